@@ -1,47 +1,148 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"sentinel/internal/agent"
 
 	"github.com/kardianos/service"
 )
 
+var configPath = flag.String("config", "", "config file (default: $"+agent.ConfigPathEnv+", /etc/sentinel or %ProgramData%\\Sentinel, the executable's directory, then the working directory)")
+
+// overrideFlags set config fields on the command line. They take precedence
+// over the SENTINEL_* environment variables and the config file.
+var overrideFlags = []struct {
+	name, field string
+	value       *string
+}{
+	{"hq-address", "hq_address", flag.String("hq-address", "", "HQ gRPC address (host:port)")},
+	{"server-id", "server_id", flag.String("server-id", "", "name this agent reports as")},
+	{"labels", "labels", flag.String("labels", "", "labels merged over the config file's, e.g. env=prod,role=db")},
+	{"collection-interval", "collection_interval", flag.String("collection-interval", "", "how often a batch is sent to HQ, e.g. 5s")},
+	{"state-dir", "state_dir", flag.String("state-dir", "", "directory for data kept across restarts")},
+}
+
 func main() {
-	// 1. Setup Service Configuration
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [install|uninstall|start|stop|restart|validate-config [path]|print-config [path]]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	flags := map[string]string{}
+	for _, f := range overrideFlags {
+		if *f.value != "" {
+			flags[f.field] = *f.value
+		}
+	}
+	src := agent.NewConfigSource(*configPath, flags)
+
+	// 1. Handle Config Commands (validate-config/print-config)
+	if len(args) > 0 {
+		if len(args) > 1 {
+			src.Path = args[1]
+		}
+		switch args[0] {
+		case "validate-config":
+			os.Exit(validateConfig(src))
+		case "print-config":
+			os.Exit(printConfig(src))
+		}
+	}
+
+	// 2. Setup Service Configuration
 	svcConfig := &service.Config{
 		Name:        "SentinelAgent",
 		DisplayName: "Sentinel Monitoring Agent",
 		Description: "Collects and streams system metrics to HQ.",
+		// The service starts in an unrelated working directory, so it gets
+		// the config file this command resolved, by absolute path.
+		Arguments: serviceArguments(src),
 	}
 
-	// 2. Initialize Internal Components
-	cfg := agent.LoadConfig()
+	// 3. Initialize Internal Components
+	cfg, err := agent.LoadConfig(src)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
 	collector := agent.NewCollector(cfg)
 	client := agent.NewClient(cfg, collector)
-	prg := agent.NewProgram(client)
+	watcher := agent.NewConfigWatcher(src, client.ApplyConfig)
+	prg := agent.NewProgram(client, watcher)
 
-	// 3. Create Service
+	// 4. Create Service
 	s, err := service.New(prg, svcConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// 4. Handle Control Actions (install/uninstall/start/stop)
-	if len(os.Args) > 1 {
-		err = service.Control(s, os.Args[1])
+	// 5. Handle Control Actions (install/uninstall/start/stop)
+	if len(args) > 0 {
+		err = service.Control(s, args[0])
 		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
-	// 5. Run Service
+	// 6. Run Service
 	err = s.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+// serviceArguments are the flags the installed service runs with.
+func serviceArguments(src *agent.ConfigSource) []string {
+	path, err := filepath.Abs(src.Path)
+	if err != nil {
+		path = src.Path
+	}
+	args := []string{"-config", path}
+	for _, f := range overrideFlags {
+		if *f.value != "" {
+			args = append(args, "-"+f.name, *f.value)
+		}
+	}
+	return args
+}
+
+// validateConfig checks a config file the way the agent would at startup and
+// reports every problem it finds.
+func validateConfig(src *agent.ConfigSource) int {
+	cfg, err := agent.ReadConfig(src)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is invalid:\n%v\n", src.Path, err)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", src.Path)
+	return 0
+}
+
+// printConfig prints the effective config: the file with defaults filled in
+// and the environment and flag overrides applied.
+func printConfig(src *agent.ConfigSource) int {
+	cfg, err := agent.LoadConfig(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
@@ -0,0 +1,362 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sentinel/internal/proto"
+)
+
+func init() {
+	RegisterPlugin("checks", func() Plugin { return &checksPlugin{} })
+}
+
+// Check types understood by the checks plugin.
+const (
+	CheckHTTP = "http"
+	CheckTCP  = "tcp"
+	CheckDNS  = "dns"
+)
+
+// maxCheckBody caps how much of an HTTP response is read for body_regex.
+const maxCheckBody = 1 << 20
+
+// CheckConfig is one synthetic check. Which fields apply depends on Type.
+type CheckConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Timeout string `json:"timeout,omitempty"`
+
+	// http
+	URL                string            `json:"url,omitempty"`
+	Method             string            `json:"method,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	Body               string            `json:"body,omitempty"`
+	ExpectStatus       []int             `json:"expect_status,omitempty"`
+	BodyRegex          string            `json:"body_regex,omitempty"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify,omitempty"`
+
+	// tcp
+	Address string `json:"address,omitempty"`
+
+	// dns
+	Host   string   `json:"host,omitempty"`
+	Record string   `json:"record,omitempty"`
+	Server string   `json:"server,omitempty"`
+	Expect []string `json:"expect,omitempty"`
+}
+
+type check struct {
+	CheckConfig
+	timeout time.Duration
+	bodyRe  *regexp.Regexp
+}
+
+// checksPlugin probes HTTP endpoints, TCP ports and DNS names. Every check
+// reports check_up and check_latency_ms tagged with its name.
+type checksPlugin struct {
+	checks []*check
+}
+
+func (p *checksPlugin) Name() string { return "checks" }
+
+func (p *checksPlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Checks []CheckConfig `json:"checks"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+
+	p.checks = nil
+	for _, cfg := range settings.Checks {
+		c, err := newCheck(cfg)
+		if err != nil {
+			return err
+		}
+		p.checks = append(p.checks, c)
+	}
+	return nil
+}
+
+func newCheck(cfg CheckConfig) (*check, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("check has no name")
+	}
+	c := &check{CheckConfig: cfg}
+	switch cfg.Type {
+	case CheckHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("check %q: http check needs a url", cfg.Name)
+		}
+		if c.Method == "" {
+			c.Method = http.MethodGet
+		}
+		if len(c.ExpectStatus) == 0 {
+			c.ExpectStatus = []int{http.StatusOK}
+		}
+		if cfg.BodyRegex != "" {
+			re, err := regexp.Compile(cfg.BodyRegex)
+			if err != nil {
+				return nil, fmt.Errorf("check %q: invalid body_regex: %w", cfg.Name, err)
+			}
+			c.bodyRe = re
+		}
+	case CheckTCP:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("check %q: tcp check needs an address", cfg.Name)
+		}
+	case CheckDNS:
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("check %q: dns check needs a host", cfg.Name)
+		}
+		switch strings.ToUpper(cfg.Record) {
+		case "":
+			c.Record = "A"
+		case "A", "AAAA", "CNAME", "TXT", "MX", "NS":
+			c.Record = strings.ToUpper(cfg.Record)
+		default:
+			return nil, fmt.Errorf("check %q: unsupported record type %q", cfg.Name, cfg.Record)
+		}
+	default:
+		return nil, fmt.Errorf("check %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("check %q: invalid timeout: %w", cfg.Name, err)
+		}
+		c.timeout = d
+	}
+	return c, nil
+}
+
+func (p *checksPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		metrics []*proto.Metric
+	)
+	for _, c := range p.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := c.run(ctx)
+			mu.Lock()
+			metrics = append(metrics, m...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return metrics, nil
+}
+
+// checkResult carries the outcome of one probe. extra holds type specific
+// metrics such as the HTTP status code.
+type checkResult struct {
+	latency time.Duration
+	err     error
+	extra   []*proto.Metric
+}
+
+func (c *check) run(ctx context.Context) []*proto.Metric {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var res checkResult
+	switch c.Type {
+	case CheckHTTP:
+		res = c.runHTTP(ctx)
+	case CheckTCP:
+		res = c.runTCP(ctx)
+	case CheckDNS:
+		res = c.runDNS(ctx)
+	}
+
+	up := 1.0
+	if res.err != nil {
+		up = 0
+		log.Printf("Check %s failed: %v", c.Name, res.err)
+	}
+	metrics := []*proto.Metric{
+		{Type: "check_up", Value: up, Tags: c.tags()},
+		{Type: "check_latency_ms", Value: round(float64(res.latency) / float64(time.Millisecond)), Tags: c.tags()},
+	}
+	for _, m := range res.extra {
+		m.Tags = c.tags()
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func (c *check) tags() map[string]string {
+	return map[string]string{"check": c.Name, "type": c.Type}
+}
+
+func (c *check) runHTTP(ctx context.Context) checkResult {
+	var body io.Reader
+	if c.Body != "" {
+		body = strings.NewReader(c.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Method, c.URL, body)
+	if err != nil {
+		return checkResult{err: err}
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+
+	// A fresh transport per check so every probe pays for (and measures) a
+	// full connect and TLS handshake instead of reusing a pooled connection.
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify},
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkResult{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+	res := checkResult{latency: time.Since(start)}
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	res.extra = append(res.extra, &proto.Metric{Type: "check_http_status", Value: float64(resp.StatusCode)})
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		expiry := time.Until(resp.TLS.PeerCertificates[0].NotAfter)
+		res.extra = append(res.extra, &proto.Metric{Type: "check_cert_expiry_days", Value: round(expiry.Hours() / 24)})
+	}
+
+	switch {
+	case !slices.Contains(c.ExpectStatus, resp.StatusCode):
+		res.err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	case c.bodyRe != nil && !c.bodyRe.Match(respBody):
+		res.err = fmt.Errorf("body does not match %q", c.BodyRegex)
+	}
+	return res
+}
+
+func (c *check) runTCP(ctx context.Context) checkResult {
+	var d net.Dialer
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", c.Address)
+	res := checkResult{latency: time.Since(start), err: err}
+	if err == nil {
+		conn.Close()
+	}
+	return res
+}
+
+func (c *check) runDNS(ctx context.Context) checkResult {
+	resolver := net.DefaultResolver
+	if c.Server != "" {
+		server := c.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	start := time.Now()
+	answers, err := lookup(ctx, resolver, c.Record, c.Host)
+	res := checkResult{latency: time.Since(start), err: err}
+	if err != nil {
+		return res
+	}
+	res.extra = append(res.extra, &proto.Metric{Type: "check_dns_answers", Value: float64(len(answers))})
+
+	for _, want := range c.Expect {
+		if !slices.ContainsFunc(answers, func(got string) bool { return sameDNSAnswer(got, want) }) {
+			res.err = fmt.Errorf("expected answer %q not in %v", want, answers)
+			break
+		}
+	}
+	return res
+}
+
+func lookup(ctx context.Context, r *net.Resolver, record, host string) ([]string, error) {
+	switch record {
+	case "A", "AAAA":
+		network := "ip4"
+		if record == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		answers := make([]string, len(ips))
+		for i, ip := range ips {
+			answers[i] = ip.String()
+		}
+		return answers, nil
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return []string{cname}, nil
+	case "TXT":
+		return r.LookupTXT(ctx, host)
+	case "MX":
+		mxs, err := r.LookupMX(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		answers := make([]string, len(mxs))
+		for i, mx := range mxs {
+			answers[i] = mx.Host
+		}
+		return answers, nil
+	case "NS":
+		nss, err := r.LookupNS(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		answers := make([]string, len(nss))
+		for i, ns := range nss {
+			answers[i] = ns.Host
+		}
+		return answers, nil
+	}
+	return nil, fmt.Errorf("unsupported record type %q", record)
+}
+
+// sameDNSAnswer compares names without regard to case or the trailing dot
+// that fully qualified answers carry.
+func sameDNSAnswer(got, want string) bool {
+	return strings.EqualFold(strings.TrimSuffix(got, "."), strings.TrimSuffix(want, "."))
+}
@@ -0,0 +1,289 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sentinel/internal/proto"
+)
+
+// runCheck runs one check and returns its metrics by type.
+func runCheck(t *testing.T, cfg CheckConfig) map[string]*proto.Metric {
+	t.Helper()
+	c, err := newCheck(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	metrics := map[string]*proto.Metric{}
+	for _, m := range c.run(ctx) {
+		if m.Tags["check"] != cfg.Name || m.Tags["type"] != cfg.Type {
+			t.Errorf("%s tags = %v, want check %s of type %s", m.Type, m.Tags, cfg.Name, cfg.Type)
+		}
+		metrics[m.Type] = m
+	}
+	return metrics
+}
+
+func wantUp(t *testing.T, metrics map[string]*proto.Metric, want bool) {
+	t.Helper()
+	up := metrics["check_up"]
+	if up == nil {
+		t.Fatalf("no check_up in %v", metrics)
+	}
+	if (up.Value == 1) != want {
+		t.Errorf("check_up = %v, want up=%t", up.Value, want)
+	}
+	if metrics["check_latency_ms"] == nil {
+		t.Error("no check_latency_ms")
+	}
+}
+
+// closedAddr returns a local TCP address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestHTTPCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Probe") != "sentinel" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("status: Healthy"))
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	headers := map[string]string{"X-Probe": "sentinel"}
+
+	for _, tc := range []struct {
+		name   string
+		cfg    CheckConfig
+		up     bool
+		status float64 // check_http_status, 0 if there is none
+	}{
+		{"success", CheckConfig{URL: srv.URL + "/health", Headers: headers, BodyRegex: "Healthy"}, true, 200},
+		{"status mismatch", CheckConfig{URL: srv.URL + "/broken"}, false, 500},
+		{"expected status", CheckConfig{URL: srv.URL + "/broken", ExpectStatus: []int{500}}, true, 500},
+		{"body mismatch", CheckConfig{URL: srv.URL + "/health", Headers: headers, BodyRegex: "^Ready$"}, false, 200},
+		{"timeout", CheckConfig{URL: srv.URL + "/slow", Timeout: "100ms"}, false, 0},
+		{"refused", CheckConfig{URL: "http://" + closedAddr(t) + "/health"}, false, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Name, tc.cfg.Type = "web", CheckHTTP
+			start := time.Now()
+			metrics := runCheck(t, tc.cfg)
+			wantUp(t, metrics, tc.up)
+			status := metrics["check_http_status"]
+			switch {
+			case tc.status == 0 && status != nil:
+				t.Errorf("check_http_status = %v, want none", status.Value)
+			case tc.status != 0 && (status == nil || status.Value != tc.status):
+				t.Errorf("check_http_status = %v, want %v", status, tc.status)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("check took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckCertExpiry(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	metrics := runCheck(t, CheckConfig{Name: "tls", Type: CheckHTTP, URL: srv.URL, InsecureSkipVerify: true})
+	wantUp(t, metrics, true)
+	if m := metrics["check_cert_expiry_days"]; m == nil || m.Value <= 0 {
+		t.Errorf("check_cert_expiry_days = %v, want a positive number", m)
+	}
+
+	// Without insecure_skip_verify the self-signed certificate is refused.
+	wantUp(t, runCheck(t, CheckConfig{Name: "tls", Type: CheckHTTP, URL: srv.URL}), false)
+}
+
+func TestTCPCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	for _, tc := range []struct {
+		name string
+		cfg  CheckConfig
+		up   bool
+	}{
+		{"success", CheckConfig{Address: l.Addr().String()}, true},
+		{"refused", CheckConfig{Address: closedAddr(t)}, false},
+		{"timeout", CheckConfig{Address: l.Addr().String(), Timeout: "1ns"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Name, tc.cfg.Type = "db", CheckTCP
+			wantUp(t, runCheck(t, tc.cfg), tc.up)
+		})
+	}
+}
+
+// dnsServer answers A queries over UDP from records, with NXDOMAIN for other
+// names. With silent it reads queries but never answers.
+func dnsServer(t *testing.T, records map[string][]net.IP, silent bool) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsAnswer(buf[:n], records); resp != nil && !silent {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// dnsAnswer builds the response to a single-question query.
+func dnsAnswer(query []byte, records map[string][]net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// The question is the name's labels up to a zero byte, then type and class.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	end := i + 5
+	if end > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+
+	var ips []net.IP
+	if qtype == 1 { // A
+		ips = records[name]
+	}
+	_, known := records[name]
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	flags := uint16(0x8180) // response, recursion desired and available
+	if !known {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(ips)))
+	resp = append(resp, query[12:end]...)
+	for _, ip := range ips {
+		resp = append(resp, 0xc0, 12) // pointer to the question's name
+		resp = binary.BigEndian.AppendUint16(resp, 1)
+		resp = binary.BigEndian.AppendUint16(resp, 1)
+		resp = binary.BigEndian.AppendUint32(resp, 60)
+		resp = binary.BigEndian.AppendUint16(resp, 4)
+		resp = append(resp, ip.To4()...)
+	}
+	return resp
+}
+
+func TestDNSCheck(t *testing.T) {
+	records := map[string][]net.IP{
+		"app.example.test": {net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)},
+	}
+	server := dnsServer(t, records, false)
+	silent := dnsServer(t, records, true)
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.LocalAddr().String()
+	closed.Close()
+
+	for _, tc := range []struct {
+		name    string
+		cfg     CheckConfig
+		up      bool
+		answers float64 // check_dns_answers, -1 if there is none
+	}{
+		{"success", CheckConfig{Host: "app.example.test.", Server: server, Expect: []string{"10.0.0.2"}}, true, 2},
+		{"answer mismatch", CheckConfig{Host: "app.example.test.", Server: server, Expect: []string{"10.0.0.9"}}, false, 2},
+		{"no such name", CheckConfig{Host: "missing.example.test.", Server: server}, false, -1},
+		{"timeout", CheckConfig{Host: "app.example.test.", Server: silent, Timeout: "200ms"}, false, -1},
+		{"refused", CheckConfig{Host: "app.example.test.", Server: refused, Timeout: "2s"}, false, -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Name, tc.cfg.Type = "dns", CheckDNS
+			metrics := runCheck(t, tc.cfg)
+			wantUp(t, metrics, tc.up)
+			answers := metrics["check_dns_answers"]
+			switch {
+			case tc.answers < 0 && answers != nil:
+				t.Errorf("check_dns_answers = %v, want none", answers.Value)
+			case tc.answers >= 0 && (answers == nil || answers.Value != tc.answers):
+				t.Errorf("check_dns_answers = %v, want %v", answers, tc.answers)
+			}
+		})
+	}
+}
+
+func TestNewCheckErrors(t *testing.T) {
+	for _, cfg := range []CheckConfig{
+		{Type: CheckHTTP, URL: "http://localhost"},
+		{Name: "a", Type: "icmp"},
+		{Name: "a", Type: CheckHTTP},
+		{Name: "a", Type: CheckHTTP, URL: "http://localhost", BodyRegex: "("},
+		{Name: "a", Type: CheckTCP},
+		{Name: "a", Type: CheckDNS},
+		{Name: "a", Type: CheckDNS, Host: "example.com", Record: "SRV"},
+		{Name: "a", Type: CheckTCP, Address: "localhost:1", Timeout: "soon"},
+	} {
+		if _, err := newCheck(cfg); err == nil {
+			t.Errorf("newCheck(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
@@ -2,12 +2,21 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"os"
 	"sentinel/internal/proto"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
 )
 
 type Client struct {
@@ -15,37 +24,259 @@ type Client struct {
 	Collector *Collector
 	Conn      *grpc.ClientConn
 	Stream    proto.Sentinel_StreamMetricsClient
+
+	// machineID tells HQ which host is behind the server_id.
+	machineID string
+
+	mu        sync.Mutex
+	local     *Config             // from the config file, before HQ's overlay
+	remote    *proto.ConfigUpdate // the config from HQ in effect, if any
+	reconnect context.CancelFunc
 }
 
 func NewClient(cfg *Config, collector *Collector) *Client {
 	return &Client{
 		Config:    cfg,
 		Collector: collector,
+		local:     cfg,
 	}
 }
 
+func (c *Client) currentConfig() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Config
+}
+
 func (c *Client) Start(ctx context.Context) error {
-	log.Printf("Connecting to HQ at %s...", c.Config.HQAddress)
-	// For production, use credentials (TLS). For now, insecure is fine.
-	conn, err := grpc.NewClient(c.Config.HQAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	id, err := MachineID(c.currentConfig().StateDir)
 	if err != nil {
-		return err
+		log.Printf("Error determining machine id: %v", err)
 	}
+	c.machineID = id
+
+	// Plugins keep collecting while the stream is down; the buffered metrics
+	// go out with the next batch.
+	go c.Collector.Run(ctx)
+
+	// Each pass is one connection to HQ. A config reload that changes the
+	// address cancels connCtx and the loop dials the new one.
+	for {
+		connCtx, cancel := context.WithCancel(ctx)
+		c.mu.Lock()
+		c.reconnect = cancel
+		c.mu.Unlock()
+
+		c.connect(connCtx, c.currentConfig())
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// connect streams to HQ until ctx is cancelled, retrying the stream
+// whenever it fails.
+func (c *Client) connect(ctx context.Context, cfg *Config) {
+	log.Printf("Connecting to HQ at %s...", cfg.HQAddress)
+	conn, err := dial(cfg)
+	if err != nil {
+		// Only a malformed address or an unreadable CA file gets here;
+		// retrying won't help until the config is fixed.
+		log.Printf("Invalid HQ connection settings for %q: %v. Waiting for a config change...", cfg.HQAddress, err)
+		<-ctx.Done()
+		return
+	}
+	defer conn.Close()
+	c.mu.Lock()
 	c.Conn = conn
+	c.mu.Unlock()
 	client := proto.NewSentinelClient(conn)
 
+	go c.watchConfig(ctx, client)
+
 	// Retry loop for stream connection
-	for {
+	for ctx.Err() == nil {
+		if err := c.streamMetrics(ctx, client); err != nil && ctx.Err() == nil {
+			log.Printf("Stream error: %v. Retrying in 5s...", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// ApplyConfig takes a new local config, which the caller has validated. A
+// config from HQ stays in effect on top of it.
+func (c *Client) ApplyConfig(cfg *Config) {
+	c.mu.Lock()
+	c.local = cfg
+	remote := c.remote
+	c.mu.Unlock()
+
+	effective := cfg
+	if remote != nil && remote.Profile != "" {
+		merged, err := cfg.WithOverlay(remote.ConfigJson)
+		if err == nil {
+			err = merged.Validate()
+		}
+		if err != nil {
+			log.Printf("Config from HQ (%s v%d) no longer applies over the local config, using the local config alone: %v",
+				remote.Profile, remote.Version, err)
+		} else {
+			effective = merged
+		}
+	}
+	c.apply(effective)
+}
+
+// apply switches the agent to cfg. The collector picks up plugin changes
+// right away; the connection to HQ is only re-established when its settings
+// changed.
+func (c *Client) apply(cfg *Config) {
+	c.mu.Lock()
+	old := c.Config
+	c.Config = cfg
+	reconnect := c.reconnect
+	c.mu.Unlock()
+
+	changes := DiffConfig(old, cfg)
+	if len(changes) == 0 {
+		log.Println("Config reloaded, nothing changed")
+		return
+	}
+	for _, change := range changes {
+		log.Printf("Config changed: %s", change)
+	}
+
+	c.Collector.Apply(cfg)
+	if connectionChanged(old, cfg) && reconnect != nil {
+		log.Println("HQ connection settings changed, reconnecting...")
+		reconnect()
+	}
+}
+
+// watchConfig receives the config managed in HQ for as long as ctx lasts,
+// re-opening the stream when it breaks.
+func (c *Client) watchConfig(ctx context.Context, client proto.SentinelClient) {
+	for ctx.Err() == nil {
+		err := c.receiveConfig(ctx, client)
+		if status.Code(err) == codes.Unimplemented {
+			log.Println("HQ does not serve agent configs, using the local config only")
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Config stream error: %v. Retrying in 5s...", err)
+		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			if err := c.streamMetrics(ctx, client); err != nil {
-				log.Printf("Stream error: %v. Retrying in 5s...", err)
-				time.Sleep(5 * time.Second)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *Client) receiveConfig(ctx context.Context, client proto.SentinelClient) error {
+	stream, err := client.WatchConfig(ctx, &proto.ConfigRequest{ServerId: c.currentConfig().ServerID})
+	if err != nil {
+		return err
+	}
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		c.applyRemote(ctx, client, update)
+	}
+}
+
+// applyRemote merges a config from HQ over the local one and reports the
+// outcome back, so HQ can tell which agents are out of date.
+func (c *Client) applyRemote(ctx context.Context, client proto.SentinelClient, update *proto.ConfigUpdate) {
+	c.mu.Lock()
+	local, current := c.local, c.remote
+	c.mu.Unlock()
+
+	report := &proto.ConfigStatus{
+		ServerId: local.ServerID,
+		Profile:  update.Profile,
+		Version:  update.Version,
+	}
+	unchanged := update.Profile == current.GetProfile() && update.Version == current.GetVersion()
+	if unchanged {
+		// Already running it, e.g. after a reconnect.
+		report.Applied = true
+		report.ConfigHash = c.currentConfig().Hash()
+	} else {
+		cfg := local
+		var err error
+		if update.Profile != "" {
+			cfg, err = local.WithOverlay(update.ConfigJson)
+		}
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			log.Printf("Rejected config %s v%d from HQ: %v", update.Profile, update.Version, err)
+			report.Error = err.Error()
+		} else {
+			if update.Profile == "" {
+				log.Println("No config from HQ applies any more, using the local config")
+			} else {
+				log.Printf("Applying config %s v%d from HQ", update.Profile, update.Version)
 			}
+			c.mu.Lock()
+			c.remote = update
+			c.mu.Unlock()
+			c.apply(cfg)
+			report.Applied = true
+			report.ConfigHash = cfg.Hash()
 		}
 	}
+
+	rctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := client.ReportConfigStatus(rctx, report); err != nil {
+		log.Printf("Error reporting config status: %v", err)
+	}
+}
+
+// dial creates the gRPC connection to HQ, over TLS if hq_tls is enabled.
+func dial(cfg *Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.HQTLS.Enabled {
+		tlsConfig := &tls.Config{
+			ServerName:         cfg.HQTLS.ServerName,
+			InsecureSkipVerify: cfg.HQTLS.InsecureSkipVerify,
+		}
+		if cfg.HQTLS.CAFile != "" {
+			pool, err := cfg.HQTLS.certPool()
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(cfg.HQAddress, grpc.WithTransportCredentials(creds))
+}
+
+// certPool loads the CA certificates in CAFile.
+func (t HQTLSConfig) certPool() (*x509.CertPool, error) {
+	pem, err := os.ReadFile(t.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+	}
+	return pool, nil
+}
+
+// connectionChanged reports whether the settings used to dial HQ differ.
+func connectionChanged(old, new *Config) bool {
+	return old.HQAddress != new.HQAddress || old.HQTLS != new.HQTLS
 }
 
 func (c *Client) streamMetrics(ctx context.Context, client proto.SentinelClient) error {
@@ -53,27 +284,55 @@ func (c *Client) streamMetrics(ctx context.Context, client proto.SentinelClient)
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
 	c.Stream = stream
+	c.mu.Unlock()
 	log.Println("Connected to HQ. Streaming metrics...")
 
-	ticker := time.NewTicker(c.Config.CollectionInterval)
+	interval := c.currentConfig().CollectionInterval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Host info goes out with the first batch of every stream, then again
+	// only when it changes. A config reload is checked for right away, so
+	// new labels reach HQ with the next batch.
+	var sentHostInfo *proto.HostInfo
+	var hostInfoCheckedAt time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
-			return c.Stream.CloseSend()
+			return stream.CloseSend()
 		case <-ticker.C:
+			cfg := c.currentConfig()
+			if cfg.CollectionInterval != interval {
+				interval = cfg.CollectionInterval
+				ticker.Reset(interval)
+			}
 			batch := c.Collector.Collect()
-			if err := c.Stream.Send(batch); err != nil {
+			batch.MachineId = c.machineID
+			if sentHostInfo == nil || sentHostInfo.ConfigHash != cfg.Hash() || time.Since(hostInfoCheckedAt) >= hostInfoRefresh {
+				hostInfoCheckedAt = time.Now()
+				if info, err := CollectHostInfo(ctx, cfg); err != nil {
+					log.Printf("Error collecting host info: %v", err)
+				} else if !gproto.Equal(info, sentHostInfo) {
+					batch.HostInfo = info
+				}
+			}
+			if err := stream.Send(batch); err != nil {
 				return err
 			}
+			if batch.HostInfo != nil {
+				sentHostInfo = batch.HostInfo
+			}
 			log.Printf("Sent batch with %d metrics", len(batch.Metrics))
 		}
 	}
 }
 
 func (c *Client) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.Stream != nil {
 		c.Stream.CloseSend()
 	}
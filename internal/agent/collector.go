@@ -1,147 +1,313 @@
 package agent
 
 import (
+	"context"
 	"log"
 	"math"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"sentinel/internal/proto"
 
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/disk"
-	"github.com/shirou/gopsutil/v4/mem"
-	"github.com/shirou/gopsutil/v4/process"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
+
+// maxPendingMetrics and maxPendingEvents bound what is buffered between
+// batches, e.g. while HQ is unreachable. The oldest entries are dropped first.
+const (
+	maxPendingMetrics = 10000
+	maxPendingEvents  = 1000
+)
+
+// Collector runs the enabled plugins, each on its own interval, and buffers
+// their metrics until the next batch is sent.
 type Collector struct {
 	Config *Config
+
+	runMu   sync.Mutex
+	runCtx  context.Context
+	runners map[string]*pluginRunner
+	wg      sync.WaitGroup
+
+	mu            sync.Mutex
+	pending       []*proto.Metric
+	pendingEvents []*proto.Event
 }
+
 func NewCollector(cfg *Config) *Collector {
-	return &Collector{Config: cfg}
+	c := &Collector{Config: cfg, runners: map[string]*pluginRunner{}}
+	for _, name := range cfg.EnabledPlugins() {
+		if r := newPluginRunner(name, cfg); r != nil {
+			c.runners[name] = r
+		}
+	}
+	return c
+}
+
+// newPluginRunner creates and initialises the named plugin from cfg. Problems
+// are logged and leave the plugin out rather than stopping the agent.
+func newPluginRunner(name string, cfg *Config) *pluginRunner {
+	pc := cfg.Plugins[name]
+	pc.StateDir = cfg.StateDir
+	plugin, ok := NewPlugin(name)
+	if !ok {
+		log.Printf("Unknown plugin %q in config, skipping", name)
+		return nil
+	}
+	if err := plugin.Init(pc); err != nil {
+		log.Printf("Failed to initialise plugin %q: %v", name, err)
+		return nil
+	}
+	interval, timeout := pluginTiming(pc, cfg)
+	return &pluginRunner{
+		plugin:   plugin,
+		config:   pc,
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// pluginTiming returns how often a plugin runs and how long each Collect may
+// take. Both default from the agent-wide collection interval.
+func pluginTiming(pc PluginConfig, cfg *Config) (interval, timeout time.Duration) {
+	interval = pc.Interval
+	if interval <= 0 {
+		interval = cfg.CollectionInterval
+	}
+	timeout = pc.Timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+	return interval, timeout
+}
+
+// Run starts every plugin and blocks until ctx is cancelled.
+func (c *Collector) Run(ctx context.Context) {
+	c.runMu.Lock()
+	c.runCtx = ctx
+	for _, r := range c.runners {
+		c.start(r)
+	}
+	c.runMu.Unlock()
+
+	<-ctx.Done()
+	c.wg.Wait()
 }
 
+// start runs r until it is stopped or the Collector's context ends. The
+// caller holds runMu.
+func (c *Collector) start(r *pluginRunner) {
+	ctx, cancel := context.WithCancel(c.runCtx)
+	r.stop = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		r.run(ctx, c.add, c.addEvents)
+	}()
+}
+
+// Apply switches to a new configuration. Plugins whose settings didn't change
+// keep running undisturbed; the others are stopped, re-created or started.
+// Metrics already buffered are kept.
+func (c *Collector) Apply(cfg *Config) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	enabled := map[string]bool{}
+	for _, name := range cfg.EnabledPlugins() {
+		enabled[name] = true
+		old := c.runners[name]
+		if old != nil && old.sameSetup(name, cfg) {
+			continue
+		}
+		r := newPluginRunner(name, cfg)
+		if old != nil {
+			c.stopRunner(name, old)
+		}
+		if r == nil {
+			continue
+		}
+		c.runners[name] = r
+		if c.runCtx != nil {
+			c.start(r)
+		}
+	}
+	for name, r := range c.runners {
+		if !enabled[name] {
+			c.stopRunner(name, r)
+		}
+	}
+
+	c.mu.Lock()
+	c.Config = cfg
+	c.mu.Unlock()
+}
+
+func (c *Collector) stopRunner(name string, r *pluginRunner) {
+	if r.stop != nil {
+		r.stop()
+	}
+	delete(c.runners, name)
+}
+
+// Collect returns everything collected since the previous call as a batch.
 func (c *Collector) Collect() *proto.MetricBatch {
-	batch := &proto.MetricBatch{
-		ServerId:  c.Config.ServerID,
+	c.mu.Lock()
+	metrics := c.pending
+	events := c.pendingEvents
+	c.pending = nil
+	c.pendingEvents = nil
+	serverID := c.Config.ServerID
+	c.mu.Unlock()
+
+	return &proto.MetricBatch{
+		ServerId:  serverID,
 		Timestamp: timestamppb.Now(),
-		Metrics:   []*proto.Metric{},
-	}
-
-	// 1. CPU Usage
-	percent, err := cpu.Percent(0, false)
-	if err == nil && len(percent) > 0 {
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "cpu_usage",
-			Value: round(percent[0]),
-			Tags:  map[string]string{"unit": "percent"},
-		})
-	} else {
-		log.Printf("Error getting CPU: %v", err)
-	}
-
-	// 2. Memory Usage
-	v, err := mem.VirtualMemory()
-	if err == nil {
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "memory_used_percent",
-			Value: round(v.UsedPercent),
-			Tags:  map[string]string{"unit": "percent"},
-		})
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "memory_free_mb",
-			Value: float64(v.Free) / 1024 / 1024,
-			Tags:  map[string]string{"unit": "mb"},
-		})
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "memory_total_mb",
-			Value: float64(v.Total) / 1024 / 1024,
-			Tags:  map[string]string{"unit": "mb"},
-		})
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "memory_available_mb",
-			Value: float64(v.Available) / 1024 / 1024,
-			Tags:  map[string]string{"unit": "mb"},
-		})
-	} else {
-		log.Printf("Error getting Memory: %v", err)
-	}
-
-	// 3. Disk Usage (Root)
-	d, err := disk.Usage("/")
-	if err == nil {
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "disk_free_gb",
-			Value: float64(d.Free) / 1024 / 1024 / 1024,
-			Tags:  map[string]string{"unit": "gb", "path": "/"},
-		})
-		batch.Metrics = append(batch.Metrics, &proto.Metric{
-			Type:  "disk_used_percent",
-			Value: round(d.UsedPercent),
-			Tags:  map[string]string{"unit": "percent", "path": "/"},
-		})
-	} else {
-		log.Printf("Error getting Disk: %v", err)
-	}
-
-	// 4. Service Monitoring
-	if len(c.Config.Services) > 0 {
-		procs, err := process.Processes()
-		if err == nil {
-			// Create a set of services for fast lookup
-			servicesToMonitor := make(map[string]bool)
-			for _, s := range c.Config.Services {
-				servicesToMonitor[strings.ToLower(s)] = true
-			}
+		Metrics:   latestOnly(metrics),
+		Events:    events,
+	}
+}
 
-			for _, p := range procs {
-				name, err := p.Name()
-				if err != nil {
-					continue
-				}
-				nameLower := strings.ToLower(name)
-
-				// Simple contains check (e.g. "postgres.exe" contains "postgres")
-				foundService := ""
-				for s := range servicesToMonitor {
-					if strings.Contains(nameLower, s) {
-						foundService = s
-						break
-					}
-				}
-
-				if foundService != "" {
-					// Found a monitored service!
-					cpuPercent, _ := p.CPUPercent()
-					memInfo, _ := p.MemoryInfo()
-
-					batch.Metrics = append(batch.Metrics, &proto.Metric{
-						Type:  "service_cpu",
-						Value: round(cpuPercent),
-						Tags:  map[string]string{"service": foundService, "pid": string(rune(p.Pid))},
-					})
-
-					if memInfo != nil {
-						batch.Metrics = append(batch.Metrics, &proto.Metric{
-							Type:  "service_memory_mb",
-							Value: float64(memInfo.RSS) / 1024 / 1024,
-							Tags:  map[string]string{"service": foundService},
-						})
-					}
-
-					// We could also report "up" status implicitly by the presence of these metrics
-					batch.Metrics = append(batch.Metrics, &proto.Metric{
-						Type:  "service_status",
-						Value: 1, // 1 = Up
-						Tags:  map[string]string{"service": foundService},
-					})
-				}
-			}
+// latestOnly keeps the most recent sample of each series. A batch carries a
+// single timestamp, so older samples of the same series would only collide.
+func latestOnly(metrics []*proto.Metric) []*proto.Metric {
+	index := make(map[string]int, len(metrics))
+	out := make([]*proto.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		key := seriesKey(m)
+		if i, ok := index[key]; ok {
+			out[i] = m
+			continue
 		}
+		index[key] = len(out)
+		out = append(out, m)
+	}
+	return out
+}
+
+func seriesKey(m *proto.Metric) string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(m.Type)
+	for _, k := range keys {
+		b.WriteString("\x00")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(m.Tags[k])
 	}
+	return b.String()
+}
 
-	return batch
+func (c *Collector) add(metrics []*proto.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, metrics...)
+	if over := len(c.pending) - maxPendingMetrics; over > 0 {
+		log.Printf("Metric buffer full, dropping %d oldest metrics", over)
+		c.pending = append([]*proto.Metric(nil), c.pending[over:]...)
+	}
+}
+
+func (c *Collector) addEvents(events []*proto.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pendingEvents = append(c.pendingEvents, events...)
+	if over := len(c.pendingEvents) - maxPendingEvents; over > 0 {
+		log.Printf("Event buffer full, dropping %d oldest events", over)
+		c.pendingEvents = append([]*proto.Event(nil), c.pendingEvents[over:]...)
+	}
+}
+
+// pluginRunner isolates one plugin: a slow, hung or panicking Collect only
+// costs that plugin its samples.
+type pluginRunner struct {
+	plugin   Plugin
+	config   PluginConfig
+	interval time.Duration
+	timeout  time.Duration
+	busy     atomic.Bool
+	stop     context.CancelFunc
+}
+
+// sameSetup reports whether cfg would run the named plugin exactly as r does.
+func (r *pluginRunner) sameSetup(name string, cfg *Config) bool {
+	pc := cfg.Plugins[name]
+	interval, timeout := pluginTiming(pc, cfg)
+	return r.config.equal(pc) && r.config.StateDir == cfg.StateDir &&
+		r.interval == interval && r.timeout == timeout
+}
+
+func (r *pluginRunner) run(ctx context.Context, emit func([]*proto.Metric), emitEvents func([]*proto.Event)) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.collectOnce(ctx, emit, emitEvents)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.collectOnce(ctx, emit, emitEvents)
+		}
+	}
+}
+
+func (r *pluginRunner) collectOnce(ctx context.Context, emit func([]*proto.Metric), emitEvents func([]*proto.Event)) {
+	name := r.plugin.Name()
+	// A previous Collect that ignored its timeout is still running; don't
+	// pile another one on top of it.
+	if !r.busy.CompareAndSwap(false, true) {
+		log.Printf("Plugin %s is still busy, skipping this interval", name)
+		return
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	// The goroutine hands over what it collected even if Collect returns
+	// after its timeout, unless the plugin has been stopped since.
+	done := make(chan struct{})
+	go func() {
+		defer r.busy.Store(false)
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Plugin %s failed: panic: %v", name, p)
+			}
+		}()
+		metrics, err := r.plugin.Collect(cctx)
+		if err != nil {
+			log.Printf("Plugin %s failed: %v", name, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if len(metrics) > 0 {
+			emit(metrics)
+		}
+		if src, ok := r.plugin.(EventSource); ok {
+			if events := src.DrainEvents(); len(events) > 0 {
+				emitEvents(events)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-cctx.Done():
+		if ctx.Err() == nil {
+			log.Printf("Plugin %s timed out after %s", name, r.timeout)
+		}
+	}
 }
 
 func round(val float64) float64 {
 	return math.Round(val*100) / 100
-}
\ No newline at end of file
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"sentinel/internal/proto"
+)
+
+// slowPlugin ignores its context and returns one metric after delay.
+type slowPlugin struct {
+	delay time.Duration
+}
+
+func (p *slowPlugin) Name() string                   { return "slow" }
+func (p *slowPlugin) Init(config PluginConfig) error { return nil }
+func (p *slowPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	time.Sleep(p.delay)
+	return []*proto.Metric{{Type: "slow", Value: 1}}, nil
+}
+
+func TestCollectOnceKeepsLateResults(t *testing.T) {
+	r := &pluginRunner{plugin: &slowPlugin{delay: 200 * time.Millisecond}, timeout: 50 * time.Millisecond}
+	var (
+		mu      sync.Mutex
+		emitted []*proto.Metric
+	)
+	emit := func(m []*proto.Metric) {
+		mu.Lock()
+		defer mu.Unlock()
+		emitted = append(emitted, m...)
+	}
+
+	r.collectOnce(context.Background(), emit, func([]*proto.Event) {})
+	if !r.busy.Load() {
+		t.Fatal("runner isn't busy with the timed-out Collect")
+	}
+	// Another interval is skipped while the first Collect still runs.
+	r.collectOnce(context.Background(), emit, func([]*proto.Event) {})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.busy.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(emitted) != 1 {
+		t.Errorf("emitted %d metrics, want the late Collect's 1", len(emitted))
+	}
+}
+
+func TestCollectOnceDropsResultsOfStoppedPlugin(t *testing.T) {
+	r := &pluginRunner{plugin: &slowPlugin{delay: 100 * time.Millisecond}, timeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	emitted := 0
+	r.collectOnce(ctx, func(m []*proto.Metric) { emitted += len(m) }, func([]*proto.Event) {})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.busy.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if emitted != 0 {
+		t.Errorf("emitted %d metrics after the plugin was stopped", emitted)
+	}
+}
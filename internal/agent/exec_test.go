@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"sentinel/internal/proto"
+)
+
+func newExecPlugin(t *testing.T, commands ...ExecCommand) *execPlugin {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need sh")
+	}
+	settings, err := json.Marshal(map[string]any{"commands": commands})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &execPlugin{}
+	if err := p.Init(PluginConfig{Settings: settings}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// byCommand indexes metrics by their command tag and type.
+func byCommand(metrics []*proto.Metric) map[string]map[string]*proto.Metric {
+	out := map[string]map[string]*proto.Metric{}
+	for _, m := range metrics {
+		cmd := m.Tags["command"]
+		if out[cmd] == nil {
+			out[cmd] = map[string]*proto.Metric{}
+		}
+		out[cmd][m.Type] = m
+	}
+	return out
+}
+
+func TestExecSlowCommandDoesNotHoldUpOthers(t *testing.T) {
+	p := newExecPlugin(t,
+		ExecCommand{Name: "fast", Command: "echo fast_value 1", Shell: true},
+		// Its own timeout is longer than the plugin's, so it runs on in the
+		// background instead of being killed with the plugin's context.
+		ExecCommand{Name: "slow", Command: "sleep 0.5; echo slow_value 2", Shell: true, Timeout: "5s"},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	start := time.Now()
+	metrics, err := p.Collect(ctx)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Collect took %s, want it not to wait for the slow command", elapsed)
+	}
+	got := byCommand(metrics)
+	if got["fast"]["fast_value"] == nil || got["fast"]["exec_status"] == nil {
+		t.Errorf("first Collect = %v, want the fast command's metrics", got)
+	}
+	if got["slow"] != nil {
+		t.Errorf("first Collect has the slow command's metrics %v before it finished", got["slow"])
+	}
+
+	// While the slow command runs it isn't started again.
+	if !p.jobs[1].running.Load() {
+		t.Fatal("slow command isn't running")
+	}
+
+	time.Sleep(time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	metrics, err = p.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = byCommand(metrics)
+	if m := got["slow"]["slow_value"]; m == nil || m.Value != 2 {
+		t.Errorf("second Collect = %v, want the slow command's metrics", got)
+	}
+	if m := got["slow"]["exec_status"]; m == nil || m.Value != 0 {
+		t.Errorf("slow exec_status = %v, want 0", m)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	p := newExecPlugin(t, ExecCommand{Name: "hung", Command: "sleep 5", Shell: true, Timeout: "100ms"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	metrics, err := p.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := byCommand(metrics)["hung"]["exec_status"]; m == nil || m.Value != nagiosUnknown {
+		t.Errorf("exec_status = %v, want %d for a timeout", m, nagiosUnknown)
+	}
+}
+
+func TestExecOutputFormats(t *testing.T) {
+	p := newExecPlugin(t,
+		ExecCommand{Name: "lines", Command: "printf 'depth 3 queue=a\\ndepth 5 queue=b\\n'", Shell: true, Tags: map[string]string{"team": "ops"}},
+		ExecCommand{Name: "json", Command: `echo '{"metrics":[{"name":"licenses","value":7}]}'`, Shell: true, Format: FormatJSON},
+		ExecCommand{Name: "nagios", Command: "echo 'DISK WARNING | /=80%;70;90'; exit 1", Shell: true, Format: FormatNagios},
+	)
+
+	metrics, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var depths []string
+	for _, m := range metrics {
+		if m.Type == "depth" {
+			if m.Tags["team"] != "ops" || m.Tags["command"] != "lines" {
+				t.Errorf("depth tags = %v, want the command's tags", m.Tags)
+			}
+			depths = append(depths, m.Tags["queue"])
+		}
+	}
+	if len(depths) != 2 {
+		t.Errorf("depth series = %v, want queue a and b", depths)
+	}
+
+	got := byCommand(metrics)
+	if m := got["json"]["licenses"]; m == nil || m.Value != 7 {
+		t.Errorf("json metric = %v, want licenses 7", m)
+	}
+	status := got["nagios"]["exec_status"]
+	if status == nil || status.Value != nagiosWarning || status.Tags["state"] != "warning" {
+		t.Errorf("nagios exec_status = %v, want 1 with state warning", status)
+	}
+	if m := got["nagios"]["perfdata"]; m == nil || m.Value != 80 || m.Tags["unit"] != "%" {
+		t.Errorf("nagios perfdata = %v, want 80%%", m)
+	}
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"sentinel/internal/proto"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/mem"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Version is the agent's version, set at build time with
+// -ldflags "-X sentinel/internal/agent.Version=1.2.3".
+var Version = "dev"
+
+// hostInfoRefresh is how often host inventory is re-read to spot changes
+// such as a kernel update or added memory.
+const hostInfoRefresh = 10 * time.Minute
+
+// CollectHostInfo gathers the host inventory reported to HQ.
+func CollectHostInfo(ctx context.Context, cfg *Config) (*proto.HostInfo, error) {
+	h, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := &proto.HostInfo{
+		Hostname:          h.Hostname,
+		Os:                h.OS,
+		Platform:          h.Platform,
+		PlatformFamily:    h.PlatformFamily,
+		PlatformVersion:   h.PlatformVersion,
+		KernelVersion:     h.KernelVersion,
+		KernelArch:        h.KernelArch,
+		BootTime:          timestamppb.New(time.Unix(int64(h.BootTime), 0)),
+		AgentVersion:      Version,
+		ConfigHash:        cfg.Hash(),
+		Labels:            cfg.Labels,
+		ServiceIntervalMs: serviceInterval(cfg).Milliseconds(),
+	}
+
+	// CPU and memory details are best effort; the rest is still worth sending.
+	if cpus, err := cpu.InfoWithContext(ctx); err == nil && len(cpus) > 0 {
+		info.CpuModel = cpus[0].ModelName
+	}
+	if n, err := cpu.CountsWithContext(ctx, true); err == nil {
+		info.CpuCount = int32(n)
+	}
+	if v, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		info.MemoryTotalBytes = v.Total
+	}
+	return info, nil
+}
+
+// serviceInterval is how often the services plugin reports service_status,
+// so HQ can tell a service that stopped reporting from one between reports.
+func serviceInterval(cfg *Config) time.Duration {
+	pc, ok := cfg.Plugins["services"]
+	if !ok || !pc.IsEnabled() {
+		return 0
+	}
+	interval, _ := pluginTiming(pc, cfg)
+	return interval
+}
@@ -0,0 +1,348 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+
+	"sentinel/internal/proto"
+)
+
+func init() {
+	RegisterPlugin("logs", func() Plugin { return &logsPlugin{} })
+}
+
+const (
+	// logStateFile holds the read offsets, inside the agent's state dir.
+	logStateFile = "logtail.json"
+	// fingerprintSize is how much of a file's head identifies it. When the
+	// head changes the file has been rotated or rewritten.
+	fingerprintSize = 256
+	// maxLogRead caps how much of one file is read per interval, so a burst
+	// of logging can't stall the plugin. The rest is read next interval.
+	maxLogRead = 8 << 20
+	// maxLogLine caps the length of a single line; longer lines are cut.
+	maxLogLine = 64 << 10
+)
+
+// LogFileConfig is one file followed by the logs plugin.
+type LogFileConfig struct {
+	Path string `json:"path"`
+	// Patterns maps a pattern name (the "pattern" tag) to a regex.
+	Patterns map[string]string `json:"patterns"`
+	// SendLines is how many matching lines per interval are sent as events.
+	SendLines int `json:"send_lines,omitempty"`
+	// FromBeginning reads a file seen for the first time from the start
+	// instead of only following new lines.
+	FromBeginning bool `json:"from_beginning,omitempty"`
+	// Severity of the events sent for matching lines, "warning" by default.
+	Severity string `json:"severity,omitempty"`
+}
+
+type logPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+type logFile struct {
+	LogFileConfig
+	patterns []logPattern
+	severity proto.Severity
+}
+
+// logOffset is the persisted read position of one file.
+type logOffset struct {
+	Offset         int64  `json:"offset"`
+	Fingerprint    string `json:"fingerprint"`
+	FingerprintLen int    `json:"fingerprint_len"`
+}
+
+// logsPlugin follows log files and counts lines matching named patterns.
+// Offsets survive restarts; rotation and truncation restart a file from the
+// beginning.
+type logsPlugin struct {
+	files     []*logFile
+	statePath string
+
+	mu      sync.Mutex
+	offsets map[string]logOffset
+	events  []*proto.Event
+}
+
+func (p *logsPlugin) Name() string { return "logs" }
+
+func (p *logsPlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Files []LogFileConfig `json:"files"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+
+	p.files = nil
+	for _, fc := range settings.Files {
+		if fc.Path == "" {
+			return errors.New("log file entry has no path")
+		}
+		f := &logFile{LogFileConfig: fc, severity: proto.Severity_SEVERITY_WARNING}
+		if fc.Severity != "" {
+			sev, err := ParseSeverity(fc.Severity)
+			if err != nil {
+				return fmt.Errorf("log %s: %w", fc.Path, err)
+			}
+			f.severity = sev
+		}
+		for name, expr := range fc.Patterns {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("log %s: pattern %q: %w", fc.Path, name, err)
+			}
+			f.patterns = append(f.patterns, logPattern{name: name, re: re})
+		}
+		sort.Slice(f.patterns, func(i, j int) bool { return f.patterns[i].name < f.patterns[j].name })
+		p.files = append(p.files, f)
+	}
+
+	p.offsets = map[string]logOffset{}
+	if config.StateDir != "" {
+		p.statePath = filepath.Join(config.StateDir, logStateFile)
+		if data, err := os.ReadFile(p.statePath); err == nil {
+			if err := json.Unmarshal(data, &p.offsets); err != nil {
+				log.Printf("Ignoring corrupt log offsets in %s: %v", p.statePath, err)
+				p.offsets = map[string]logOffset{}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *logsPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	var metrics []*proto.Metric
+	var errs []error
+	for _, f := range p.files {
+		if err := ctx.Err(); err != nil {
+			return metrics, err
+		}
+		m, err := p.collectFile(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
+		}
+		metrics = append(metrics, m...)
+	}
+	if err := p.saveOffsets(); err != nil {
+		errs = append(errs, err)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func (p *logsPlugin) DrainEvents() []*proto.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+func (p *logsPlugin) collectFile(f *logFile) ([]*proto.Metric, error) {
+	counts := make([]int, len(f.patterns))
+	lines, err := p.readNewLines(f, func(line string) {
+		for i, pat := range f.patterns {
+			if !pat.re.MatchString(line) {
+				continue
+			}
+			counts[i]++
+			if counts[i] <= f.SendLines {
+				p.addEvent(f, pat.name, line)
+			}
+		}
+	})
+
+	// Counts are reported even when nothing matched, so a quiet interval
+	// shows up as zero rather than as a gap.
+	metrics := []*proto.Metric{{
+		Type:  "log_lines",
+		Value: float64(lines),
+		Tags:  map[string]string{"file": f.Path},
+	}}
+	for i, pat := range f.patterns {
+		metrics = append(metrics, &proto.Metric{
+			Type:  "log_matches",
+			Value: float64(counts[i]),
+			Tags:  map[string]string{"file": f.Path, "pattern": pat.name},
+		})
+	}
+	return metrics, err
+}
+
+func (p *logsPlugin) addEvent(f *logFile, pattern, line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, newEvent(f.severity, "logtail", line,
+		map[string]string{"file": f.Path, "pattern": pattern}))
+}
+
+// readNewLines calls fn for every complete line added since the last call
+// and returns how many there were. A trailing line without a newline is left
+// for the next call, since the writer may still be in the middle of it.
+func (p *logsPlugin) readNewLines(f *logFile, fn func(string)) (int, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Not created yet, or between rotation and re-creation.
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	head := make([]byte, min(size, fingerprintSize))
+	if _, err := io.ReadFull(file, head); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	state, known := p.offsets[f.Path]
+	p.mu.Unlock()
+
+	switch {
+	case !known:
+		// First sight of this file: follow it from the end unless asked
+		// to read what is already there.
+		state = logOffset{}
+		if !f.FromBeginning {
+			state.Offset = size
+		}
+	case size < state.Offset:
+		log.Printf("Log %s was truncated, reading from the start", f.Path)
+		state = logOffset{}
+	case state.FingerprintLen > len(head) || fingerprint(head[:state.FingerprintLen]) != state.Fingerprint:
+		log.Printf("Log %s was rotated, reading from the start", f.Path)
+		state = logOffset{}
+	}
+	state.Fingerprint, state.FingerprintLen = fingerprint(head), len(head)
+
+	if _, err := file.Seek(state.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	limited := &io.LimitedReader{R: file, N: maxLogRead}
+	reader := bufio.NewReaderSize(limited, maxLogLine)
+	lines := 0
+	for {
+		line, n, err := readLine(reader)
+		if err != nil {
+			// A line longer than maxLogRead would never fit in a read and
+			// stall the file, so skip to its end if it has one yet.
+			if lines == 0 && limited.N == 0 {
+				if rest, ok := skipLine(file); ok {
+					state.Offset += int64(n) + rest
+					lines++
+					fn(string(line))
+				}
+			}
+			break
+		}
+		state.Offset += int64(n)
+		lines++
+		fn(string(bytes.TrimRight(line, "\r\n")))
+	}
+
+	p.mu.Lock()
+	p.offsets[f.Path] = state
+	p.mu.Unlock()
+	return lines, nil
+}
+
+// readLine returns the next newline-terminated line and the number of bytes
+// it took up in the file. Only the first maxLogLine bytes of an overlong line
+// are returned. At the end of the input it returns what there is of an
+// unfinished line along with the error.
+func readLine(r *bufio.Reader) ([]byte, int, error) {
+	chunk, err := r.ReadSlice('\n')
+	line := append([]byte(nil), chunk...)
+	n := len(chunk)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		chunk, err = r.ReadSlice('\n')
+		n += len(chunk)
+	}
+	return line, n, err
+}
+
+// skipLine reads r up to and including the next newline and returns how many
+// bytes that took. ok is false if r ends first.
+func skipLine(r io.Reader) (n int64, ok bool) {
+	reader := bufio.NewReader(r)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		n += int64(len(chunk))
+		if err == nil {
+			return n, true
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return n, false
+		}
+	}
+}
+
+func fingerprint(head []byte) string {
+	sum := sha256.Sum256(head)
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *logsPlugin) saveOffsets() error {
+	if p.statePath == "" {
+		return nil
+	}
+	p.mu.Lock()
+	// Forget files that are no longer followed.
+	for path := range p.offsets {
+		if !slices.ContainsFunc(p.files, func(f *logFile) bool { return f.Path == path }) {
+			delete(p.offsets, path)
+		}
+	}
+	data, err := json.MarshalIndent(p.offsets, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.statePath, data)
+}
+
+// writeFileAtomic replaces path with data via a temporary file and rename, so
+// a crash never leaves a half-written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newLogsPlugin(t *testing.T, stateDir string, files ...LogFileConfig) *logsPlugin {
+	t.Helper()
+	settings, err := json.Marshal(map[string]any{"files": files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &logsPlugin{}
+	if err := p.Init(PluginConfig{Settings: settings, StateDir: stateDir}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// logCounts is what one Collect reported for a file: its new lines and the
+// matches per pattern.
+type logCounts struct {
+	lines   int
+	matches map[string]int
+}
+
+func collectLogs(t *testing.T, p *logsPlugin, path string) logCounts {
+	t.Helper()
+	metrics, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := logCounts{matches: map[string]int{}}
+	for _, m := range metrics {
+		if m.Tags["file"] != path {
+			continue
+		}
+		switch m.Type {
+		case "log_lines":
+			got.lines = int(m.Value)
+		case "log_matches":
+			got.matches[m.Tags["pattern"]] = int(m.Value)
+		}
+	}
+	return got
+}
+
+func writeLog(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendLog(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectLines(t *testing.T, got logCounts, lines, errors int) {
+	t.Helper()
+	if got.lines != lines || got.matches["error"] != errors {
+		t.Errorf("got %d lines and %d errors, want %d and %d", got.lines, got.matches["error"], lines, errors)
+	}
+}
+
+var errorPattern = map[string]string{"error": "ERROR"}
+
+func TestLogsFollowsNewLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLog(t, path, "ERROR before the agent started\n")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern, SendLines: 10})
+
+	expectLines(t, collectLogs(t, p, path), 0, 0)
+	appendLog(t, path, "ERROR one\nok\nERROR two\nERROR unfin")
+	// The unfinished line waits until its writer finishes it.
+	expectLines(t, collectLogs(t, p, path), 3, 2)
+	appendLog(t, path, "ished\n")
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+	expectLines(t, collectLogs(t, p, path), 0, 0)
+
+	events := p.DrainEvents()
+	if len(events) != 3 || events[2].Message != "ERROR unfinished" {
+		t.Errorf("events = %v, want the 3 matching lines", events)
+	}
+}
+
+func TestLogsFromBeginning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLog(t, path, "ERROR one\r\nok\r\n")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern, FromBeginning: true, SendLines: 10})
+
+	expectLines(t, collectLogs(t, p, path), 2, 1)
+	if events := p.DrainEvents(); len(events) != 1 || events[0].Message != "ERROR one" {
+		t.Errorf("events = %v, want the line without its \\r\\n", events)
+	}
+}
+
+func TestLogsMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern})
+
+	expectLines(t, collectLogs(t, p, path), 0, 0)
+	// A file that shows up later is first seen then, and followed from
+	// its end like any other.
+	writeLog(t, path, "ERROR one\n")
+	expectLines(t, collectLogs(t, p, path), 0, 0)
+	appendLog(t, path, "ERROR two\n")
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+}
+
+func TestLogsRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeLog(t, path, "")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern})
+	collectLogs(t, p, path)
+	appendLog(t, path, "ERROR one\n")
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+
+	// Rotated by rename: between the rename and the new file there is
+	// nothing to read, then the new file is read from its start even
+	// though it is longer than the old offset.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, collectLogs(t, p, path), 0, 0)
+	writeLog(t, path, "ERROR in the new file\nok\n")
+	expectLines(t, collectLogs(t, p, path), 2, 1)
+}
+
+func TestLogsCopyTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLog(t, path, "")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern})
+	collectLogs(t, p, path)
+	appendLog(t, path, "ok\nok\nok\n")
+	expectLines(t, collectLogs(t, p, path), 3, 0)
+
+	// Copied away and truncated in place, then written to again.
+	writeLog(t, path, "ERROR\n")
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+}
+
+func TestLogsResumeAfterRestart(t *testing.T) {
+	dir, stateDir := t.TempDir(), t.TempDir()
+	path, other := filepath.Join(dir, "app.log"), filepath.Join(dir, "other.log")
+	writeLog(t, path, "ERROR one\n")
+	writeLog(t, other, "")
+	file := LogFileConfig{Path: path, Patterns: errorPattern, FromBeginning: true}
+
+	p := newLogsPlugin(t, stateDir, file, LogFileConfig{Path: other})
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+	appendLog(t, path, "ERROR while stopped\nok\n")
+
+	// A restarted agent carries on from the saved offset rather than from
+	// the beginning or the end.
+	p = newLogsPlugin(t, stateDir, file)
+	expectLines(t, collectLogs(t, p, path), 2, 1)
+
+	// other.log is no longer followed, so its offset is dropped.
+	data, err := os.ReadFile(filepath.Join(stateDir, logStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets map[string]logOffset
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := offsets[other]; ok || len(offsets) != 1 {
+		t.Errorf("saved offsets = %v, want only %s", offsets, path)
+	}
+}
+
+func TestLogsSendLinesCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLog(t, path, strings.Repeat("ERROR again\n", 5))
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern, FromBeginning: true, SendLines: 2})
+
+	// Every match is counted, but only send_lines of them become events.
+	expectLines(t, collectLogs(t, p, path), 5, 5)
+	if events := p.DrainEvents(); len(events) != 2 {
+		t.Errorf("%d events, want 2", len(events))
+	}
+	// Without send_lines, none are sent.
+	p = newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern, FromBeginning: true})
+	expectLines(t, collectLogs(t, p, path), 5, 5)
+	if events := p.DrainEvents(); len(events) != 0 {
+		t.Errorf("%d events, want none", len(events))
+	}
+}
+
+func TestLogsOverlongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLog(t, path, "ERROR "+strings.Repeat("x", maxLogRead)+"\nERROR next\n")
+	p := newLogsPlugin(t, "", LogFileConfig{Path: path, Patterns: errorPattern, FromBeginning: true, SendLines: 10})
+
+	// The line doesn't fit in one read; it is skipped over, cut to
+	// maxLogLine, instead of stalling the file.
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+	expectLines(t, collectLogs(t, p, path), 1, 1)
+	events := p.DrainEvents()
+	if len(events) != 2 || len(events[0].Message) != maxLogLine || events[1].Message != "ERROR next" {
+		t.Errorf("got %d events, want the cut long line and the next one", len(events))
+	}
+}
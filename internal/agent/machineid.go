@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// machineIDFile holds the generated machine ID, inside the agent's state dir,
+// on hosts where the OS doesn't provide one.
+const machineIDFile = "machine-id"
+
+// MachineID returns a stable identifier for this host: the one the OS keeps
+// (/etc/machine-id, the Windows MachineGuid, ...) or else a random UUID
+// generated once and stored in stateDir.
+func MachineID(stateDir string) (string, error) {
+	if id := systemMachineID(); id != "" {
+		return id, nil
+	}
+
+	path := filepath.Join(stateDir, machineIDFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, []byte(id+"\n")); err != nil {
+		return "", fmt.Errorf("saving machine id: %w", err)
+	}
+	return id, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// defaultServerID names an agent whose config doesn't: the host name.
+func defaultServerID() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,18 @@
+package agent
+
+import (
+	"os"
+	"strings"
+)
+
+// systemMachineID reads the systemd/D-Bus machine ID.
+func systemMachineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !windows
+
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/host"
+)
+
+// systemMachineID asks the OS for its host UUID (IOPlatformUUID on macOS,
+// kern.hostuuid on the BSDs).
+func systemMachineID() string {
+	id, err := host.HostIDWithContext(context.Background())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(id))
+}
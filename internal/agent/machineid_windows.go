@@ -0,0 +1,21 @@
+package agent
+
+import (
+	"strings"
+
+	winreg "golang.org/x/sys/windows/registry"
+)
+
+// systemMachineID reads the MachineGuid Windows generates at install time.
+func systemMachineID() string {
+	key, err := winreg.OpenKey(winreg.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, winreg.QUERY_VALUE|winreg.WOW64_64KEY)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+	id, _, err := key.GetStringValue("MachineGuid")
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(id))
+}
@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// ServiceConfig describes one monitored service. Name is reported as the
+// "service" tag; every rule that is set must match for a process to count.
+// A plain JSON string is accepted as shorthand for a case-insensitive
+// "name contains" match, which is how services used to be configured.
+type ServiceConfig struct {
+	Name         string `json:"name"`
+	ProcessName  string `json:"process_name,omitempty"`
+	NameRegex    string `json:"name_regex,omitempty"`
+	CmdlineRegex string `json:"cmdline_regex,omitempty"`
+	Exe          string `json:"exe,omitempty"`
+	User         string `json:"user,omitempty"`
+	Pidfile      string `json:"pidfile,omitempty"`
+}
+
+func (s *ServiceConfig) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*s = ServiceConfig{
+			Name:      name,
+			NameRegex: "(?i)" + regexp.QuoteMeta(name),
+		}
+		return nil
+	}
+
+	type plain ServiceConfig
+	var p plain
+	if err := decodeStrict(data, &p); err != nil {
+		return err
+	}
+	*s = ServiceConfig(p)
+	return nil
+}
+
+// ServiceMatcher is the compiled form of a ServiceConfig.
+type ServiceMatcher struct {
+	Name string
+
+	processName string
+	nameRe      *regexp.Regexp
+	cmdlineRe   *regexp.Regexp
+	exe         string
+	user        string
+	pidfile     string
+}
+
+func NewServiceMatcher(cfg ServiceConfig) (*ServiceMatcher, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("service entry has no name")
+	}
+	m := &ServiceMatcher{
+		Name:        cfg.Name,
+		processName: cfg.ProcessName,
+		exe:         cfg.Exe,
+		user:        cfg.User,
+		pidfile:     cfg.Pidfile,
+	}
+	var err error
+	if cfg.NameRegex != "" {
+		if m.nameRe, err = regexp.Compile(cfg.NameRegex); err != nil {
+			return nil, fmt.Errorf("service %q: invalid name_regex: %w", cfg.Name, err)
+		}
+	}
+	if cfg.CmdlineRegex != "" {
+		if m.cmdlineRe, err = regexp.Compile(cfg.CmdlineRegex); err != nil {
+			return nil, fmt.Errorf("service %q: invalid cmdline_regex: %w", cfg.Name, err)
+		}
+	}
+	// With no rules at all, the display name doubles as the exact process name.
+	if m.processName == "" && m.nameRe == nil && m.cmdlineRe == nil && m.exe == "" && m.user == "" && m.pidfile == "" {
+		m.processName = cfg.Name
+	}
+	return m, nil
+}
+
+// PidfilePID reads the matcher's pidfile. It returns 0 when the matcher has no
+// pidfile or the file can't be read, which matches no process.
+func (m *ServiceMatcher) PidfilePID() int32 {
+	if m.pidfile == "" {
+		return 0
+	}
+	data, err := os.ReadFile(m.pidfile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(pid)
+}
+
+// Match reports whether the process satisfies every rule. pidfilePID is the
+// value of PidfilePID for this collection cycle.
+func (m *ServiceMatcher) Match(p *processInfo, pidfilePID int32) bool {
+	if m.pidfile != "" && p.proc.Pid != pidfilePID {
+		return false
+	}
+	if m.processName != "" || m.nameRe != nil {
+		name, ok := p.name()
+		if !ok {
+			return false
+		}
+		if m.processName != "" && !sameProcessName(name, m.processName) {
+			return false
+		}
+		if m.nameRe != nil && !m.nameRe.MatchString(name) {
+			return false
+		}
+	}
+	if m.cmdlineRe != nil {
+		cmdline, ok := p.cmdline()
+		if !ok || !m.cmdlineRe.MatchString(cmdline) {
+			return false
+		}
+	}
+	if m.exe != "" {
+		exe, ok := p.exe()
+		if !ok || !samePath(exe, m.exe) {
+			return false
+		}
+	}
+	if m.user != "" {
+		user, ok := p.username()
+		if !ok || !sameUser(user, m.user) {
+			return false
+		}
+	}
+	return true
+}
+
+// processInfo lazily fetches and caches process attributes, since reading the
+// command line or owner of every process is far more expensive than its name.
+type processInfo struct {
+	proc *process.Process
+
+	nameVal, cmdlineVal, exeVal, userVal     string
+	nameErr, cmdlineErr, exeErr, userErr     error
+	haveName, haveCmdline, haveExe, haveUser bool
+}
+
+func newProcessInfo(p *process.Process) *processInfo {
+	return &processInfo{proc: p}
+}
+
+func (p *processInfo) name() (string, bool) {
+	if !p.haveName {
+		p.nameVal, p.nameErr = p.proc.Name()
+		p.haveName = true
+	}
+	return p.nameVal, p.nameErr == nil
+}
+
+func (p *processInfo) cmdline() (string, bool) {
+	if !p.haveCmdline {
+		p.cmdlineVal, p.cmdlineErr = p.proc.Cmdline()
+		p.haveCmdline = true
+	}
+	return p.cmdlineVal, p.cmdlineErr == nil
+}
+
+func (p *processInfo) exe() (string, bool) {
+	if !p.haveExe {
+		p.exeVal, p.exeErr = p.proc.Exe()
+		p.haveExe = true
+	}
+	return p.exeVal, p.exeErr == nil
+}
+
+func (p *processInfo) username() (string, bool) {
+	if !p.haveUser {
+		p.userVal, p.userErr = p.proc.Username()
+		p.haveUser = true
+	}
+	return p.userVal, p.userErr == nil
+}
+
+// sameProcessName compares names case-insensitively and ignores a trailing
+// ".exe", so "postgres" matches "postgres.exe" on Windows.
+func sameProcessName(actual, want string) bool {
+	if strings.EqualFold(actual, want) {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSuffix(strings.ToLower(actual), ".exe"), want)
+}
+
+func samePath(actual, want string) bool {
+	actual, want = filepath.Clean(actual), filepath.Clean(want)
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(actual, want)
+	}
+	return actual == want
+}
+
+// sameUser accepts either the full account name or, for Windows "DOMAIN\user"
+// accounts, just the user part.
+func sameUser(actual, want string) bool {
+	if strings.EqualFold(actual, want) {
+		return true
+	}
+	if i := strings.LastIndex(actual, `\`); i >= 0 {
+		return strings.EqualFold(actual[i+1:], want)
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"sentinel/internal/proto"
+)
+
+// Plugin is a metric source. Each enabled plugin is initialised once from its
+// section of the "plugins" config and then collected on its own interval.
+type Plugin interface {
+	Name() string
+	Init(config PluginConfig) error
+	Collect(ctx context.Context) ([]*proto.Metric, error)
+}
+
+// EventSource is implemented by plugins that also produce events. The
+// Collector drains them after every Collect.
+type EventSource interface {
+	DrainEvents() []*proto.Event
+}
+
+// PluginConfig is one section of the "plugins" object in agent-config.json.
+// The enabled/interval/timeout keys are common to every plugin; everything
+// else in the section is plugin specific and read with Decode.
+type PluginConfig struct {
+	Enabled  *bool
+	Interval time.Duration
+	Timeout  time.Duration
+	Settings json.RawMessage
+
+	// StateDir is the agent's state directory, for plugins that persist
+	// data across restarts. It is filled in by the Collector.
+	StateDir string
+}
+
+// pluginCommonKeys are the section keys handled by the Collector itself.
+var pluginCommonKeys = []string{"enabled", "interval", "timeout"}
+
+func (pc *PluginConfig) UnmarshalJSON(data []byte) error {
+	var common struct {
+		Enabled  *bool  `json:"enabled"`
+		Interval string `json:"interval"`
+		Timeout  string `json:"timeout"`
+	}
+	if err := json.Unmarshal(data, &common); err != nil {
+		return err
+	}
+	*pc = PluginConfig{Enabled: common.Enabled, Settings: append(json.RawMessage(nil), data...)}
+	var err error
+	if common.Interval != "" {
+		if pc.Interval, err = time.ParseDuration(common.Interval); err != nil {
+			return fmt.Errorf("invalid interval %q: %w", common.Interval, err)
+		}
+	}
+	if common.Timeout != "" {
+		if pc.Timeout, err = time.ParseDuration(common.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", common.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// IsEnabled reports whether the section turns the plugin on. Sections are
+// enabled unless they say otherwise.
+func (pc PluginConfig) IsEnabled() bool {
+	return pc.Enabled == nil || *pc.Enabled
+}
+
+// equal reports whether two sections configure a plugin identically.
+func (pc PluginConfig) equal(other PluginConfig) bool {
+	return pc.IsEnabled() == other.IsEnabled() &&
+		pc.Interval == other.Interval &&
+		pc.Timeout == other.Timeout &&
+		bytes.Equal(canonicalJSON(pc.Settings), canonicalJSON(other.Settings))
+}
+
+// canonicalJSON re-encodes a section so that formatting and key order don't
+// count as changes. A missing section is the same as an empty one.
+func canonicalJSON(data json.RawMessage) []byte {
+	var v any
+	if len(data) == 0 || json.Unmarshal(data, &v) != nil || v == nil {
+		v = map[string]any{}
+	}
+	out, _ := json.Marshal(v)
+	return out
+}
+
+// Decode unmarshals the plugin specific part of the section into v. Keys v
+// doesn't know are an error.
+func (pc PluginConfig) Decode(v any) error {
+	if len(pc.Settings) == 0 {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(pc.Settings, &fields); err != nil {
+		return err
+	}
+	for _, k := range pluginCommonKeys {
+		delete(fields, k)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return decodeStrict(data, v)
+}
+
+// MarshalJSON writes the section back in the config file layout.
+func (pc PluginConfig) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{}
+	if len(pc.Settings) > 0 {
+		if err := json.Unmarshal(pc.Settings, &fields); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range pluginCommonKeys {
+		delete(fields, k)
+	}
+	if pc.Enabled != nil {
+		fields["enabled"] = *pc.Enabled
+	}
+	if pc.Interval > 0 {
+		fields["interval"] = pc.Interval.String()
+	}
+	if pc.Timeout > 0 {
+		fields["timeout"] = pc.Timeout.String()
+	}
+	return json.Marshal(fields)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Plugin{}
+)
+
+// RegisterPlugin makes a plugin available under name. It is meant to be
+// called from init functions and panics on duplicate names.
+func RegisterPlugin(name string, factory func() Plugin) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("agent: plugin registered twice: " + name)
+	}
+	registry[name] = factory
+}
+
+// NewPlugin returns a fresh instance of the named plugin.
+func NewPlugin(name string) (Plugin, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[name]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// RegisteredPlugins returns the names of all registered plugins, sorted.
+func RegisteredPlugins() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultReloadInterval is how often the config file is checked for changes.
+const DefaultReloadInterval = 5 * time.Second
+
+// ConfigWatcher reloads the config file when its content changes or, on Unix,
+// when the agent receives SIGHUP. A new config is only applied once it has
+// been validated; a broken edit is logged and the running config stays.
+type ConfigWatcher struct {
+	Source   *ConfigSource
+	Interval time.Duration
+	Apply    func(*Config)
+
+	sum [sha256.Size]byte
+}
+
+func NewConfigWatcher(src *ConfigSource, apply func(*Config)) *ConfigWatcher {
+	return &ConfigWatcher{
+		Source:   src,
+		Interval: DefaultReloadInterval,
+		Apply:    apply,
+	}
+}
+
+// Run watches until ctx is cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) {
+	// The file as it is now is what the agent started with.
+	w.sum, _ = w.checksum()
+
+	signals, stop := reloadSignals()
+	defer stop()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			log.Printf("Reload requested, reading %s", w.Source.Path)
+			w.reload()
+		case <-ticker.C:
+			sum, err := w.checksum()
+			if err != nil || sum == w.sum {
+				continue
+			}
+			log.Printf("%s changed, reloading", w.Source.Path)
+			w.reload()
+		}
+	}
+}
+
+func (w *ConfigWatcher) checksum() ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(w.Source.Path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+func (w *ConfigWatcher) reload() {
+	// Remember the content even if it is rejected, so the same broken file
+	// isn't reported again every interval.
+	if sum, err := w.checksum(); err == nil {
+		w.sum = sum
+	}
+
+	cfg, err := ReadConfig(w.Source)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("Config reload skipped: %s not found", w.Source.Path)
+			return
+		}
+		log.Printf("Config reload rejected, keeping the current config: %v", err)
+		return
+	}
+	w.Apply(cfg)
+}
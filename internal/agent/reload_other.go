@@ -0,0 +1,11 @@
+//go:build !unix
+
+package agent
+
+import "os"
+
+// reloadSignals returns a channel that never fires: there is no reload signal
+// here, so changes are only picked up by watching the file.
+func reloadSignals() (<-chan os.Signal, func()) {
+	return nil, func() {}
+}
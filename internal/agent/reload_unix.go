@@ -0,0 +1,17 @@
+//go:build unix
+
+package agent
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadSignals delivers SIGHUP, the conventional "re-read your config"
+// signal (e.g. `systemctl kill -s HUP SentinelAgent`).
+func reloadSignals() (<-chan os.Signal, func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	return ch, func() { signal.Stop(ch) }
+}
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"sentinel/internal/proto"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+func init() {
+	RegisterPlugin("services", func() Plugin { return &servicesPlugin{} })
+}
+
+// servicesPlugin reports configured services by matching running processes.
+// It also raises an event whenever a service goes down or comes back up.
+type servicesPlugin struct {
+	matchers []*ServiceMatcher
+
+	mu     sync.Mutex
+	up     map[string]bool
+	events []*proto.Event
+}
+
+func (p *servicesPlugin) Name() string { return "services" }
+
+func (p *servicesPlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Services []ServiceConfig `json:"services"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+	p.matchers = nil
+	p.up = map[string]bool{}
+	for _, s := range settings.Services {
+		m, err := NewServiceMatcher(s)
+		if err != nil {
+			return err
+		}
+		p.matchers = append(p.matchers, m)
+	}
+	return nil
+}
+
+// serviceUsage aggregates the processes that matched a configured service.
+type serviceUsage struct {
+	processes int
+	cpu       float64
+	rssBytes  uint64
+}
+
+func (p *servicesPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	if len(p.matchers) == 0 {
+		return nil, nil
+	}
+
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		// Without a process list we can't tell up from down, so report nothing
+		// and let HQ mark the services as unknown.
+		return nil, fmt.Errorf("listing processes: %w", err)
+	}
+
+	// Pidfiles are re-read every cycle since the pid changes on restart
+	pidfilePIDs := make([]int32, len(p.matchers))
+	for i, m := range p.matchers {
+		pidfilePIDs[i] = m.PidfilePID()
+	}
+
+	found := make(map[string]*serviceUsage)
+	for _, m := range p.matchers {
+		found[m.Name] = nil
+	}
+
+	for _, proc := range procs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info := newProcessInfo(proc)
+		for i, m := range p.matchers {
+			if !m.Match(info, pidfilePIDs[i]) {
+				continue
+			}
+			usage := found[m.Name]
+			if usage == nil {
+				usage = &serviceUsage{}
+				found[m.Name] = usage
+			}
+			usage.processes++
+			if cpuPercent, err := proc.CPUPercentWithContext(ctx); err == nil {
+				usage.cpu += cpuPercent
+			}
+			if memInfo, err := proc.MemoryInfoWithContext(ctx); err == nil && memInfo != nil {
+				usage.rssBytes += memInfo.RSS
+			}
+		}
+	}
+
+	var metrics []*proto.Metric
+	for s, usage := range found {
+		p.recordState(s, usage != nil)
+		if usage == nil {
+			// Configured but not running: report it explicitly as down.
+			metrics = append(metrics, &proto.Metric{
+				Type:  "service_status",
+				Value: 0, // 0 = Down
+				Tags:  map[string]string{"service": s},
+			})
+			continue
+		}
+
+		metrics = append(metrics, &proto.Metric{
+			Type:  "service_cpu",
+			Value: round(usage.cpu),
+			Tags:  map[string]string{"service": s},
+		})
+		metrics = append(metrics, &proto.Metric{
+			Type:  "service_processes",
+			Value: float64(usage.processes),
+			Tags:  map[string]string{"service": s},
+		})
+		metrics = append(metrics, &proto.Metric{
+			Type:  "service_memory_mb",
+			Value: float64(usage.rssBytes) / 1024 / 1024,
+			Tags:  map[string]string{"service": s},
+		})
+		metrics = append(metrics, &proto.Metric{
+			Type:  "service_status",
+			Value: 1, // 1 = Up
+			Tags:  map[string]string{"service": s},
+		})
+	}
+	return metrics, nil
+}
+
+func (p *servicesPlugin) DrainEvents() []*proto.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// recordState queues an event when a service changes state. The first
+// observation after start-up only sets the baseline.
+func (p *servicesPlugin) recordState(service string, up bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	was, seen := p.up[service]
+	p.up[service] = up
+	if !seen || was == up {
+		return
+	}
+	attrs := map[string]string{"service": service}
+	if up {
+		p.events = append(p.events, newEvent(proto.Severity_SEVERITY_INFO, "services", "Service "+service+" is up", attrs))
+	} else {
+		p.events = append(p.events, newEvent(proto.Severity_SEVERITY_ERROR, "services", "Service "+service+" is down", attrs))
+	}
+}
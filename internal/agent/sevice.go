@@ -8,14 +8,16 @@ import (
 )
 
 type Program struct {
-	Client *Client
-	ctx    context.Context
-	cancel context.CancelFunc
+	Client  *Client
+	Watcher *ConfigWatcher
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
-func NewProgram(client *Client) *Program {
+func NewProgram(client *Client, watcher *ConfigWatcher) *Program {
 	return &Program{
-		Client: client,
+		Client:  client,
+		Watcher: watcher,
 	}
 }
 
@@ -27,6 +29,9 @@ func (p *Program) Start(s service.Service) error {
 
 func (p *Program) run() {
 	defer p.cancel()
+	if p.Watcher != nil {
+		go p.Watcher.Run(p.ctx)
+	}
 	// Start the gRPC client (this blocks until error or stop)
 	if err := p.Client.Start(p.ctx); err != nil {
 		log.Printf("Agent stopped with error: %v", err)
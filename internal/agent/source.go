@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigPathEnv names the config file when no path is given on the command
+// line.
+const ConfigPathEnv = "SENTINEL_CONFIG"
+
+// ConfigEnv maps the environment variables that override config fields to
+// those fields. SENTINEL_PLUGINS and SENTINEL_HQ_TLS hold JSON that is merged
+// over that section of the file; SENTINEL_LABELS holds key=value pairs, such
+// as env=prod,role=db, merged over the file's labels.
+var ConfigEnv = map[string]string{
+	"SENTINEL_HQ_ADDRESS":          "hq_address",
+	"SENTINEL_HQ_TLS":              "hq_tls",
+	"SENTINEL_SERVER_ID":           "server_id",
+	"SENTINEL_LABELS":              "labels",
+	"SENTINEL_COLLECTION_INTERVAL": "collection_interval",
+	"SENTINEL_STATE_DIR":           "state_dir",
+	"SENTINEL_PLUGINS":             "plugins",
+}
+
+// ConfigSource is where the agent's config comes from. From lowest to highest
+// precedence: the defaults, the config file, the SENTINEL_* environment
+// variables and the command line flags.
+type ConfigSource struct {
+	Path string
+	// Flags are config fields set on the command line, by field name.
+	Flags map[string]string
+}
+
+// NewConfigSource returns a source for the config file at path, or for the
+// file FindConfigFile picks when path is empty.
+func NewConfigSource(path string, flags map[string]string) *ConfigSource {
+	if path == "" {
+		path = FindConfigFile()
+	}
+	return &ConfigSource{Path: path, Flags: flags}
+}
+
+// FindConfigFile returns the config file to use when none was given: the file
+// named by SENTINEL_CONFIG, else the first that exists of the standard
+// location (/etc/sentinel or %ProgramData%\Sentinel), the executable's
+// directory and the working directory. If there is none, the standard
+// location is returned so a file created there later is picked up.
+func FindConfigFile() string {
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path
+	}
+	candidates := []string{defaultConfigPath()}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), DefaultConfigFile))
+	}
+	candidates = append(candidates, DefaultConfigFile)
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return candidates[0]
+}
+
+// parse parses the content of the config file with the environment and flag
+// overrides merged over it.
+func (src *ConfigSource) parse(data []byte) (*Config, error) {
+	overrides := map[string]any{}
+	for env, field := range ConfigEnv {
+		if v := os.Getenv(env); v != "" {
+			if err := setOverride(overrides, field, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	flags := map[string]any{}
+	for field, v := range src.Flags {
+		if err := setOverride(flags, field, v); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", field, err)
+		}
+	}
+	overrides = mergeJSON(overrides, flags)
+	if len(overrides) == 0 {
+		return ParseConfig(data)
+	}
+
+	var file map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file == nil {
+		file = map[string]any{}
+	}
+	merged, err := json.Marshal(mergeJSON(file, overrides))
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(merged)
+}
+
+// setOverride records value for field. Plugins and hq_tls are given as JSON,
+// labels as comma-separated key=value pairs; every other field is a plain
+// string.
+func setOverride(overrides map[string]any, field, value string) error {
+	switch field {
+	case "plugins", "hq_tls":
+		var section map[string]any
+		if err := json.Unmarshal([]byte(value), &section); err != nil {
+			return fmt.Errorf("%s must be a JSON object: %w", field, err)
+		}
+		overrides[field] = section
+	case "labels":
+		labels := map[string]any{}
+		for _, pair := range strings.Split(value, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || k == "" {
+				return fmt.Errorf("labels must be key=value pairs separated by commas, got %q", pair)
+			}
+			labels[k] = v
+		}
+		overrides[field] = labels
+	default:
+		overrides[field] = value
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+
+	"sentinel/internal/proto"
+)
+
+func init() {
+	RegisterPlugin("service_state", func() Plugin { return &serviceStatePlugin{} })
+}
+
+// Normalized service manager states, shared by systemd and the Windows SCM.
+const (
+	StateRunning      = "running"
+	StateStopped      = "stopped"
+	StateFailed       = "failed"
+	StateActivating   = "activating"
+	StateDeactivating = "deactivating"
+	StateReloading    = "reloading"
+	StatePaused       = "paused"
+	StateNotFound     = "not_found"
+	StateUnknown      = "unknown"
+)
+
+// ServiceState is what the OS service manager reports for one service.
+type ServiceState struct {
+	Name      string
+	Manager   string // "systemd" or "scm"
+	State     string // one of the State* constants
+	SubState  string // raw manager state, e.g. systemd SubState "dead" or "start_pending"
+	StartType string // e.g. "auto", "manual", "disabled" or systemd's UnitFileState
+}
+
+// serviceStatePlugin reports the service manager's view of the configured
+// services (systemd units on Linux, SCM services on Windows).
+type serviceStatePlugin struct {
+	services []string
+}
+
+func (p *serviceStatePlugin) Name() string { return "service_state" }
+
+func (p *serviceStatePlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Services []string `json:"services"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+	p.services = settings.Services
+	return nil
+}
+
+func (p *serviceStatePlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	states, err := queryServiceStates(ctx, p.services)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []*proto.Metric
+	for _, s := range states {
+		value := 0.0
+		if s.State == StateRunning {
+			value = 1
+		}
+		metrics = append(metrics, &proto.Metric{
+			Type:  "service_state",
+			Value: value,
+			Tags: map[string]string{
+				"service":    s.Name,
+				"manager":    s.Manager,
+				"state":      s.State,
+				"sub_state":  s.SubState,
+				"start_type": s.StartType,
+			},
+		})
+	}
+	return metrics, nil
+}
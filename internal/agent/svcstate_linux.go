@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// queryServiceStates asks systemd for unit state via `systemctl show`.
+// systemctl is looked up on the PATH on every call, so a fake one can stand
+// in for it.
+func queryServiceStates(ctx context.Context, names []string) ([]ServiceState, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	args := []string{"show", "--no-pager", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", "--"}
+	args = append(args, names...)
+	out, err := exec.CommandContext(ctx, "systemctl", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl show: %w", err)
+	}
+	return parseSystemctlShow(out, names)
+}
+
+// parseSystemctlShow parses `systemctl show` output, which is one block of
+// key=value lines per unit, separated by blank lines, in argument order.
+func parseSystemctlShow(out []byte, names []string) ([]ServiceState, error) {
+	var blocks []map[string]string
+	current := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = map[string]string{}
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			current[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+	if len(blocks) != len(names) {
+		return nil, fmt.Errorf("systemctl show returned %d units, expected %d", len(blocks), len(names))
+	}
+
+	states := make([]ServiceState, len(names))
+	for i, props := range blocks {
+		states[i] = ServiceState{
+			Name:      names[i],
+			Manager:   "systemd",
+			State:     systemdState(props["LoadState"], props["ActiveState"]),
+			SubState:  props["SubState"],
+			StartType: props["UnitFileState"],
+		}
+	}
+	return states, nil
+}
+
+func systemdState(loadState, activeState string) string {
+	if loadState == "not-found" {
+		return StateNotFound
+	}
+	switch activeState {
+	case "active":
+		return StateRunning
+	case "inactive":
+		return StateStopped
+	case "failed":
+		return StateFailed
+	case "activating":
+		return StateActivating
+	case "deactivating":
+		return StateDeactivating
+	case "reloading":
+		return StateReloading
+	default:
+		return StateUnknown
+	}
+}
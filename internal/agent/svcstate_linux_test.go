@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSystemctl answers `systemctl show` like systemd does: one block per
+// unit in argument order, with LoadState=not-found for units that don't
+// exist. It records its arguments in args.
+const fakeSystemctl = `#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+while [ "$1" != "--" ]; do shift; done
+shift
+first=1
+for unit in "$@"; do
+	[ "$first" = 1 ] || echo
+	first=0
+	case "$unit" in
+	nginx.service)
+		printf 'Id=nginx.service\nLoadState=loaded\nActiveState=active\nSubState=running\nUnitFileState=enabled\n' ;;
+	cron)
+		printf 'Id=cron.service\nLoadState=loaded\nActiveState=inactive\nSubState=dead\nUnitFileState=disabled\n' ;;
+	backup)
+		printf 'Id=backup.service\nLoadState=loaded\nActiveState=failed\nSubState=failed\nUnitFileState=static\n' ;;
+	slow)
+		printf 'Id=slow.service\nLoadState=loaded\nActiveState=activating\nSubState=start-pre\nUnitFileState=enabled\n' ;;
+	odd)
+		printf 'Id=odd.service\nLoadState=loaded\nActiveState=maintenance\nSubState=\nUnitFileState=\n' ;;
+	*)
+		printf 'Id=%s.service\nLoadState=not-found\nActiveState=inactive\nSubState=dead\nUnitFileState=\n' "$unit" ;;
+	esac
+done
+`
+
+// installSystemctl puts script on the PATH as systemctl and returns its
+// directory.
+func installSystemctl(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "systemctl"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestQueryServiceStates(t *testing.T) {
+	dir := installSystemctl(t, fakeSystemctl)
+
+	names := []string{"nginx.service", "cron", "backup", "slow", "odd", "missing"}
+	got, err := queryServiceStates(context.Background(), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServiceState{
+		{Name: "nginx.service", Manager: "systemd", State: StateRunning, SubState: "running", StartType: "enabled"},
+		{Name: "cron", Manager: "systemd", State: StateStopped, SubState: "dead", StartType: "disabled"},
+		{Name: "backup", Manager: "systemd", State: StateFailed, SubState: "failed", StartType: "static"},
+		{Name: "slow", Manager: "systemd", State: StateActivating, SubState: "start-pre", StartType: "enabled"},
+		{Name: "odd", Manager: "systemd", State: StateUnknown},
+		{Name: "missing", Manager: "systemd", State: StateNotFound, SubState: "dead"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("states:\n got %+v\nwant %+v", got, want)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := "show --no-pager --property=Id,LoadState,ActiveState,SubState,UnitFileState -- " + strings.Join(names, " ")
+	if strings.TrimSpace(string(args)) != wantArgs {
+		t.Errorf("systemctl args = %q, want %q", args, wantArgs)
+	}
+}
+
+func TestQueryServiceStatesNoServices(t *testing.T) {
+	dir := installSystemctl(t, fakeSystemctl)
+
+	got, err := queryServiceStates(context.Background(), nil)
+	if err != nil || got != nil {
+		t.Errorf("queryServiceStates(nil) = %v, %v; want nil, nil", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "args")); err == nil {
+		t.Error("systemctl was run without services")
+	}
+}
+
+func TestQueryServiceStatesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, script string
+	}{
+		{"systemctl fails", "#!/bin/sh\necho 'Failed to connect to bus' >&2\nexit 1\n"},
+		{"units missing from the output", "#!/bin/sh\nprintf 'Id=nginx.service\\nLoadState=loaded\\nActiveState=active\\n'\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			installSystemctl(t, tc.script)
+			if got, err := queryServiceStates(context.Background(), []string{"nginx.service", "cron"}); err == nil {
+				t.Errorf("queryServiceStates = %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestQueryServiceStatesNoSystemctl(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if _, err := queryServiceStates(context.Background(), []string{"nginx.service"}); err == nil {
+		t.Error("queryServiceStates without systemctl succeeded")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+func queryServiceStates(ctx context.Context, names []string) ([]ServiceState, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("service manager state is not supported on %s", runtime.GOOS)
+}
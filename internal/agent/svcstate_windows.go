@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// queryServiceStates reads service state and start type from the Windows
+// Service Control Manager. Handles are opened with query rights only, so this
+// doesn't require the agent to run as an administrator.
+func queryServiceStates(ctx context.Context, names []string) ([]ServiceState, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return nil, fmt.Errorf("connect to service control manager: %w", err)
+	}
+	defer windows.CloseServiceHandle(h)
+
+	states := make([]ServiceState, 0, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		states = append(states, queryWindowsService(h, name))
+	}
+	return states, nil
+}
+
+func queryWindowsService(scm windows.Handle, name string) ServiceState {
+	state := ServiceState{Name: name, Manager: "scm", State: StateUnknown}
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return state
+	}
+	h, err := windows.OpenService(scm, namePtr, windows.SERVICE_QUERY_STATUS|windows.SERVICE_QUERY_CONFIG)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			state.State = StateNotFound
+		}
+		return state
+	}
+	s := &mgr.Service{Name: name, Handle: h}
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil {
+		state.State, state.SubState = scmState(status.State)
+	}
+	if cfg, err := s.Config(); err == nil {
+		state.StartType = scmStartType(cfg)
+	}
+	return state
+}
+
+func scmState(st svc.State) (state, subState string) {
+	switch st {
+	case svc.Stopped:
+		return StateStopped, "stopped"
+	case svc.StartPending:
+		return StateActivating, "start_pending"
+	case svc.StopPending:
+		return StateDeactivating, "stop_pending"
+	case svc.Running:
+		return StateRunning, "running"
+	case svc.ContinuePending:
+		return StateActivating, "continue_pending"
+	case svc.PausePending:
+		return StateDeactivating, "pause_pending"
+	case svc.Paused:
+		return StatePaused, "paused"
+	default:
+		return StateUnknown, ""
+	}
+}
+
+func scmStartType(cfg mgr.Config) string {
+	switch cfg.StartType {
+	case mgr.StartAutomatic:
+		if cfg.DelayedAutoStart {
+			return "delayed_auto"
+		}
+		return "auto"
+	case mgr.StartManual:
+		return "manual"
+	case mgr.StartDisabled:
+		return "disabled"
+	case windows.SERVICE_BOOT_START:
+		return "boot"
+	case windows.SERVICE_SYSTEM_START:
+		return "system"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sentinel/internal/proto"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+func init() {
+	RegisterPlugin("cpu", func() Plugin { return &cpuPlugin{} })
+	RegisterPlugin("memory", func() Plugin { return &memoryPlugin{} })
+	RegisterPlugin("disk", func() Plugin { return &diskPlugin{} })
+}
+
+type cpuPlugin struct{}
+
+func (p *cpuPlugin) Name() string { return "cpu" }
+
+func (p *cpuPlugin) Init(config PluginConfig) error { return nil }
+
+func (p *cpuPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	percent, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(percent) == 0 {
+		return nil, errors.New("no CPU usage reported")
+	}
+	return []*proto.Metric{{
+		Type:  "cpu_usage",
+		Value: round(percent[0]),
+		Tags:  map[string]string{"unit": "percent"},
+	}}, nil
+}
+
+type memoryPlugin struct{}
+
+func (p *memoryPlugin) Name() string { return "memory" }
+
+func (p *memoryPlugin) Init(config PluginConfig) error { return nil }
+
+func (p *memoryPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []*proto.Metric{
+		{
+			Type:  "memory_used_percent",
+			Value: round(v.UsedPercent),
+			Tags:  map[string]string{"unit": "percent"},
+		},
+		{
+			Type:  "memory_free_mb",
+			Value: float64(v.Free) / 1024 / 1024,
+			Tags:  map[string]string{"unit": "mb"},
+		},
+		{
+			Type:  "memory_total_mb",
+			Value: float64(v.Total) / 1024 / 1024,
+			Tags:  map[string]string{"unit": "mb"},
+		},
+		{
+			Type:  "memory_available_mb",
+			Value: float64(v.Available) / 1024 / 1024,
+			Tags:  map[string]string{"unit": "mb"},
+		},
+	}, nil
+}
+
+// diskPlugin reports usage for each configured mount point or drive.
+type diskPlugin struct {
+	paths []string
+}
+
+func (p *diskPlugin) Name() string { return "disk" }
+
+func (p *diskPlugin) Init(config PluginConfig) error {
+	var settings struct {
+		Paths []string `json:"paths"`
+	}
+	if err := config.Decode(&settings); err != nil {
+		return err
+	}
+	p.paths = settings.Paths
+	if len(p.paths) == 0 {
+		p.paths = []string{"/"}
+	}
+	return nil
+}
+
+func (p *diskPlugin) Collect(ctx context.Context) ([]*proto.Metric, error) {
+	var metrics []*proto.Metric
+	var errs []error
+	for _, path := range p.paths {
+		d, err := disk.UsageWithContext(ctx, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		metrics = append(metrics, &proto.Metric{
+			Type:  "disk_free_gb",
+			Value: float64(d.Free) / 1024 / 1024 / 1024,
+			Tags:  map[string]string{"unit": "gb", "path": path},
+		})
+		metrics = append(metrics, &proto.Metric{
+			Type:  "disk_used_percent",
+			Value: round(d.UsedPercent),
+			Tags:  map[string]string{"unit": "percent", "path": path},
+		})
+	}
+	return metrics, errors.Join(errs...)
+}
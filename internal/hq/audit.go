@@ -0,0 +1,278 @@
+package hq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxAuditBody caps the request and response bodies kept for the audit
+	// log; larger bodies are recorded as null.
+	maxAuditBody = 64 << 10
+	// maxRequestBody caps the body of write requests, which audit reads into
+	// memory before the handler runs.
+	maxRequestBody = 1 << 20
+	// auditWriteTimeout bounds writing an entry once the request is done.
+	auditWriteTimeout = 5 * time.Second
+)
+
+// auditExempt are write routes that are not administrative actions.
+var auditExempt = map[string]bool{"/auth/login": true, "/auth/logout": true, "/live/token": true}
+
+// auditJobKey is set by handlers that answer with a job, to its ID.
+const auditJobKey = "audit_job"
+
+// auditSecretKeys are JSON keys whose values never reach the audit log.
+var auditSecretKeys = map[string]bool{"password": true, "token": true, "tokens": true, "key": true}
+
+// auditSnapshot returns the current state of the resource a route writes to,
+// or nil if it doesn't exist. Routes with a snapshot get before and after
+// states in the audit log; the others get their request body as after.
+// Routes that start a job get the request body and the job's ID as after,
+// since the change is still to come.
+type auditSnapshot func(s *RESTServer, c *gin.Context) (any, error)
+
+var auditSnapshots = map[string]auditSnapshot{
+	"/servers/:server_id":              snapshotServer,
+	"/servers/:server_id/decommission": snapshotServer,
+	"/servers/:server_id/recommission": snapshotServer,
+	"/servers/:server_id/rename":       snapshotServer,
+	"/servers/:server_id/labels":       snapshotServer,
+	"/config/profiles/:name":           snapshotConfigProfile,
+	"/config/profiles/:name/selector":  snapshotConfigProfile,
+	"/servers/:server_id/config": func(s *RESTServer, c *gin.Context) (any, error) {
+		return s.Store.GetConfigStatus(c.Request.Context(), c.Param("server_id"))
+	},
+	"/users/:username": func(s *RESTServer, c *gin.Context) (any, error) {
+		return s.Store.GetUser(c.Request.Context(), c.Param("username"))
+	},
+	"/api-keys/:id": func(s *RESTServer, c *gin.Context) (any, error) {
+		keys, err := s.Store.ListAPIKeys(c.Request.Context())
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if strconv.FormatInt(k.ID, 10) == c.Param("id") {
+				return k, nil
+			}
+		}
+		return nil, ErrNotFound
+	},
+}
+
+func snapshotServer(s *RESTServer, c *gin.Context) (any, error) {
+	return s.Store.GetServer(c.Request.Context(), c.Param("server_id"))
+}
+
+func snapshotConfigProfile(s *RESTServer, c *gin.Context) (any, error) {
+	return s.Store.GetConfigProfile(c.Request.Context(), c.Param("name"), 0)
+}
+
+// audit records every write request in the audit log, including ones that
+// fail or are forbidden. It runs after authenticate, so it knows the actor.
+func (s *RESTServer) audit(c *gin.Context) {
+	route := c.FullPath()
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	if route == "" || auditExempt[route] {
+		c.Next()
+		return
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBody)); err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	snapshot := auditSnapshots[route]
+	var before json.RawMessage
+	if snapshot != nil {
+		before = s.auditState(c, snapshot)
+	}
+
+	w := &auditWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	p := principalOf(c)
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Actor:     p.Name,
+		ActorKind: p.Kind,
+		Role:      p.Role,
+		IPAddress: c.ClientIP(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Route:     route,
+		Status:    w.Status(),
+		Before:    before,
+	}
+	if entry.Status >= 200 && entry.Status < 300 {
+		jobID, isJob := c.Get(auditJobKey)
+		switch {
+		case isJob:
+			entry.After = auditJob(jobID.(int64), body)
+		case snapshot != nil:
+			entry.After = s.auditState(c, snapshot)
+		default:
+			entry.After = redactJSON(body)
+		}
+	} else {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(w.body.Bytes(), &resp) == nil {
+			entry.Error = resp.Error
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditWriteTimeout)
+	defer cancel()
+	if err := s.Store.SaveAuditEntry(ctx, entry); err != nil {
+		log.Printf("Error writing audit log entry for %s %s by %s: %v", entry.Method, entry.Path, entry.Actor, err)
+	}
+}
+
+// auditState takes a snapshot as JSON with secrets removed.
+func (s *RESTServer) auditState(c *gin.Context, snapshot auditSnapshot) json.RawMessage {
+	v, err := snapshot(s, c)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error taking audit snapshot of %s: %v", c.Request.URL.Path, err)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return redactJSON(data)
+}
+
+// auditJob records a started job: its ID, to look up how it went at
+// /jobs/:id, and the request that started it.
+func auditJob(jobID int64, body []byte) json.RawMessage {
+	data, err := json.Marshal(struct {
+		JobID   int64           `json:"job_id"`
+		Request json.RawMessage `json:"request"`
+	}{jobID, redactJSON(body)})
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// redactJSON replaces the values of auditSecretKeys anywhere in data. Data
+// that isn't JSON, or is too large, is dropped.
+func redactJSON(data []byte) json.RawMessage {
+	if len(data) == 0 || len(data) > maxAuditBody {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil || v == nil {
+		return nil
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			if auditSecretKeys[strings.ToLower(k)] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(item)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return v
+}
+
+// auditWriter keeps a copy of the start of the response, to pick up the
+// error message of failed requests.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if room := maxAuditBody - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// handleGetAuditLog serves the audit log, newest first. Query parameters:
+// from and to (RFC 3339), actor, method, route (e.g. /users/:username),
+// path (prefix) and limit.
+func (s *RESTServer) handleGetAuditLog(c *gin.Context) {
+	q := AuditQuery{
+		Actor:      c.Query("actor"),
+		Method:     c.Query("method"),
+		Route:      c.Query("route"),
+		PathPrefix: c.Query("path"),
+	}
+	var err error
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: " + err.Error()})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: " + err.Error()})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	entries, err := s.Store.GetAuditLog(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
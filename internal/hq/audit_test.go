@@ -0,0 +1,90 @@
+package hq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// auditStore records audit entries. Only the methods the tested routes use
+// are implemented.
+type auditStore struct {
+	MetricStore
+
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func (f *auditStore) SaveAuditEntry(ctx context.Context, e AuditEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func (f *auditStore) GetServer(ctx context.Context, serverID string) (*ServerDetails, error) {
+	return &ServerDetails{ServerStatus: ServerStatus{ServerID: serverID}}, nil
+}
+
+func (f *auditStore) RenameServer(ctx context.Context, oldID, newID string, progress ProgressFunc) error {
+	return nil
+}
+
+func newAuditServer(t *testing.T) (*RESTServer, *auditStore) {
+	t.Helper()
+	store := &auditStore{}
+	s := NewRESTServer(store, NewStreamRegistry(), NewLiveBroker(), NewLatestCache(), DefaultConfig())
+	t.Cleanup(s.Live.Close)
+	return s, store
+}
+
+func TestAuditJobRoute(t *testing.T) {
+	s, store := newAuditServer(t)
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/servers/web-1/rename", strings.NewReader(`{"server_id": "web-2"}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("rename = %d %s", w.Code, w.Body)
+	}
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(store.entries))
+	}
+	e := store.entries[0]
+	if e.Before == nil {
+		t.Error("no before snapshot of the server")
+	}
+	var after struct {
+		JobID   int64             `json:"job_id"`
+		Request map[string]string `json:"request"`
+	}
+	if err := json.Unmarshal(e.After, &after); err != nil {
+		t.Fatalf("after = %s: %v", e.After, err)
+	}
+	if after.JobID != job.ID || after.Request["server_id"] != "web-2" {
+		t.Errorf("after = %s, want job %d and the request", e.After, job.ID)
+	}
+}
+
+func TestAuditRejectsLargeBody(t *testing.T) {
+	s, store := newAuditServer(t)
+	body := `{"server_id": "` + strings.Repeat("x", maxRequestBody) + `"}`
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/servers/web-1/rename", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("rename with a %d byte body = %d, want 413", len(body), w.Code)
+	}
+	if len(s.Jobs.List()) != 0 {
+		t.Error("the handler ran")
+	}
+	if len(store.entries) != 0 {
+		t.Errorf("audit entries = %v", store.entries)
+	}
+}
@@ -0,0 +1,495 @@
+package hq
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles, from least to most privileged. Each role can do everything the
+// roles before it can.
+const (
+	// RoleViewer reads servers, metrics, events and config.
+	RoleViewer = "viewer"
+	// RoleOperator also assigns config profiles to servers, by server_id or
+	// by selector, and decommissions servers.
+	RoleOperator = "operator"
+	// RoleAdmin also saves and deletes config profiles, deletes, renames and
+	// labels servers, manages users and API keys, and reads the audit log.
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+const (
+	minPasswordLen = 8
+	// apiKeyPrefix marks API keys, so they are looked up as keys before
+	// sessions.
+	apiKeyPrefix = "snt_"
+	// apiKeyShownLen is how much of a key is kept in the clear to identify it.
+	apiKeyShownLen = len(apiKeyPrefix) + 6
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// publicPaths are served without credentials: probes and the login itself.
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true, "/auth/login": true}
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response takes as long as for a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("sentinel-dummy-password"), bcrypt.DefaultCost)
+
+// Principal is who made a request.
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Kind is how they authenticated: "session", "api_key", "token",
+	// "live_token", or "anonymous" when auth is disabled.
+	Kind string `json:"kind"`
+
+	sessionHash string
+}
+
+const principalKey = "principal"
+
+// principalOf returns the principal that authenticate stored for c.
+func principalOf(c *gin.Context) Principal {
+	p, _ := c.Get(principalKey)
+	principal, _ := p.(Principal)
+	return principal
+}
+
+// HashPassword hashes a password for storage with bcrypt.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// newSecret returns a random token with the given prefix.
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret is how session tokens and API keys are stored. They are random
+// and long, so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureAdmin creates the configured admin user if there are no users yet.
+func EnsureAdmin(ctx context.Context, store MetricStore, cfg AuthConfig) error {
+	if cfg.Admin.Username == "" {
+		return nil
+	}
+	n, err := store.CountUsers(ctx)
+	if err != nil || n > 0 {
+		return err
+	}
+	hash, err := HashPassword(cfg.Admin.Password)
+	if err != nil {
+		return err
+	}
+	_, err = store.CreateUser(ctx, User{Username: cfg.Admin.Username, Role: RoleAdmin, PasswordHash: hash})
+	if err != nil && !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+	log.Printf("Created admin user %q", cfg.Admin.Username)
+	return nil
+}
+
+// authenticate identifies the caller from the Authorization header and
+// stores it as the request's Principal. Without auth every caller is an
+// anonymous admin. It runs after cors, which has already answered preflight
+// requests.
+func (s *RESTServer) authenticate(c *gin.Context) {
+	if !s.Config.Auth.Enabled {
+		c.Set(principalKey, Principal{Name: "anonymous", Role: RoleAdmin, Kind: "anonymous"})
+		c.Next()
+		return
+	}
+	if publicPaths[c.Request.URL.Path] {
+		c.Next()
+		return
+	}
+
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if ok && token != "" {
+		principal, err := s.lookupToken(c.Request.Context(), token)
+		if err == nil {
+			c.Set(principalKey, principal)
+			c.Next()
+			return
+		}
+		if !errors.Is(err, ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if liveStreamRoutes[c.FullPath()] {
+		if principal, ok := s.checkLiveToken(c.GetString(liveTokenKey), time.Now()); ok {
+			c.Set(principalKey, principal)
+			c.Next()
+			return
+		}
+	}
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid token"})
+}
+
+// lookupToken resolves a bearer token: a static token, an API key or a
+// session token. ErrNotFound means it is none of them.
+func (s *RESTServer) lookupToken(ctx context.Context, token string) (Principal, error) {
+	for i, t := range s.Config.Auth.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return Principal{Name: "token-" + strconv.Itoa(i+1), Role: RoleAdmin, Kind: "token"}, nil
+		}
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		key, err := s.Store.GetAPIKey(ctx, hashSecret(token))
+		if err == nil {
+			return Principal{Name: "key:" + key.Name, Role: key.Role, Kind: "api_key"}, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return Principal{}, err
+		}
+	}
+	hash := hashSecret(token)
+	user, err := s.Store.GetSessionUser(ctx, hash)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{Name: user.Username, Role: user.Role, Kind: "session", sessionHash: hash}, nil
+}
+
+// liveTokenTTL is how long a live token can be used to open a stream. Once
+// open, a stream stays open like one opened with a bearer token.
+const liveTokenTTL = time.Minute
+
+const liveTokenKey = "live_token"
+
+// liveStreamRoutes accept a live token in the token query parameter, since
+// a browser's EventSource can't send an Authorization header.
+var liveStreamRoutes = map[string]bool{"/live": true, "/live/:server_id": true}
+
+// takeLiveToken moves the token query parameter into the context before the
+// request is logged, so tokens don't end up in logs.
+func takeLiveToken(c *gin.Context) {
+	q := c.Request.URL.Query()
+	if token := q.Get("token"); token != "" {
+		c.Set(liveTokenKey, token)
+		q.Del("token")
+		c.Request.URL.RawQuery = q.Encode()
+	}
+	c.Next()
+}
+
+// newLiveToken signs p and an expiry time with liveKey.
+func (s *RESTServer) newLiveToken(p Principal, expiresAt time.Time) string {
+	payload, _ := json.Marshal(liveTokenPayload{Name: p.Name, Role: p.Role, Expires: expiresAt.Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signLiveToken(encoded)
+}
+
+type liveTokenPayload struct {
+	Name    string `json:"n"`
+	Role    string `json:"r"`
+	Expires int64  `json:"e"`
+}
+
+func (s *RESTServer) signLiveToken(encoded string) string {
+	mac := hmac.New(sha256.New, s.liveKey)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkLiveToken returns the principal a live token was issued to, if it is
+// genuine and hasn't expired at now.
+func (s *RESTServer) checkLiveToken(token string, now time.Time) (Principal, bool) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.signLiveToken(encoded))) {
+		return Principal{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Principal{}, false
+	}
+	var payload liveTokenPayload
+	if json.Unmarshal(data, &payload) != nil || now.Unix() >= payload.Expires {
+		return Principal{}, false
+	}
+	return Principal{Name: payload.Name, Role: payload.Role, Kind: "live_token"}, true
+}
+
+// handleLiveToken issues a live token for the caller, to open /live with
+// ?token= where the Authorization header can't be set.
+func (s *RESTServer) handleLiveToken(c *gin.Context) {
+	expiresAt := time.Now().Add(liveTokenTTL)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"token": s.newLiveToken(principalOf(c), expiresAt), "expires_at": expiresAt})
+}
+
+// requireRole rejects callers whose role is below role.
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if roleLevels[principalOf(c).Role] < roleLevels[role] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires the " + role + " role"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleLogin exchanges a username and password for a session token.
+func (s *RESTServer) handleLogin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.Store.GetUser(c.Request.Context(), req.Username)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hash := dummyHash
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
+		return
+	}
+
+	token, err := newSecret("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	expiresAt := time.Now().Add(s.Config.Auth.SessionTTL)
+	if err := s.Store.CreateSession(c.Request.Context(), hashSecret(token), user.Username, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt, "user": user})
+}
+
+// handleLogout ends the caller's session. Other credentials are unaffected.
+func (s *RESTServer) handleLogout(c *gin.Context) {
+	if p := principalOf(c); p.sessionHash != "" {
+		if err := s.Store.DeleteSession(c.Request.Context(), p.sessionHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *RESTServer) handleWhoAmI(c *gin.Context) {
+	c.JSON(http.StatusOK, principalOf(c))
+}
+
+func (s *RESTServer) handleListUsers(c *gin.Context) {
+	users, err := s.Store.ListUsers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (s *RESTServer) handleCreateUser(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateUser(req.Username, req.Role, req.Password, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.Store.CreateUser(c.Request.Context(), User{Username: req.Username, Role: req.Role, PasswordHash: hash})
+	if errors.Is(err, ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "user already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// handleUpdateUser changes a user's role and/or password. Changing the
+// password logs the user out everywhere.
+func (s *RESTServer) handleUpdateUser(c *gin.Context) {
+	username := c.Param("username")
+	var req struct {
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateUser(username, req.Role, req.Password, false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if username == principalOf(c).Name && req.Role != "" && req.Role != RoleAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "you can't take the admin role from yourself"})
+		return
+	}
+	var hash string
+	if req.Password != "" {
+		var err error
+		if hash, err = HashPassword(req.Password); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := s.Store.UpdateUser(c.Request.Context(), username, req.Role, hash)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *RESTServer) handleDeleteUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == principalOf(c).Name {
+		c.JSON(http.StatusConflict, gin.H{"error": "you can't delete yourself"})
+		return
+	}
+	err := s.Store.DeleteUser(c.Request.Context(), username)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// validateUser checks the fields of a user. For updates, an empty role or
+// password means unchanged.
+func validateUser(username, role, password string, create bool) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("usernames may only contain letters, digits, '.', '_', '@' and '-'")
+	}
+	if (create || role != "") && roleLevels[role] == 0 {
+		return fmt.Errorf("role must be %q, %q or %q", RoleViewer, RoleOperator, RoleAdmin)
+	}
+	if (create || password != "") && len(password) < minPasswordLen {
+		return fmt.Errorf("passwords must be at least %d characters", minPasswordLen)
+	}
+	return nil
+}
+
+func (s *RESTServer) handleListAPIKeys(c *gin.Context) {
+	keys, err := s.Store.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// handleCreateAPIKey creates a key and returns it. This is the only time the
+// key itself is shown.
+func (s *RESTServer) handleCreateAPIKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if roleLevels[req.Role] == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("role must be %q, %q or %q", RoleViewer, RoleOperator, RoleAdmin)})
+		return
+	}
+
+	secret, err := newSecret(apiKeyPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key, err := s.Store.CreateAPIKey(c.Request.Context(), APIKey{
+		Name:      req.Name,
+		Prefix:    secret[:apiKeyShownLen],
+		Role:      req.Role,
+		CreatedBy: principalOf(c).Name,
+	}, hashSecret(secret))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"key": secret, "api_key": key})
+}
+
+func (s *RESTServer) handleDeleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	err = s.Store.DeleteAPIKey(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
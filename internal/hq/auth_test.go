@@ -0,0 +1,69 @@
+package hq
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveToken(t *testing.T) {
+	s := &RESTServer{liveKey: []byte("key-one")}
+	now := time.Now()
+	token := s.newLiveToken(Principal{Name: "alice", Role: RoleViewer, Kind: "session"}, now.Add(liveTokenTTL))
+
+	p, ok := s.checkLiveToken(token, now)
+	if !ok || p.Name != "alice" || p.Role != RoleViewer || p.Kind != "live_token" {
+		t.Errorf("checkLiveToken = %+v, %t; want alice as a viewer", p, ok)
+	}
+	if _, ok := s.checkLiveToken(token, now.Add(liveTokenTTL)); ok {
+		t.Error("expired token was accepted")
+	}
+
+	// Another role in the payload doesn't match the signature.
+	forged := (&RESTServer{liveKey: []byte("key-two")}).newLiveToken(Principal{Name: "alice", Role: RoleAdmin}, now.Add(liveTokenTTL))
+	payload, _, _ := strings.Cut(forged, ".")
+	_, sig, _ := strings.Cut(token, ".")
+	for _, bad := range []string{"", "garbage", forged, payload + "." + sig} {
+		if _, ok := s.checkLiveToken(bad, now); ok {
+			t.Errorf("checkLiveToken(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLiveTokenOpensOnlyLiveStreams(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.Enabled = true
+	s := NewRESTServer(nil, nil, NewLiveBroker(), NewLatestCache(), cfg)
+	srv := httptest.NewServer(s.Router)
+	defer srv.Close()
+	defer s.Live.Close()
+	token := s.newLiveToken(Principal{Name: "alice", Role: RoleViewer}, time.Now().Add(liveTokenTTL))
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/live/web-1", http.StatusUnauthorized},
+		{"/live/web-1?token=bad", http.StatusUnauthorized},
+		{"/servers/web-1/latest?token=" + token, http.StatusUnauthorized},
+		{"/live/web-1?metric=cpu_usage&token=" + token, http.StatusOK},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, resp.StatusCode, tc.want)
+		}
+		if resp.StatusCode == http.StatusOK {
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			if err != nil || line != ": connected\n" {
+				t.Errorf("stream starts with %q, %v", line, err)
+			}
+		}
+		resp.Body.Close()
+	}
+}
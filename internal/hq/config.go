@@ -0,0 +1,414 @@
+package hq
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ConfigPathEnv names the HQ config file when no -config flag is given.
+const ConfigPathEnv = "SENTINEL_HQ_CONFIG"
+
+// redacted replaces secrets in the output of Redacted.
+const redacted = "REDACTED"
+
+// Config is the HQ server's configuration. It is read from a YAML (or JSON)
+// file; SENTINEL_HQ_* environment variables override the file and flags
+// override both.
+type Config struct {
+	GRPC      ListenConfig    `yaml:"grpc"`
+	HTTP      ListenConfig    `yaml:"http"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Retention RetentionConfig `yaml:"retention"`
+	Auth      AuthConfig      `yaml:"auth"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Agents    AgentsConfig    `yaml:"agents"`
+
+	// ShutdownTimeout is how long open requests and streams get to finish
+	// on shutdown before they are cut off.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ShutdownReadinessDelay is how long HQ keeps serving with /readyz and
+	// the gRPC health checks failing before it closes its listeners, so
+	// load balancers stop sending it traffic first.
+	ShutdownReadinessDelay time.Duration `yaml:"shutdown_readiness_delay"`
+}
+
+// ListenConfig is where a server listens. Setting a certificate and key
+// turns on TLS.
+type ListenConfig struct {
+	Listen   string `yaml:"listen"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// TLS reports whether the server should serve TLS.
+func (l ListenConfig) TLS() bool {
+	return l.CertFile != ""
+}
+
+type DatabaseConfig struct {
+	URL string `yaml:"url"`
+	// Pool sizing; zero leaves the pgx default.
+	MaxConns        int32         `yaml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time"`
+}
+
+// RetentionConfig is how long data is kept. Zero keeps it forever.
+type RetentionConfig struct {
+	Metrics       time.Duration `yaml:"metrics"`
+	Events        time.Duration `yaml:"events"`
+	PruneInterval time.Duration `yaml:"prune_interval"`
+}
+
+// AuthConfig protects the REST API. When enabled, every request needs an
+// "Authorization: Bearer <token>" header with a session token from
+// /auth/login, an API key or one of Tokens. It can only be disabled while
+// the REST API listens on a loopback address.
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Tokens are static tokens with the admin role, for automation that
+	// can't use API keys.
+	Tokens     []string      `yaml:"tokens"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// Admin is created on startup while there are no users, so there is
+	// someone to log in as.
+	Admin AdminConfig `yaml:"admin"`
+}
+
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// CORSConfig lists the browser origins allowed to call the REST API, e.g.
+// "https://dashboard.example.com". "*" allows any origin. By default no
+// origin is allowed.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+type AgentsConfig struct {
+	// ServiceStaleAfter is how long a service may go unreported before its
+	// state becomes unknown. Agents that report services less often get
+	// DefaultServiceStaleIntervals of their own interval instead.
+	ServiceStaleAfter  time.Duration `yaml:"service_stale_after"`
+	ConfigPollInterval time.Duration `yaml:"config_poll_interval"`
+	ConflictPolicy     string        `yaml:"conflict_policy"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		GRPC: ListenConfig{Listen: ":9090"},
+		HTTP: ListenConfig{Listen: "127.0.0.1:8080"},
+		Database: DatabaseConfig{
+			URL: "postgres://localhost:5432/sentinel?sslmode=disable",
+		},
+		Retention: RetentionConfig{PruneInterval: time.Hour},
+		Auth:      AuthConfig{SessionTTL: 12 * time.Hour},
+		Agents: AgentsConfig{
+			ServiceStaleAfter:  DefaultServiceStaleIntervals * DefaultCollectionInterval,
+			ConfigPollInterval: DefaultConfigPollInterval,
+			ConflictPolicy:     ConflictAllow,
+		},
+		ShutdownTimeout:        15 * time.Second,
+		ShutdownReadinessDelay: 5 * time.Second,
+	}
+}
+
+// Setting is a config field that can also be set from the environment and
+// the command line.
+type Setting struct {
+	Key   string // dotted path in the config file, e.g. "grpc.listen"
+	Usage string
+	set   func(c *Config, v string) error
+}
+
+// EnvVar is the environment variable for the setting, e.g.
+// SENTINEL_HQ_GRPC_LISTEN.
+func (s Setting) EnvVar() string {
+	return "SENTINEL_HQ_" + strings.ToUpper(strings.ReplaceAll(s.Key, ".", "_"))
+}
+
+// FlagName is the command line flag for the setting, e.g. grpc-listen.
+func (s Setting) FlagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.Key)
+}
+
+// Settings are all the fields that can be overridden. Lists are given comma
+// separated.
+var Settings = []Setting{
+	{"grpc.listen", "gRPC listen address", setString(func(c *Config) *string { return &c.GRPC.Listen })},
+	{"grpc.cert_file", "gRPC TLS certificate", setString(func(c *Config) *string { return &c.GRPC.CertFile })},
+	{"grpc.key_file", "gRPC TLS private key", setString(func(c *Config) *string { return &c.GRPC.KeyFile })},
+	{"http.listen", "REST API listen address", setString(func(c *Config) *string { return &c.HTTP.Listen })},
+	{"http.cert_file", "REST API TLS certificate", setString(func(c *Config) *string { return &c.HTTP.CertFile })},
+	{"http.key_file", "REST API TLS private key", setString(func(c *Config) *string { return &c.HTTP.KeyFile })},
+	{"database.url", "PostgreSQL connection string", setString(func(c *Config) *string { return &c.Database.URL })},
+	{"database.max_conns", "maximum database connections", setInt32(func(c *Config) *int32 { return &c.Database.MaxConns })},
+	{"database.min_conns", "database connections kept open", setInt32(func(c *Config) *int32 { return &c.Database.MinConns })},
+	{"database.max_conn_lifetime", "maximum age of a database connection", setDuration(func(c *Config) *time.Duration { return &c.Database.MaxConnLifetime })},
+	{"database.max_conn_idle_time", "close database connections idle this long", setDuration(func(c *Config) *time.Duration { return &c.Database.MaxConnIdleTime })},
+	{"retention.metrics", "how long metrics are kept (0 = forever)", setDuration(func(c *Config) *time.Duration { return &c.Retention.Metrics })},
+	{"retention.events", "how long events are kept (0 = forever)", setDuration(func(c *Config) *time.Duration { return &c.Retention.Events })},
+	{"retention.prune_interval", "how often old data is deleted", setDuration(func(c *Config) *time.Duration { return &c.Retention.PruneInterval })},
+	{"auth.enabled", "require a bearer token on the REST API", setBool(func(c *Config) *bool { return &c.Auth.Enabled })},
+	{"auth.tokens", "static REST API tokens with the admin role", setList(func(c *Config) *[]string { return &c.Auth.Tokens })},
+	{"auth.session_ttl", "how long a login session lasts", setDuration(func(c *Config) *time.Duration { return &c.Auth.SessionTTL })},
+	{"auth.admin.username", "admin user created when there are no users", setString(func(c *Config) *string { return &c.Auth.Admin.Username })},
+	{"auth.admin.password", "password of the bootstrap admin user", setString(func(c *Config) *string { return &c.Auth.Admin.Password })},
+	{"cors.allowed_origins", "browser origins allowed to call the REST API", setList(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"agents.service_stale_after", "when an unreported service becomes unknown", setDuration(func(c *Config) *time.Duration { return &c.Agents.ServiceStaleAfter })},
+	{"agents.config_poll_interval", "how often agents' config profiles are checked for new versions", setDuration(func(c *Config) *time.Duration { return &c.Agents.ConfigPollInterval })},
+	{"agents.conflict_policy", "duplicate server_id streams: allow or reject", setString(func(c *Config) *string { return &c.Agents.ConflictPolicy })},
+	{"shutdown_timeout", "how long to drain requests and streams on shutdown", setDuration(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"shutdown_readiness_delay", "how long to fail readiness checks on shutdown before closing listeners", setDuration(func(c *Config) *time.Duration { return &c.ShutdownReadinessDelay })},
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt32(field func(*Config) *int32) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		*field(c) = int32(n)
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setList(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+// LoadConfig builds the HQ config: the defaults, then the file at path (if
+// any), then the environment, then flags (values by setting key). The result
+// is validated.
+func LoadConfig(path string, flags map[string]string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		path = os.Getenv(ConfigPathEnv)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalWithOptions(data, cfg, yaml.Strict()); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	// DATABASE_URL predates the other variables and is still honoured.
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		cfg.Database.URL = v
+	}
+	for _, s := range Settings {
+		if v, ok := os.LookupEnv(s.EnvVar()); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.EnvVar(), err)
+			}
+		}
+	}
+	for _, s := range Settings {
+		if v, ok := flags[s.Key]; ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.FlagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// minTokenLen keeps REST API tokens from being guessable.
+const minTokenLen = 16
+
+var originPattern = regexp.MustCompile(`^https?://[^/]+$`)
+
+// loopback reports whether addr, a host:port, only accepts connections from
+// the same machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Validate checks every field and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	for name, l := range map[string]ListenConfig{"grpc": c.GRPC, "http": c.HTTP} {
+		if _, _, err := net.SplitHostPort(l.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("%s.listen %q must be host:port", name, l.Listen))
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			errs = append(errs, fmt.Errorf("%s: cert_file and key_file must be set together", name))
+		}
+		for _, f := range []string{l.CertFile, l.KeyFile} {
+			if f == "" {
+				continue
+			}
+			if _, err := os.Stat(f); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	if _, err := pgxpool.ParseConfig(c.Database.URL); err != nil {
+		errs = append(errs, fmt.Errorf("database.url: %w", err))
+	}
+	if c.Database.MaxConns < 0 || c.Database.MinConns < 0 {
+		errs = append(errs, errors.New("database: max_conns and min_conns must not be negative"))
+	}
+	if c.Database.MaxConns > 0 && c.Database.MinConns > c.Database.MaxConns {
+		errs = append(errs, errors.New("database: min_conns is larger than max_conns"))
+	}
+	if c.Database.MaxConnLifetime < 0 || c.Database.MaxConnIdleTime < 0 {
+		errs = append(errs, errors.New("database: connection lifetimes must not be negative"))
+	}
+
+	if c.Retention.Metrics < 0 || c.Retention.Events < 0 {
+		errs = append(errs, errors.New("retention: durations must not be negative"))
+	}
+	if (c.Retention.Metrics > 0 || c.Retention.Events > 0) && c.Retention.PruneInterval <= 0 {
+		errs = append(errs, errors.New("retention.prune_interval must be positive"))
+	}
+
+	if !c.Auth.Enabled && !loopback(c.HTTP.Listen) {
+		errs = append(errs, fmt.Errorf("auth.enabled must be set when http.listen %q isn't a loopback address, or every caller would be an admin", c.HTTP.Listen))
+	}
+	if c.Auth.SessionTTL <= 0 {
+		errs = append(errs, errors.New("auth.session_ttl must be positive"))
+	}
+	if c.Auth.Admin.Username != "" {
+		if !usernamePattern.MatchString(c.Auth.Admin.Username) {
+			errs = append(errs, fmt.Errorf("auth.admin.username %q is not a valid username", c.Auth.Admin.Username))
+		}
+		if len(c.Auth.Admin.Password) < minPasswordLen {
+			errs = append(errs, fmt.Errorf("auth.admin.password must be at least %d characters", minPasswordLen))
+		}
+	}
+	for _, t := range c.Auth.Tokens {
+		if len(t) < minTokenLen {
+			errs = append(errs, fmt.Errorf("auth.tokens: tokens must be at least %d characters", minTokenLen))
+			break
+		}
+	}
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if o != "*" && !originPattern.MatchString(o) {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins: %q is not an origin like https://host[:port]", o))
+		}
+	}
+
+	if c.Agents.ServiceStaleAfter <= 0 {
+		errs = append(errs, errors.New("agents.service_stale_after must be positive"))
+	}
+	if c.Agents.ConfigPollInterval <= 0 {
+		errs = append(errs, errors.New("agents.config_poll_interval must be positive"))
+	}
+	if c.Agents.ConflictPolicy != ConflictAllow && c.Agents.ConflictPolicy != ConflictReject {
+		errs = append(errs, fmt.Errorf("agents.conflict_policy %q must be %q or %q", c.Agents.ConflictPolicy, ConflictAllow, ConflictReject))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.ShutdownReadinessDelay < 0 {
+		errs = append(errs, errors.New("shutdown_readiness_delay must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the config with passwords and tokens hidden,
+// safe to print or log.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Database.URL = redactDSN(c.Database.URL)
+	r.Auth.Tokens = make([]string, len(c.Auth.Tokens))
+	for i := range r.Auth.Tokens {
+		r.Auth.Tokens[i] = redacted
+	}
+	if r.Auth.Admin.Password != "" {
+		r.Auth.Admin.Password = redacted
+	}
+	return &r
+}
+
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactDSN hides the password of a URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// YAML returns the config in the layout of the config file.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
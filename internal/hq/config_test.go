@@ -0,0 +1,160 @@
+package hq
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateAuthOnPublicListener(t *testing.T) {
+	for _, tc := range []struct {
+		listen string
+		auth   bool
+		ok     bool
+	}{
+		{"127.0.0.1:8080", false, true},
+		{"localhost:8080", false, true},
+		{"[::1]:8080", false, true},
+		{":8080", false, false},
+		{"0.0.0.0:8080", false, false},
+		{"10.0.0.5:8080", false, false},
+		{"hq.example.com:8080", false, false},
+		{":8080", true, true},
+	} {
+		cfg := DefaultConfig()
+		cfg.HTTP.Listen = tc.listen
+		cfg.Auth.Enabled = tc.auth
+		if err := cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf("listen %s with auth %t: Validate() = %v, want ok=%t", tc.listen, tc.auth, err, tc.ok)
+		}
+	}
+}
+
+func TestDefaultConfigAllowsNoOrigins(t *testing.T) {
+	if origins := DefaultConfig().CORS.AllowedOrigins; len(origins) != 0 {
+		t.Errorf("default cors.allowed_origins = %v, want none", origins)
+	}
+}
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hq.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+grpc:
+  listen: ":9191"
+http:
+  listen: "127.0.0.1:8181"
+retention:
+  metrics: 720h
+  events: 168h
+`)
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("SENTINEL_HQ_HTTP_LISTEN", "127.0.0.1:8282")
+	t.Setenv("SENTINEL_HQ_RETENTION_METRICS", "48h")
+	cfg, err := LoadConfig(path, map[string]string{"retention.metrics": "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		got, want any
+	}{
+		{"default", cfg.Retention.PruneInterval, time.Hour},
+		{"file over default", cfg.GRPC.Listen, ":9191"},
+		{"file only", cfg.Retention.Events, 168 * time.Hour},
+		{"env over file", cfg.HTTP.Listen, "127.0.0.1:8282"},
+		{"flag over env", cfg.Retention.Metrics, 24 * time.Hour},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigPathFromEnv(t *testing.T) {
+	t.Setenv(ConfigPathEnv, writeConfig(t, "shutdown_timeout: 30s\n"))
+	cfg, err := LoadConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("shutdown_timeout = %s, want the file's 30s", cfg.ShutdownTimeout)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, file string
+		flags      map[string]string
+		want       string
+	}{
+		// A misspelt key mustn't silently leave the default in place.
+		{"unknown key", "shutdown_timout: 30s\n", nil, "shutdown_timout"},
+		{"unknown nested key", "retention:\n  metric: 24h\n", nil, "metric"},
+		{"bad flag value", "", map[string]string{"retention.metrics": "soon"}, "-retention-metrics"},
+		{"invalid result", "", map[string]string{"shutdown_timeout": "0s"}, "shutdown_timeout must be positive"},
+	} {
+		_, err := LoadConfig(writeConfig(t, tc.file), tc.flags)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: LoadConfig() = %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	for _, tc := range []struct {
+		dsn, want string
+	}{
+		{"postgres://localhost:5432/sentinel?sslmode=disable", "postgres://localhost:5432/sentinel?sslmode=disable"},
+		{"postgres://sentinel:s3cret@db:5432/sentinel", "postgres://sentinel:REDACTED@db:5432/sentinel"},
+		{"postgres://sentinel@db/sentinel?password=s3cret&sslmode=require", "postgres://sentinel@db/sentinel?password=REDACTED&sslmode=require"},
+		{"host=db user=sentinel password=s3cret dbname=sentinel", "host=db user=sentinel password=REDACTED dbname=sentinel"},
+		{"host=db password = 's3 cret' dbname=sentinel", "host=db password = REDACTED dbname=sentinel"},
+		{"host=db PASSWORD=s3cret", "host=db PASSWORD=REDACTED"},
+		{"host=db user=sentinel", "host=db user=sentinel"},
+	} {
+		if got := redactDSN(tc.dsn); got != tc.want {
+			t.Errorf("redactDSN(%q) = %q, want %q", tc.dsn, got, tc.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Database.URL = "postgres://sentinel:s3cret@db/sentinel"
+	cfg.Auth.Tokens = []string{"0123456789abcdef", "fedcba9876543210"}
+	cfg.Auth.Admin = AdminConfig{Username: "admin", Password: "hunter2hunter2"}
+
+	data, err := cfg.Redacted().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, secret := range []string{"s3cret", "0123456789abcdef", "fedcba9876543210", "hunter2hunter2"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("redacted config shows %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "admin") || strings.Count(out, redacted) != 4 {
+		t.Errorf("want the username kept and 4 values redacted:\n%s", out)
+	}
+
+	// The original is left alone.
+	if cfg.Auth.Tokens[0] != "0123456789abcdef" || cfg.Auth.Admin.Password != "hunter2hunter2" || cfg.Database.URL != "postgres://sentinel:s3cret@db/sentinel" {
+		t.Errorf("Redacted changed the config it was called on: %+v", cfg)
+	}
+	// An unset password stays empty rather than looking set.
+	if got := DefaultConfig().Redacted().Auth.Admin.Password; got != "" {
+		t.Errorf("unset admin password redacted to %q", got)
+	}
+}
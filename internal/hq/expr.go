@@ -0,0 +1,661 @@
+package hq
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// This file parses query expressions, a small PromQL-like language:
+//
+//	cpu_usage{env="prod"}                        latest value of each series
+//	rate(log_lines{file=~".*app.*"}[5m])         range function over 5 minutes
+//	avg by (server_id) (disk_used_percent)       aggregation
+//	topk(3, max by (server_id) (cpu_usage))      top-k
+//	memory_used_percent / 100 > 0.9              arithmetic and comparisons
+//
+// A series' labels are its server_id, its server's labels and its metric
+// tags, tags taking precedence over server labels.
+
+// valueType is the type an expression evaluates to.
+type valueType string
+
+const (
+	typeScalar valueType = "scalar"
+	typeVector valueType = "vector" // one sample per series
+	typeMatrix valueType = "matrix" // a range of samples per series
+)
+
+func (t valueType) describe() string {
+	switch t {
+	case typeVector:
+		return "an instant vector"
+	case typeMatrix:
+		return "a range vector"
+	}
+	return "a scalar"
+}
+
+// Expr is a parsed query expression.
+type Expr interface {
+	Type() valueType
+}
+
+type NumberLiteral struct {
+	Value float64
+}
+
+// VectorSelector picks the series of a metric whose labels match. With a
+// Range it is a range vector.
+type VectorSelector struct {
+	Metric   string
+	Matchers []*LabelMatcher
+	Range    time.Duration
+}
+
+// LabelMatcher compares a label with a value. A missing label matches "".
+type LabelMatcher struct {
+	Name, Op, Value string
+	re              *regexp.Regexp
+}
+
+type Call struct {
+	Func *exprFunc
+	Args []Expr
+}
+
+// AggregateExpr aggregates a vector per group. Param is k for topk and
+// bottomk.
+type AggregateExpr struct {
+	Op       string
+	Param    Expr
+	Expr     Expr
+	Grouping []string
+	Without  bool
+}
+
+// BinaryExpr applies an arithmetic or comparison operator. Two vectors are
+// matched on all labels, or only on (or ignoring) Matching.
+type BinaryExpr struct {
+	Op       string
+	LHS, RHS Expr
+	Matching []string
+	On       bool
+}
+
+type UnaryExpr struct {
+	Expr Expr
+}
+
+func (*NumberLiteral) Type() valueType { return typeScalar }
+func (*Call) Type() valueType          { return typeVector }
+func (*AggregateExpr) Type() valueType { return typeVector }
+func (e *UnaryExpr) Type() valueType   { return e.Expr.Type() }
+
+func (e *VectorSelector) Type() valueType {
+	if e.Range > 0 {
+		return typeMatrix
+	}
+	return typeVector
+}
+
+func (e *BinaryExpr) Type() valueType {
+	if e.LHS.Type() == typeScalar && e.RHS.Type() == typeScalar {
+		return typeScalar
+	}
+	return typeVector
+}
+
+func (m *LabelMatcher) Matches(labels map[string]string) bool {
+	v := labels[m.Name]
+	switch m.Op {
+	case "=":
+		return v == m.Value
+	case "!=":
+		return v != m.Value
+	case "=~":
+		return m.re.MatchString(v)
+	default: // !~
+		return !m.re.MatchString(v)
+	}
+}
+
+// exprFunc is a function callable in expressions.
+type exprFunc struct {
+	Args []valueType
+	// rangeFn reduces the samples of a range; instantFn maps a value, with
+	// the scalar arguments after the first.
+	rangeFn   func(points []Point, window time.Duration) (float64, bool)
+	instantFn func(v float64, args []float64) float64
+}
+
+// aggregateOps are the aggregation operators; topk and bottomk take k first.
+var aggregateOps = map[string]bool{
+	"sum": true, "avg": true, "min": true, "max": true, "count": true, "topk": true, "bottomk": true,
+}
+
+// Binary operators by precedence, lowest first. ^ is right-associative.
+var binaryPrecedence = map[string]int{
+	"==": 1, "!=": 1, ">": 1, "<": 1, ">=": 1, "<=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+	"^": 5,
+}
+
+// unaryPrecedence sits between * and ^, so -2^2 is -4.
+const unaryPrecedence = 4
+
+func isComparison(op string) bool {
+	return binaryPrecedence[op] == 1
+}
+
+// Token kinds.
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexer splits an expression into tokens. Inside braces and the label lists
+// of by, without, on and ignoring, names may contain '-', as label keys do.
+type lexer struct {
+	input  string
+	pos    int
+	tokens []token
+
+	braces     bool
+	labelList  bool
+	afterLabel bool // the last token was by, without, on or ignoring
+}
+
+var labelListKeywords = map[string]bool{"by": true, "without": true, "on": true, "ignoring": true}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: input}
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		l.tokens = append(l.tokens, t)
+		if t.kind == tokEOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	afterLabel := l.afterLabel
+	l.afterLabel = false
+	c := l.input[l.pos]
+	rest := l.input[l.pos:]
+
+	switch {
+	case isIdentStart(c):
+		l.pos++
+		for l.pos < len(l.input) && l.isIdentChar(l.input[l.pos]) {
+			l.pos++
+		}
+		text := l.input[start:l.pos]
+		l.afterLabel = labelListKeywords[text]
+		return token{kind: tokIdent, text: text, pos: start}, nil
+
+	case c >= '0' && c <= '9' || c == '.':
+		l.pos++
+		for l.pos < len(l.input) {
+			c := l.input[l.pos]
+			exponentSign := (c == '+' || c == '-') && (l.input[l.pos-1] == 'e' || l.input[l.pos-1] == 'E')
+			if !isIdentChar(c) && !exponentSign {
+				break
+			}
+			l.pos++
+		}
+		text := l.input[start:l.pos]
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return token{kind: tokNumber, text: text, pos: start}, nil
+		}
+		if _, err := parseExprDuration(text); err == nil {
+			return token{kind: tokDuration, text: text, pos: start}, nil
+		}
+		return token{}, fmt.Errorf("invalid number or duration %q at position %d", text, start)
+
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.input) && l.input[l.pos] != '"' {
+			if l.input[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			return token{}, fmt.Errorf("unterminated string at position %d", start)
+		}
+		l.pos++
+		s, err := strconv.Unquote(l.input[start:l.pos])
+		if err != nil {
+			return token{}, fmt.Errorf("invalid string at position %d: %w", start, err)
+		}
+		return token{kind: tokString, text: s, pos: start}, nil
+	}
+
+	for _, op := range []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "=", "(", ")", "{", "}", "[", "]", ","} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			switch op {
+			case "{":
+				l.braces = true
+			case "}":
+				l.braces = false
+			case "(":
+				l.labelList = afterLabel
+			case ")":
+				l.labelList = false
+			}
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected character %q at position %d", c, start)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == ':' || c == '.'
+}
+
+func (l *lexer) isIdentChar(c byte) bool {
+	return isIdentChar(c) || c == '-' && (l.braces || l.labelList)
+}
+
+// parseExprDuration parses durations such as 30s, 5m, 1h30m, 2d or 1w.
+func parseExprDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond, "s": time.Second, "m": time.Minute,
+		"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour,
+	}
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[i:]
+		j := strings.IndexFunc(rest, func(r rune) bool { return r >= '0' && r <= '9' })
+		if j < 0 {
+			j = len(rest)
+		}
+		unit, ok := units[rest[:j]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * unit
+		rest = rest[j:]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return total, nil
+}
+
+// maxExprLen bounds the length of an expression.
+const maxExprLen = 4096
+
+// ParseExpr parses and type-checks a query expression. The result must be
+// a scalar or an instant vector.
+func ParseExpr(input string) (Expr, error) {
+	if len(input) > maxExprLen {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExprLen)
+	}
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if e.Type() == typeMatrix {
+		return nil, fmt.Errorf("a range vector can't be the result; use a function such as rate or avg_over_time")
+	}
+	return e, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("position %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isOp(text string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == text
+}
+
+func (p *parser) expectOp(text string) error {
+	if t := p.advance(); t.kind != tokOp || t.text != text {
+		return p.errorf(t, "expected %q, got %s", text, t)
+	}
+	return nil
+}
+
+// parseExpr parses binary expressions whose operators bind at least as
+// tightly as minPrec.
+func (p *parser) parseExpr(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := binaryPrecedence[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.advance()
+		b := &BinaryExpr{Op: t.text, LHS: lhs}
+		if next := p.peek(); next.kind == tokIdent && (next.text == "on" || next.text == "ignoring") {
+			p.advance()
+			b.On = next.text == "on"
+			if b.Matching, err = p.parseLabelList(); err != nil {
+				return nil, err
+			}
+		}
+		nextMin := prec + 1
+		if t.text == "^" {
+			nextMin = prec
+		}
+		if b.RHS, err = p.parseExpr(nextMin); err != nil {
+			return nil, err
+		}
+		if err := checkBinary(b); err != nil {
+			return nil, p.errorf(t, "%v", err)
+		}
+		lhs = b
+	}
+}
+
+func checkBinary(b *BinaryExpr) error {
+	for _, side := range []Expr{b.LHS, b.RHS} {
+		if side.Type() == typeMatrix {
+			return fmt.Errorf("operator %s can't be applied to a range vector", b.Op)
+		}
+	}
+	if b.Matching != nil && (b.LHS.Type() != typeVector || b.RHS.Type() != typeVector) {
+		return fmt.Errorf("on and ignoring only apply between two vectors")
+	}
+	return nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.isOp("-") || p.isOp("+") {
+		neg := p.advance().text == "-"
+		e, err := p.parseExpr(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type() == typeMatrix {
+			return nil, fmt.Errorf("unary minus can't be applied to a range vector")
+		}
+		if !neg {
+			return e, nil
+		}
+		if n, ok := e.(*NumberLiteral); ok {
+			return &NumberLiteral{Value: -n.Value}, nil
+		}
+		return &UnaryExpr{Expr: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokNumber:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return &NumberLiteral{Value: v}, nil
+
+	case tokOp:
+		if t.text != "(" {
+			break
+		}
+		e, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+
+	case tokIdent:
+		if aggregateOps[t.text] && (p.isOp("(") || p.peek().text == "by" || p.peek().text == "without") {
+			return p.parseAggregate(t)
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t)
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+func (p *parser) parseSelector(name token) (Expr, error) {
+	sel := &VectorSelector{Metric: name.text}
+	if p.isOp("{") {
+		p.advance()
+		for !p.isOp("}") {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if !p.isOp("}") {
+				if err := p.expectOp(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.advance()
+	}
+	if p.isOp("[") {
+		p.advance()
+		t := p.advance()
+		d, err := parseExprDuration(t.text)
+		if t.kind != tokDuration && t.kind != tokNumber || err != nil {
+			return nil, p.errorf(t, "expected a duration such as 5m, got %s", t)
+		}
+		sel.Range = d
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (*LabelMatcher, error) {
+	name := p.advance()
+	if name.kind != tokIdent {
+		return nil, p.errorf(name, "expected a label name, got %s", name)
+	}
+	op := p.advance()
+	switch op.text {
+	case "=", "!=", "=~", "!~":
+	default:
+		return nil, p.errorf(op, "expected =, !=, =~ or !~, got %s", op)
+	}
+	value := p.advance()
+	if value.kind != tokString {
+		return nil, p.errorf(value, "expected a quoted value, got %s", value)
+	}
+	m := &LabelMatcher{Name: name.text, Op: op.text, Value: value.text}
+	if op.text == "=~" || op.text == "!~" {
+		re, err := regexp.Compile("^(?:" + value.text + ")$")
+		if err != nil {
+			return nil, p.errorf(value, "invalid regular expression: %v", err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (p *parser) parseLabelList() ([]string, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for !p.isOp(")") {
+		t := p.advance()
+		if t.kind != tokIdent {
+			return nil, p.errorf(t, "expected a label name, got %s", t)
+		}
+		labels = append(labels, t.text)
+		if !p.isOp(")") {
+			if err := p.expectOp(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.advance()
+	return labels, nil
+}
+
+// parseAggregate parses sum(...), sum by (a) (...) and sum(...) by (a).
+func (p *parser) parseAggregate(op token) (Expr, error) {
+	agg := &AggregateExpr{Op: op.text}
+	parseGrouping := func() error {
+		t := p.peek()
+		if t.kind != tokIdent || t.text != "by" && t.text != "without" {
+			return nil
+		}
+		if agg.Grouping != nil {
+			return p.errorf(t, "more than one grouping")
+		}
+		p.advance()
+		agg.Without = t.text == "without"
+		var err error
+		agg.Grouping, err = p.parseLabelList()
+		return err
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	want := 1
+	if op.text == "topk" || op.text == "bottomk" {
+		want = 2
+	}
+	if len(args) != want {
+		return nil, p.errorf(op, "%s takes %d argument(s), got %d", op.text, want, len(args))
+	}
+	if want == 2 {
+		if args[0].Type() != typeScalar {
+			return nil, p.errorf(op, "the first argument of %s must be a number", op.text)
+		}
+		agg.Param = args[0]
+	}
+	agg.Expr = args[len(args)-1]
+	if agg.Expr.Type() != typeVector {
+		return nil, p.errorf(op, "%s expects an instant vector, got %s", op.text, agg.Expr.Type().describe())
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.text)
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(fn.Args) {
+		return nil, p.errorf(name, "%s takes %d argument(s), got %d", name.text, len(fn.Args), len(args))
+	}
+	for i, arg := range args {
+		if arg.Type() != fn.Args[i] {
+			return nil, p.errorf(name, "argument %d of %s must be %s, got %s", i+1, name.text, fn.Args[i].describe(), arg.Type().describe())
+		}
+	}
+	return &Call{Func: fn, Args: args}, nil
+}
+
+func (p *parser) parseArgs() ([]Expr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for !p.isOp(")") {
+		e, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+		if !p.isOp(")") {
+			if err := p.expectOp(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.advance()
+	return args, nil
+}
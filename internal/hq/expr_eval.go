@@ -0,0 +1,540 @@
+package hq
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExprLookback is how far back an instant vector selector looks for the
+// latest point of a series.
+const ExprLookback = 5 * time.Minute
+
+// ErrInvalidExpr is returned for an expression that fails on the data, such
+// as a binary operation with ambiguous matches.
+var ErrInvalidExpr = errors.New("invalid expression")
+
+// exprFuncs are the functions available in expressions.
+var exprFuncs = map[string]*exprFunc{
+	"rate":            {Args: rangeArg, rangeFn: rateOverRange},
+	"increase":        {Args: rangeArg, rangeFn: increaseOverRange},
+	"delta":           {Args: rangeArg, rangeFn: deltaOverRange},
+	"avg_over_time":   {Args: rangeArg, rangeFn: overTime(func(sum float64, n int, _ []Point) float64 { return sum / float64(n) })},
+	"sum_over_time":   {Args: rangeArg, rangeFn: overTime(func(sum float64, _ int, _ []Point) float64 { return sum })},
+	"count_over_time": {Args: rangeArg, rangeFn: overTime(func(_ float64, n int, _ []Point) float64 { return float64(n) })},
+	"last_over_time":  {Args: rangeArg, rangeFn: overTime(func(_ float64, _ int, ps []Point) float64 { return ps[len(ps)-1].Value })},
+	"min_over_time": {Args: rangeArg, rangeFn: overTime(func(_ float64, _ int, ps []Point) float64 {
+		return slices.MinFunc(ps, func(a, b Point) int { return cmp.Compare(a.Value, b.Value) }).Value
+	})},
+	"max_over_time": {Args: rangeArg, rangeFn: overTime(func(_ float64, _ int, ps []Point) float64 {
+		return slices.MaxFunc(ps, func(a, b Point) int { return cmp.Compare(a.Value, b.Value) }).Value
+	})},
+	"abs":   mathFunc(math.Abs),
+	"ceil":  mathFunc(math.Ceil),
+	"floor": mathFunc(math.Floor),
+	"round": mathFunc(math.Round),
+	"sqrt":  mathFunc(math.Sqrt),
+	"clamp_min": {Args: []valueType{typeVector, typeScalar},
+		instantFn: func(v float64, args []float64) float64 { return max(v, args[0]) }},
+	"clamp_max": {Args: []valueType{typeVector, typeScalar},
+		instantFn: func(v float64, args []float64) float64 { return min(v, args[0]) }},
+}
+
+var rangeArg = []valueType{typeMatrix}
+
+func mathFunc(fn func(float64) float64) *exprFunc {
+	return &exprFunc{Args: []valueType{typeVector}, instantFn: func(v float64, _ []float64) float64 { return fn(v) }}
+}
+
+func overTime(fn func(sum float64, n int, points []Point) float64) func([]Point, time.Duration) (float64, bool) {
+	return func(points []Point, _ time.Duration) (float64, bool) {
+		if len(points) == 0 {
+			return 0, false
+		}
+		var sum float64
+		for _, p := range points {
+			sum += p.Value
+		}
+		return fn(sum, len(points), points), true
+	}
+}
+
+// increaseOverRange is how much a counter grew from the first to the last
+// point of the range. A drop is taken as a counter reset.
+func increaseOverRange(points []Point, _ time.Duration) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	var total float64
+	for i := 1; i < len(points); i++ {
+		d := points[i].Value - points[i-1].Value
+		if d < 0 {
+			d = points[i].Value
+		}
+		total += d
+	}
+	return total, true
+}
+
+// rateOverRange is the per-second increase between the first and last
+// point of the range.
+func rateOverRange(points []Point, window time.Duration) (float64, bool) {
+	increase, ok := increaseOverRange(points, window)
+	if !ok {
+		return 0, false
+	}
+	elapsed := points[len(points)-1].Time.Sub(points[0].Time).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return increase / elapsed, true
+}
+
+func deltaOverRange(points []Point, _ time.Duration) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	return points[len(points)-1].Value - points[0].Value, true
+}
+
+// sample is the value of one series at the evaluation time.
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// EvalExpr evaluates e at every step from start to end, or only at end if
+// step is 0. Each vector selector is read from the store once, for the
+// whole range, before evaluating. Points that aren't finite, such as the
+// result of a division by zero, are left out.
+func EvalExpr(ctx context.Context, store MetricStore, e Expr, start, end time.Time, step time.Duration) ([]Series, error) {
+	if step == 0 {
+		start = end
+	}
+	ev := &evaluator{series: map[*VectorSelector][]Series{}}
+	for sel, read := range planExpr(e, start, end) {
+		series, err := store.ReadSeries(ctx, read)
+		if err != nil {
+			return nil, err
+		}
+		var matched []Series
+		for _, s := range series {
+			if matchesAll(sel.Matchers, s.Labels) {
+				matched = append(matched, s)
+			}
+		}
+		ev.series[sel] = matched
+	}
+
+	var result []Series
+	index := map[string]int{}
+	var points int
+	add := func(labels map[string]string, p Point) error {
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			return nil
+		}
+		if points++; points > MaxQueryPoints {
+			return ErrTooManyPoints
+		}
+		key := labelsKey(labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(result)
+			index[key] = i
+			result = append(result, Series{Labels: labels})
+		}
+		result[i].Points = append(result[i].Points, p)
+		return nil
+	}
+
+	for t := start; !t.After(end); t = t.Add(step) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ev.ts = t
+		v, err := ev.eval(e)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExpr, err)
+		}
+		switch v := v.(type) {
+		case float64:
+			err = add(map[string]string{}, Point{Time: t, Value: v})
+		case []sample:
+			for _, s := range v {
+				if err = add(s.labels, Point{Time: t, Value: s.value}); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if step == 0 {
+			break
+		}
+	}
+
+	// An instant query keeps the order of its result, such as the ranking
+	// of topk; a range query is sorted by labels.
+	if step > 0 {
+		sort.Slice(result, func(i, j int) bool { return labelsKey(result[i].Labels) < labelsKey(result[j].Labels) })
+	}
+	if result == nil {
+		result = []Series{}
+	}
+	return result, nil
+}
+
+// planExpr returns the reads needed to evaluate e from start to end: each
+// vector selector's metric over its range or the lookback, with its
+// equality matchers to narrow the read.
+func planExpr(e Expr, start, end time.Time) map[*VectorSelector]SeriesQuery {
+	reads := map[*VectorSelector]SeriesQuery{}
+	var walk func(e Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *VectorSelector:
+			window := ExprLookback
+			if e.Range > 0 {
+				window = e.Range
+			}
+			q := SeriesQuery{Metric: e.Metric, From: start.Add(-window), To: end, Equal: map[string]string{}}
+			for _, m := range e.Matchers {
+				if m.Op == "=" && m.Value != "" {
+					q.Equal[m.Name] = m.Value
+				}
+			}
+			reads[e] = q
+		case *Call:
+			for _, arg := range e.Args {
+				walk(arg)
+			}
+		case *AggregateExpr:
+			if e.Param != nil {
+				walk(e.Param)
+			}
+			walk(e.Expr)
+		case *BinaryExpr:
+			walk(e.LHS)
+			walk(e.RHS)
+		case *UnaryExpr:
+			walk(e.Expr)
+		}
+	}
+	walk(e)
+	return reads
+}
+
+func matchesAll(matchers []*LabelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluator evaluates an expression at one time, ts, on the series read
+// for each vector selector.
+type evaluator struct {
+	series map[*VectorSelector][]Series
+	ts     time.Time
+}
+
+// eval returns a float64 for scalars and a []sample for vectors.
+func (ev *evaluator) eval(e Expr) (any, error) {
+	switch e := e.(type) {
+	case *NumberLiteral:
+		return e.Value, nil
+	case *VectorSelector:
+		var out []sample
+		for _, s := range ev.series[e] {
+			points := pointsIn(s.Points, ev.ts.Add(-ExprLookback), ev.ts)
+			if len(points) > 0 {
+				out = append(out, sample{labels: s.Labels, value: points[len(points)-1].Value})
+			}
+		}
+		return out, nil
+	case *Call:
+		return ev.evalCall(e)
+	case *AggregateExpr:
+		return ev.evalAggregate(e)
+	case *BinaryExpr:
+		return ev.evalBinary(e)
+	case *UnaryExpr:
+		v, err := ev.eval(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := v.(float64); ok {
+			return -f, nil
+		}
+		var out []sample
+		for _, s := range v.([]sample) {
+			out = append(out, sample{labels: s.labels, value: -s.value})
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("can't evaluate %T", e)
+}
+
+// pointsIn returns the points in (from, to], which must be sorted by time.
+func pointsIn(points []Point, from, to time.Time) []Point {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Time.After(from) })
+	j := sort.Search(len(points), func(i int) bool { return points[i].Time.After(to) })
+	return points[i:j]
+}
+
+func (ev *evaluator) evalCall(e *Call) (any, error) {
+	var out []sample
+	if e.Func.rangeFn != nil {
+		sel := e.Args[0].(*VectorSelector)
+		for _, s := range ev.series[sel] {
+			if v, ok := e.Func.rangeFn(pointsIn(s.Points, ev.ts.Add(-sel.Range), ev.ts), sel.Range); ok {
+				out = append(out, sample{labels: s.Labels, value: v})
+			}
+		}
+		return out, nil
+	}
+
+	v, err := ev.eval(e.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	var args []float64
+	for _, arg := range e.Args[1:] {
+		a, err := ev.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a.(float64))
+	}
+	for _, s := range v.([]sample) {
+		out = append(out, sample{labels: s.labels, value: e.Func.instantFn(s.value, args)})
+	}
+	return out, nil
+}
+
+func (ev *evaluator) evalAggregate(e *AggregateExpr) (any, error) {
+	v, err := ev.eval(e.Expr)
+	if err != nil {
+		return nil, err
+	}
+	var k int
+	if e.Param != nil {
+		p, err := ev.eval(e.Param)
+		if err != nil {
+			return nil, err
+		}
+		if k = int(p.(float64)); k < 1 {
+			return []sample{}, nil
+		}
+	}
+
+	type group struct {
+		labels  map[string]string
+		samples []sample
+	}
+	groups := map[string]*group{}
+	var keys []string
+	for _, s := range v.([]sample) {
+		labels := groupLabels(s.labels, e.Grouping, !e.Without)
+		key := labelsKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.samples = append(g.samples, s)
+	}
+	sort.Strings(keys)
+
+	var out []sample
+	for _, key := range keys {
+		g := groups[key]
+		switch e.Op {
+		case "topk", "bottomk":
+			ranked := slices.Clone(g.samples)
+			sort.SliceStable(ranked, func(i, j int) bool {
+				if e.Op == "topk" {
+					return ranked[i].value > ranked[j].value
+				}
+				return ranked[i].value < ranked[j].value
+			})
+			out = append(out, ranked[:min(k, len(ranked))]...)
+			continue
+		}
+
+		value := g.samples[0].value
+		for _, s := range g.samples[1:] {
+			switch e.Op {
+			case "sum", "avg":
+				value += s.value
+			case "min":
+				value = min(value, s.value)
+			case "max":
+				value = max(value, s.value)
+			}
+		}
+		switch e.Op {
+		case "avg":
+			value /= float64(len(g.samples))
+		case "count":
+			value = float64(len(g.samples))
+		}
+		out = append(out, sample{labels: g.labels, value: value})
+	}
+	return out, nil
+}
+
+// groupLabels returns the labels among names if include is set, and the
+// others if not.
+func groupLabels(labels map[string]string, names []string, include bool) map[string]string {
+	out := map[string]string{}
+	for k, v := range labels {
+		if slices.Contains(names, k) == include {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// labelsKey identifies a label set.
+func labelsKey(labels map[string]string) string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func (ev *evaluator) evalBinary(e *BinaryExpr) (any, error) {
+	lhs, err := ev.eval(e.LHS)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := ev.eval(e.RHS)
+	if err != nil {
+		return nil, err
+	}
+
+	l, lScalar := lhs.(float64)
+	r, rScalar := rhs.(float64)
+	switch {
+	case lScalar && rScalar:
+		v, keep := applyBinary(e.Op, l, r)
+		if isComparison(e.Op) {
+			v = 0
+			if keep {
+				v = 1
+			}
+		}
+		return v, nil
+
+	case rScalar:
+		var out []sample
+		for _, s := range lhs.([]sample) {
+			if v, keep := applyBinary(e.Op, s.value, r); keep {
+				out = append(out, sample{labels: s.labels, value: v})
+			}
+		}
+		return out, nil
+
+	case lScalar:
+		var out []sample
+		for _, s := range rhs.([]sample) {
+			v, keep := applyBinary(e.Op, l, s.value)
+			if isComparison(e.Op) {
+				// A filter keeps the vector's value, whichever side it's on.
+				v = s.value
+			}
+			if keep {
+				out = append(out, sample{labels: s.labels, value: v})
+			}
+		}
+		return out, nil
+	}
+
+	// Two vectors are matched one-to-one on their labels.
+	signature := func(labels map[string]string) map[string]string {
+		if e.Matching == nil {
+			return labels
+		}
+		return groupLabels(labels, e.Matching, e.On)
+	}
+	right := map[string]sample{}
+	for _, s := range rhs.([]sample) {
+		key := labelsKey(signature(s.labels))
+		if _, dup := right[key]; dup {
+			return nil, fmt.Errorf("more than one series on the right of %s has the labels %v; use on or ignoring", e.Op, signature(s.labels))
+		}
+		right[key] = s
+	}
+	seen := map[string]bool{}
+	var out []sample
+	for _, s := range lhs.([]sample) {
+		sig := signature(s.labels)
+		key := labelsKey(sig)
+		match, ok := right[key]
+		if !ok {
+			continue
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("more than one series on the left of %s has the labels %v; use on or ignoring", e.Op, sig)
+		}
+		seen[key] = true
+		v, keep := applyBinary(e.Op, s.value, match.value)
+		if !keep {
+			continue
+		}
+		labels := sig
+		if isComparison(e.Op) {
+			labels = s.labels
+		}
+		out = append(out, sample{labels: labels, value: v})
+	}
+	return out, nil
+}
+
+// applyBinary applies op to a and b. For comparisons the value is a and
+// keep tells whether the comparison holds.
+func applyBinary(op string, a, b float64) (v float64, keep bool) {
+	switch op {
+	case "+":
+		return a + b, true
+	case "-":
+		return a - b, true
+	case "*":
+		return a * b, true
+	case "/":
+		return a / b, true
+	case "%":
+		return math.Mod(a, b), true
+	case "^":
+		return math.Pow(a, b), true
+	case "==":
+		return a, a == b
+	case "!=":
+		return a, a != b
+	case ">":
+		return a, a > b
+	case "<":
+		return a, a < b
+	case ">=":
+		return a, a >= b
+	case "<=":
+		return a, a <= b
+	}
+	return math.NaN(), false
+}
@@ -0,0 +1,365 @@
+package hq
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exprString prints a parsed expression with every binary and unary
+// expression in parentheses, so the tree's shape can be compared.
+func exprString(e Expr) string {
+	switch e := e.(type) {
+	case *NumberLiteral:
+		return strconv.FormatFloat(e.Value, 'g', -1, 64)
+	case *VectorSelector:
+		s := e.Metric
+		if len(e.Matchers) > 0 {
+			var ms []string
+			for _, m := range e.Matchers {
+				ms = append(ms, m.Name+m.Op+strconv.Quote(m.Value))
+			}
+			s += "{" + strings.Join(ms, ",") + "}"
+		}
+		if e.Range > 0 {
+			s += "[" + e.Range.String() + "]"
+		}
+		return s
+	case *Call:
+		var args []string
+		for _, a := range e.Args {
+			args = append(args, exprString(a))
+		}
+		for name, fn := range exprFuncs {
+			if fn == e.Func {
+				return name + "(" + strings.Join(args, ", ") + ")"
+			}
+		}
+	case *AggregateExpr:
+		s := e.Op
+		if e.Grouping != nil {
+			if e.Without {
+				s += " without"
+			} else {
+				s += " by"
+			}
+			s += " (" + strings.Join(e.Grouping, ",") + ")"
+		}
+		s += " ("
+		if e.Param != nil {
+			s += exprString(e.Param) + ", "
+		}
+		return s + exprString(e.Expr) + ")"
+	case *BinaryExpr:
+		op := e.Op
+		if e.Matching != nil {
+			if e.On {
+				op += " on"
+			} else {
+				op += " ignoring"
+			}
+			op += "(" + strings.Join(e.Matching, ",") + ")"
+		}
+		return "(" + exprString(e.LHS) + " " + op + " " + exprString(e.RHS) + ")"
+	case *UnaryExpr:
+		return "(-" + exprString(e.Expr) + ")"
+	}
+	return "?"
+}
+
+func TestParseExpr(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  string
+		typ   valueType
+	}{
+		{"42", "42", typeScalar},
+		{"-1", "-1", typeScalar},
+		// Unary minus binds less tightly than ^, so this is -(2^2).
+		{"-2^2", "(-(2 ^ 2))", typeScalar},
+		{"2^3^2", "(2 ^ (3 ^ 2))", typeScalar},
+		{"(-2)^2", "(-2 ^ 2)", typeScalar},
+		{"1 + 2 * 3", "(1 + (2 * 3))", typeScalar},
+		{"(1 + 2) * 3", "((1 + 2) * 3)", typeScalar},
+		{"8 - 4 - 2", "((8 - 4) - 2)", typeScalar},
+		{"-cpu_usage * 2", "((-cpu_usage) * 2)", typeVector},
+		{"memory_used_percent / 100 > 0.9", "((memory_used_percent / 100) > 0.9)", typeVector},
+		{`cpu_usage{env="prod", role=~"db|cache", dc!="", host!~"test-.*"}`,
+			`cpu_usage{env="prod",role=~"db|cache",dc!="",host!~"test-.*"}`, typeVector},
+		{"rate(log_lines[5m])", "rate(log_lines[5m0s])", typeVector},
+		{"avg_over_time(cpu_usage[1h30m])", "avg_over_time(cpu_usage[1h30m0s])", typeVector},
+		{"clamp_max(cpu_usage, 100)", "clamp_max(cpu_usage, 100)", typeVector},
+		{"avg by (server_id) (disk_used_percent)", "avg by (server_id) (disk_used_percent)", typeVector},
+		{"avg(disk_used_percent) by (server_id)", "avg by (server_id) (disk_used_percent)", typeVector},
+		{"sum without (core) (cpu_usage)", "sum without (core) (cpu_usage)", typeVector},
+		{"count by (k8s-app) (up)", "count by (k8s-app) (up)", typeVector},
+		{"topk(3, max by (server_id) (cpu_usage))", "topk (3, max by (server_id) (cpu_usage))", typeVector},
+		{"a / on(server_id) b", "(a / on(server_id) b)", typeVector},
+		{"a + ignoring(unit, type) b", "(a + ignoring(unit,type) b)", typeVector},
+		{"a > on() b", "(a > on() b)", typeVector},
+		// Matching belongs to its operator only.
+		{"a * on(x) b + c", "((a * on(x) b) + c)", typeVector},
+	} {
+		e, err := ParseExpr(tc.input)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tc.input, err)
+			continue
+		}
+		if got := exprString(e); got != tc.want {
+			t.Errorf("ParseExpr(%q) = %s, want %s", tc.input, got, tc.want)
+		}
+		if e.Type() != tc.typ {
+			t.Errorf("ParseExpr(%q) is %s, want %s", tc.input, e.Type(), tc.typ)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"", "unexpected end of expression"},
+		{"a +", "unexpected end of expression"},
+		{"a b", `unexpected "b"`},
+		{"(1 + 2", `expected ")"`},
+		{"cpu_usage[5m]", "range vector can't be the result"},
+		{"rate(cpu_usage)", "argument 1 of rate must be a range vector"},
+		{"abs(cpu_usage[5m])", "argument 1 of abs must be an instant vector"},
+		{"nope(cpu_usage)", `unknown function "nope"`},
+		{"-cpu_usage[5m]", "unary minus can't be applied to a range vector"},
+		{"cpu_usage[5m] + 1", "can't be applied to a range vector"},
+		{"1 + on(a) 2", "on and ignoring only apply between two vectors"},
+		{"topk(a, b)", "first argument of topk must be a number"},
+		{"topk(b)", "topk takes 2 argument(s), got 1"},
+		{"sum by (a) (x) by (b)", "more than one grouping"},
+		{"sum(1)", "sum expects an instant vector, got a scalar"},
+		{`a{env=prod}`, "expected a quoted value"},
+		{`a{env "x"}`, "expected =, !=, =~ or !~"},
+		{`a{env~"x"}`, "unexpected character '~'"},
+		{`a{env=~"("}`, "invalid regular expression"},
+		{"a[5]", "expected a duration"},
+		{"a[5x]", `invalid number or duration "5x"`},
+		{strings.Repeat("a+", maxExprLen), "longer than"},
+	} {
+		_, err := ParseExpr(tc.input)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseExpr(%q) error = %v, want it to mention %q", tc.input, err, tc.want)
+		}
+	}
+}
+
+// seriesStore serves ReadSeries from fixed series, narrowed like the
+// database narrows them, and records the queries. Nothing else is
+// implemented.
+type seriesStore struct {
+	MetricStore
+	series  map[string][]Series
+	queries []SeriesQuery
+}
+
+func (f *seriesStore) ReadSeries(ctx context.Context, q SeriesQuery) ([]Series, error) {
+	f.queries = append(f.queries, q)
+	var out []Series
+	for _, s := range f.series[q.Metric] {
+		if !selectorMatches(q.Equal, s.Labels) {
+			continue
+		}
+		var points []Point
+		for _, p := range s.Points {
+			if p.Time.After(q.From) && !p.Time.After(q.To) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			out = append(out, Series{Labels: s.Labels, Points: points})
+		}
+	}
+	return out, nil
+}
+
+func selectorMatches(equal, labels map[string]string) bool {
+	for k, v := range equal {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// evalTime is when the test expressions are evaluated.
+var evalTime = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// at returns points ago before evalTime, oldest first.
+func at(values map[time.Duration]float64) []Point {
+	var points []Point
+	for _, ago := range slices.Sorted(maps.Keys(values)) {
+		points = append(points, Point{Time: evalTime.Add(-ago), Value: values[ago]})
+	}
+	slices.Reverse(points)
+	return points
+}
+
+func newSeriesStore() *seriesStore {
+	return &seriesStore{series: map[string][]Series{
+		"cpu_usage": {
+			{Labels: map[string]string{"server_id": "web-1", "env": "prod"}, Points: at(map[time.Duration]float64{2 * time.Minute: 40, time.Minute: 50})},
+			{Labels: map[string]string{"server_id": "web-2", "env": "prod"}, Points: at(map[time.Duration]float64{2 * time.Minute: 60, time.Minute: 70})},
+			{Labels: map[string]string{"server_id": "db-1", "env": "dev"}, Points: at(map[time.Duration]float64{2 * time.Minute: 30, time.Minute: 30})},
+			// Older than ExprLookback, so it has no current value.
+			{Labels: map[string]string{"server_id": "old-1", "env": "prod"}, Points: at(map[time.Duration]float64{10 * time.Minute: 99})},
+		},
+		// A counter that resets between 3 and 2 minutes ago.
+		"log_lines": {
+			{Labels: map[string]string{"server_id": "web-1"}, Points: at(map[time.Duration]float64{
+				4 * time.Minute: 10, 3 * time.Minute: 20, 2 * time.Minute: 5, time.Minute: 15,
+			})},
+		},
+		"mem_used": {
+			{Labels: map[string]string{"server_id": "web-1", "unit": "gb"}, Points: at(map[time.Duration]float64{time.Minute: 3})},
+			{Labels: map[string]string{"server_id": "web-2", "unit": "gb"}, Points: at(map[time.Duration]float64{time.Minute: 6})},
+		},
+		"mem_total": {
+			{Labels: map[string]string{"server_id": "web-1", "unit": "bytes"}, Points: at(map[time.Duration]float64{time.Minute: 4})},
+			{Labels: map[string]string{"server_id": "web-2", "unit": "bytes"}, Points: at(map[time.Duration]float64{time.Minute: 8})},
+		},
+		// web-b and web-c tie, in the order the store returns them.
+		"load": {
+			{Labels: map[string]string{"server_id": "web-a"}, Points: at(map[time.Duration]float64{time.Minute: 5})},
+			{Labels: map[string]string{"server_id": "web-c"}, Points: at(map[time.Duration]float64{time.Minute: 7})},
+			{Labels: map[string]string{"server_id": "web-b"}, Points: at(map[time.Duration]float64{time.Minute: 7})},
+		},
+	}}
+}
+
+// resultString prints each series as its labels and values, in order.
+func resultString(result []Series) []string {
+	out := []string{}
+	for _, s := range result {
+		var labels, values []string
+		for _, k := range slices.Sorted(maps.Keys(s.Labels)) {
+			labels = append(labels, k+"="+s.Labels[k])
+		}
+		for _, p := range s.Points {
+			values = append(values, strconv.FormatFloat(p.Value, 'g', 4, 64))
+		}
+		out = append(out, "{"+strings.Join(labels, ",")+"} "+strings.Join(values, " "))
+	}
+	return out
+}
+
+func TestEvalExprInstant(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  []string
+	}{
+		{"-2^2", []string{"{} -4"}},
+		{"2 > 1", []string{"{} 1"}},
+		{`cpu_usage{env="prod"}`, []string{"{env=prod,server_id=web-1} 50", "{env=prod,server_id=web-2} 70"}},
+		{`cpu_usage{server_id=~"web-.*"} > 60`, []string{"{env=prod,server_id=web-2} 70"}},
+		// A comparison with the scalar first still keeps the vector's value.
+		{`60 < cpu_usage`, []string{"{env=prod,server_id=web-2} 70"}},
+		{"avg by (env) (cpu_usage)", []string{"{env=dev} 30", "{env=prod} 60"}},
+		{"count(cpu_usage)", []string{"{} 3"}},
+		// (20-10) + 5 after the reset + (15-5) over three minutes.
+		{"increase(log_lines[5m])", []string{"{server_id=web-1} 25"}},
+		{"rate(log_lines[5m])", []string{"{server_id=web-1} 0.1389"}},
+		{"delta(log_lines[5m])", []string{"{server_id=web-1} 5"}},
+		{"max_over_time(log_lines[5m])", []string{"{server_id=web-1} 20"}},
+		// Only the points after the reset are in the last 150 seconds.
+		{"increase(log_lines[150s])", []string{"{server_id=web-1} 10"}},
+		{"mem_used / on(server_id) mem_total", []string{"{server_id=web-1} 0.75", "{server_id=web-2} 0.75"}},
+		{"mem_used / ignoring(unit) mem_total", []string{"{server_id=web-1} 0.75", "{server_id=web-2} 0.75"}},
+		// Without on or ignoring, the differing units keep them apart.
+		{"mem_used / mem_total", []string{}},
+		// A comparison keeps the left side's labels.
+		{"mem_used >= on(server_id) mem_total - 1", []string{"{server_id=web-1,unit=gb} 3"}},
+		{"topk(2, load)", []string{"{server_id=web-c} 7", "{server_id=web-b} 7"}},
+		{"topk(1, load)", []string{"{server_id=web-c} 7"}},
+		{"bottomk(1, load)", []string{"{server_id=web-a} 5"}},
+		{"topk(0, load)", []string{}},
+		{"topk by (env) (1, cpu_usage)", []string{"{env=dev,server_id=db-1} 30", "{env=prod,server_id=web-2} 70"}},
+		// Division by zero isn't finite and is left out.
+		{"cpu_usage / 0", []string{}},
+	} {
+		e, err := ParseExpr(tc.input)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tc.input, err)
+			continue
+		}
+		result, err := EvalExpr(context.Background(), newSeriesStore(), e, evalTime, evalTime, 0)
+		if err != nil {
+			t.Errorf("EvalExpr(%q): %v", tc.input, err)
+			continue
+		}
+		if got := resultString(result); !slices.Equal(got, tc.want) {
+			t.Errorf("EvalExpr(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestEvalExprRange(t *testing.T) {
+	e, err := ParseExpr("sum by (env) (cpu_usage)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := EvalExpr(context.Background(), newSeriesStore(), e, evalTime.Add(-2*time.Minute), evalTime, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// old-1's point is too old for every step.
+	want := []string{"{env=dev} 30 30 30", "{env=prod} 100 120 120"}
+	if got := resultString(result); !slices.Equal(got, want) {
+		t.Errorf("range result = %q, want %q", got, want)
+	}
+}
+
+func TestEvalExprReads(t *testing.T) {
+	store := newSeriesStore()
+	e, err := ParseExpr(`rate(log_lines{server_id="web-1", file=~".*"}[10m]) + cpu_usage{env="prod", dc=""}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalExpr(context.Background(), store, e, evalTime.Add(-time.Hour), evalTime, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.queries) != 2 {
+		t.Fatalf("%d reads, want one per selector: %+v", len(store.queries), store.queries)
+	}
+	byMetric := map[string]SeriesQuery{}
+	for _, q := range store.queries {
+		byMetric[q.Metric] = q
+	}
+	// Each selector is read once for the whole range plus its window, with
+	// only its non-empty equality matchers.
+	for metric, want := range map[string]SeriesQuery{
+		"log_lines": {From: evalTime.Add(-time.Hour - 10*time.Minute), Equal: map[string]string{"server_id": "web-1"}},
+		"cpu_usage": {From: evalTime.Add(-time.Hour - ExprLookback), Equal: map[string]string{"env": "prod"}},
+	} {
+		q := byMetric[metric]
+		if !q.From.Equal(want.From) || !q.To.Equal(evalTime) || !maps.Equal(q.Equal, want.Equal) {
+			t.Errorf("read of %s = %+v, want from %s to %s with %v", metric, q, want.From, evalTime, want.Equal)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	for _, input := range []string{
+		// Both prod series match the one on the right.
+		"cpu_usage / on(env) cpu_usage",
+		"cpu_usage{env=\"prod\"} - ignoring(server_id) cpu_usage{env=\"prod\"}",
+	} {
+		e, err := ParseExpr(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = EvalExpr(context.Background(), newSeriesStore(), e, evalTime, evalTime, 0)
+		if !errors.Is(err, ErrInvalidExpr) || !strings.Contains(err.Error(), "more than one series") {
+			t.Errorf("EvalExpr(%q) error = %v, want an ambiguous match", input, err)
+		}
+	}
+}
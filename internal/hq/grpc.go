@@ -1,21 +1,54 @@
 package hq
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"sentinel/internal/proto"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// DefaultConfigPollInterval is how often WatchConfig looks for a new config
+// version for its agent.
+const DefaultConfigPollInterval = 10 * time.Second
+
 type GRPCServer struct {
 	proto.UnimplementedSentinelServer
-	Store MetricStore
+	Store   MetricStore
+	Streams *StreamRegistry
+	Live    *LiveBroker
+	Latest  *LatestCache
+
+	ConfigPollInterval time.Duration
+	// ConflictPolicy decides what happens to a stream for a server_id that
+	// is already streaming from another host: ConflictAllow or ConflictReject.
+	ConflictPolicy string
+
+	// saves tracks batches being written, so shutdown can wait for them.
+	saves sync.WaitGroup
 }
 
-func NewGRPCServer(store MetricStore) *GRPCServer {
-	return &GRPCServer{Store: store}
+// saveBatchTimeout bounds a batch write that outlives its stream.
+const saveBatchTimeout = 30 * time.Second
+
+func NewGRPCServer(store MetricStore, streams *StreamRegistry, live *LiveBroker, latest *LatestCache) *GRPCServer {
+	return &GRPCServer{
+		Store:              store,
+		Streams:            streams,
+		Live:               live,
+		Latest:             latest,
+		ConfigPollInterval: DefaultConfigPollInterval,
+		ConflictPolicy:     ConflictAllow,
+	}
 }
 
 func (s *GRPCServer) Register(registrar grpc.ServiceRegistrar) {
@@ -29,6 +62,15 @@ func (s *GRPCServer) StreamMetrics(stream proto.Sentinel_StreamMetricsServer) er
 		ipAddress = p.Addr.String()
 	}
 
+	// The stream is registered under the server_id of its first batch, and
+	// again if a config change renames the agent.
+	var registered *StreamInfo
+	defer func() {
+		if registered != nil {
+			s.Streams.Unregister(*registered)
+		}
+	}()
+
 	for {
 		// Receive a batch
 		batch, err := stream.Recv()
@@ -44,11 +86,157 @@ func (s *GRPCServer) StreamMetrics(stream proto.Sentinel_StreamMetricsServer) er
 			return err
 		}
 
+		if registered == nil || registered.ServerID != batch.ServerId {
+			if registered != nil {
+				s.Streams.Unregister(*registered)
+				registered = nil
+			}
+			info, err := s.register(ctx, batch, ipAddress)
+			if err != nil {
+				return err
+			}
+			registered = &info
+		}
+
+		if h := batch.HostInfo; h != nil {
+			if err := ValidateLabels(h.Labels); err != nil {
+				log.Printf("Ignoring labels from %s: %v", batch.ServerId, err)
+				h.Labels = nil
+			}
+		}
+
 		// Save to DB
-		if err := s.Store.SaveBatch(ctx, batch, ipAddress); err != nil {
+		if err := s.saveBatch(ctx, batch, ipAddress); err != nil {
 			log.Printf("Error saving batch from %s: %v", batch.ServerId, err)
 		} else {
-			log.Printf("Received & saved %d metrics from %s", len(batch.Metrics), batch.ServerId)
+			log.Printf("Received & saved %d metrics and %d events from %s", len(batch.Metrics), len(batch.Events), batch.ServerId)
+			s.Latest.Update(batch)
+			s.Live.Publish(batch)
+		}
+	}
+}
+
+// saveBatch writes a batch even if the stream is cancelled halfway, as it is
+// on shutdown, so a transaction isn't rolled back after the agent sent it.
+func (s *GRPCServer) saveBatch(ctx context.Context, batch *proto.MetricBatch, ipAddress string) error {
+	s.saves.Add(1)
+	defer s.saves.Done()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveBatchTimeout)
+	defer cancel()
+	return s.Store.SaveBatch(ctx, batch, ipAddress)
+}
+
+// Wait blocks until the batches being saved are written.
+func (s *GRPCServer) Wait() {
+	s.saves.Wait()
+}
+
+// register records the stream of an agent and handles a server_id that is
+// already streaming from another host according to the conflict policy.
+func (s *GRPCServer) register(ctx context.Context, batch *proto.MetricBatch, peerAddr string) (StreamInfo, error) {
+	info := StreamInfo{
+		ServerID:    batch.ServerId,
+		Peer:        peerAddr,
+		MachineID:   batch.MachineId,
+		ConnectedAt: time.Now(),
+	}
+	info, rivals, ok := s.Streams.Register(info, s.ConflictPolicy != ConflictReject)
+	if len(rivals) == 0 {
+		return info, nil
+	}
+
+	other := rivals[0]
+	msg := fmt.Sprintf("server_id %s is streaming from two hosts: %s (machine %s) and %s (machine %s)",
+		info.ServerID, other.Peer, orUnknown(other.MachineID), info.Peer, orUnknown(info.MachineID))
+	if !ok {
+		msg += "; the newer stream was rejected"
+	}
+	log.Print(msg)
+	if s.Streams.shouldReport(info.ServerID) {
+		attrs, _ := json.Marshal(map[string]string{
+			"peer":             info.Peer,
+			"machine_id":       info.MachineID,
+			"other_peer":       other.Peer,
+			"other_machine_id": other.MachineID,
+		})
+		event := Event{
+			Time:       time.Now(),
+			ServerID:   info.ServerID,
+			Severity:   "warning",
+			Source:     "hq",
+			Message:    msg,
+			Attributes: attrs,
 		}
+		if err := s.Store.SaveEvent(ctx, event); err != nil {
+			log.Printf("Error saving conflict event for %s: %v", info.ServerID, err)
+		}
+	}
+	if !ok {
+		return info, status.Errorf(codes.AlreadyExists, "server_id %q is already connected from another host", info.ServerID)
+	}
+	return info, nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// WatchConfig sends the agent its effective config profile, then every new
+// version until the agent disconnects. An update without a profile tells the
+// agent to run on its local config alone.
+func (s *GRPCServer) WatchConfig(req *proto.ConfigRequest, stream proto.Sentinel_WatchConfigServer) error {
+	if req.ServerId == "" {
+		return status.Error(codes.InvalidArgument, "server_id is required")
+	}
+	ctx := stream.Context()
+	ticker := time.NewTicker(s.ConfigPollInterval)
+	defer ticker.Stop()
+
+	var sent *proto.ConfigUpdate
+	for {
+		update := &proto.ConfigUpdate{}
+		profile, err := s.Store.GetEffectiveConfig(ctx, req.ServerId)
+		switch {
+		case err == nil:
+			update.Profile = profile.Name
+			update.Version = profile.Version
+			update.ConfigJson = profile.Config
+		case !errors.Is(err, ErrNotFound):
+			log.Printf("Error loading config for %s: %v", req.ServerId, err)
+			update = nil
+		}
+
+		if update != nil && (sent == nil || update.Profile != sent.Profile || update.Version != sent.Version) {
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+			sent = update
+			if update.Profile != "" {
+				log.Printf("Sent config %s v%d to %s", update.Profile, update.Version, req.ServerId)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *GRPCServer) ReportConfigStatus(ctx context.Context, report *proto.ConfigStatus) (*proto.Ack, error) {
+	if report.ServerId == "" {
+		return nil, status.Error(codes.InvalidArgument, "server_id is required")
+	}
+	if err := s.Store.SaveConfigStatus(ctx, report); err != nil {
+		log.Printf("Error saving config status from %s: %v", report.ServerId, err)
+		return nil, status.Error(codes.Internal, "saving config status failed")
+	}
+	if !report.Applied {
+		log.Printf("%s rejected config %s v%d: %s", report.ServerId, report.Profile, report.Version, report.Error)
 	}
+	return &proto.Ack{Success: true}, nil
 }
@@ -0,0 +1,82 @@
+package hq
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sentinel/internal/proto"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// healthCheckTimeout bounds the database ping of a health check.
+	healthCheckTimeout = 2 * time.Second
+	// HealthCheckInterval is how often the gRPC health status is refreshed.
+	HealthCheckInterval = 10 * time.Second
+)
+
+// handleHealthz reports whether HQ is up and can reach its database.
+func (s *RESTServer) handleHealthz(c *gin.Context) {
+	if err := s.pingStore(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz is handleHealthz, except that it fails as soon as shutdown
+// starts so load balancers stop sending requests.
+func (s *RESTServer) handleReadyz(c *gin.Context) {
+	if s.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+	s.handleHealthz(c)
+}
+
+func (s *RESTServer) pingStore(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return s.Store.Ping(ctx)
+}
+
+// RunHealthChecks keeps the gRPC health status of hs in line with the
+// database until ctx is cancelled. The status is reported for the server as
+// a whole ("") and for the Sentinel service.
+func RunHealthChecks(ctx context.Context, store MetricStore, hs *health.Server) {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	serving := true
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := store.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			if serving {
+				log.Printf("Database unreachable, reporting NOT_SERVING: %v", err)
+			}
+		} else if !serving {
+			log.Println("Database reachable again, reporting SERVING")
+		}
+		serving = err == nil
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(proto.Sentinel_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
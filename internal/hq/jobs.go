@@ -0,0 +1,142 @@
+package hq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// finishedJobTTL is how long finished jobs stay visible.
+const finishedJobTTL = 24 * time.Hour
+
+// ErrJobRunning is returned when a server already has a job in progress.
+var ErrJobRunning = errors.New("a job is already running for this server")
+
+// Job is a long-running operation on a server, such as deleting its data.
+// Progress counts the rows processed per table.
+type Job struct {
+	ID         int64            `json:"id"`
+	Kind       string           `json:"kind"`
+	ServerID   string           `json:"server_id"`
+	State      string           `json:"state"`
+	Progress   map[string]int64 `json:"progress"`
+	Error      string           `json:"error,omitempty"`
+	StartedBy  string           `json:"started_by"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+// JobRegistry runs jobs in the background and keeps their state in memory.
+// Jobs don't survive a restart.
+type JobRegistry struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[int64]*Job
+	nextID int64
+}
+
+func NewJobRegistry() *JobRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobRegistry{ctx: ctx, cancel: cancel, jobs: map[int64]*Job{}}
+}
+
+// Start runs fn in the background as a job of the given kind and returns the
+// job as started. Only one job runs per server at a time.
+func (r *JobRegistry) Start(kind, serverID, startedBy string, fn func(ctx context.Context, progress ProgressFunc) error) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, j := range r.jobs {
+		if j.ServerID == serverID && j.State == JobRunning {
+			return Job{}, ErrJobRunning
+		}
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > finishedJobTTL {
+			delete(r.jobs, id)
+		}
+	}
+
+	r.nextID++
+	job := &Job{
+		ID:        r.nextID,
+		Kind:      kind,
+		ServerID:  serverID,
+		State:     JobRunning,
+		Progress:  map[string]int64{},
+		StartedBy: startedBy,
+		StartedAt: time.Now(),
+	}
+	r.jobs[job.ID] = job
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		err := fn(r.ctx, func(table string, rows int64) {
+			r.mu.Lock()
+			job.Progress[table] = rows
+			r.mu.Unlock()
+		})
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		now := time.Now()
+		job.FinishedAt = &now
+		job.State = JobDone
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+			log.Printf("Job %d (%s %s) failed: %v", job.ID, job.Kind, job.ServerID, err)
+		} else {
+			log.Printf("Job %d (%s %s) done in %s", job.ID, job.Kind, job.ServerID, now.Sub(job.StartedAt).Round(time.Millisecond))
+		}
+	}()
+	return job.copy(), nil
+}
+
+// Get returns a copy of a job.
+func (r *JobRegistry) Get(id int64) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.copy(), true
+}
+
+// List returns copies of all jobs, newest first.
+func (r *JobRegistry) List() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]Job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		jobs = append(jobs, j.copy())
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID > jobs[k].ID })
+	return jobs
+}
+
+// Shutdown cancels running jobs and waits for them to stop.
+func (r *JobRegistry) Shutdown() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// copy must be called with the registry's lock held.
+func (j *Job) copy() Job {
+	c := *j
+	c.Progress = maps.Clone(j.Progress)
+	return c
+}
@@ -0,0 +1,153 @@
+package hq
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxLabels is how many labels a server may have from each source.
+const maxLabels = 64
+
+// labelKeyPattern and labelValuePattern match the agent's rules, and keep
+// labels usable in selectors.
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.:/@-]{1,128}$`)
+)
+
+// ValidateLabels checks the keys and values of a server's labels.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	var errs []error
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		if !labelKeyPattern.MatchString(k) {
+			errs = append(errs, fmt.Errorf("label %q: keys start with a letter and have up to 63 letters, digits, '_', '.' or '-'", k))
+		} else if !labelValuePattern.MatchString(labels[k]) {
+			errs = append(errs, fmt.Errorf("label %s: values have 1 to 128 letters, digits, '_', '.', ':', '/', '@' or '-'", k))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Label selector operators.
+const (
+	selectEqual     = "="
+	selectNotEqual  = "!="
+	selectExists    = "exists"
+	selectNotExists = "!exists"
+)
+
+type labelRequirement struct {
+	Key, Op, Value string
+}
+
+// A Selector picks servers by their labels. All of its requirements must
+// hold; an empty selector matches every server.
+type Selector []labelRequirement
+
+// ParseSelector parses a comma-separated list of requirements:
+//
+//	key=value   the label is set to value (key==value works too)
+//	key!=value  the label is set to something else, or not set at all
+//	key         the label is set
+//	!key        the label is not set
+//
+// For example: env=prod,role=db,!maintenance.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var r labelRequirement
+		switch {
+		case strings.HasPrefix(term, "!") && !strings.Contains(term, "="):
+			r = labelRequirement{Key: term[1:], Op: selectNotExists}
+		case strings.Contains(term, "!="):
+			k, v, _ := strings.Cut(term, "!=")
+			r = labelRequirement{Key: k, Op: selectNotEqual, Value: v}
+		case strings.Contains(term, "="):
+			k, v, _ := strings.Cut(term, "=")
+			r = labelRequirement{Key: k, Op: selectEqual, Value: strings.TrimPrefix(v, "=")}
+		default:
+			r = labelRequirement{Key: term, Op: selectExists}
+		}
+		r.Key, r.Value = strings.TrimSpace(r.Key), strings.TrimSpace(r.Value)
+		if !labelKeyPattern.MatchString(r.Key) {
+			return nil, fmt.Errorf("invalid selector %q: bad label key %q", term, r.Key)
+		}
+		if (r.Op == selectEqual || r.Op == selectNotEqual) && !labelValuePattern.MatchString(r.Value) {
+			return nil, fmt.Errorf("invalid selector %q: bad label value %q", term, r.Value)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy every requirement of sel.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.Key]
+		switch r.Op {
+		case selectEqual:
+			if !ok || v != r.Value {
+				return false
+			}
+		case selectNotEqual:
+			if ok && v == r.Value {
+				return false
+			}
+		case selectExists:
+			if !ok {
+				return false
+			}
+		case selectNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	terms := make([]string, len(sel))
+	for i, r := range sel {
+		switch r.Op {
+		case selectExists:
+			terms[i] = r.Key
+		case selectNotExists:
+			terms[i] = "!" + r.Key
+		default:
+			terms[i] = r.Key + r.Op + r.Value
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// where returns the SQL conditions for sel on labels, a JSONB expression.
+// arg adds a query argument and returns its placeholder.
+func (sel Selector) where(labels string, arg func(any) string) []string {
+	var conds []string
+	for _, r := range sel {
+		label := "(" + labels + ")->>" + arg(r.Key)
+		switch r.Op {
+		case selectEqual:
+			conds = append(conds, label+" = "+arg(r.Value))
+		case selectNotEqual:
+			conds = append(conds, label+" IS DISTINCT FROM "+arg(r.Value))
+		case selectExists:
+			conds = append(conds, label+" IS NOT NULL")
+		case selectNotExists:
+			conds = append(conds, label+" IS NULL")
+		}
+	}
+	return conds
+}
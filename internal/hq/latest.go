@@ -0,0 +1,151 @@
+package hq
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"sentinel/internal/proto"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LatestMaxAge is how long a series stays in LatestCache without being
+// reported, so a disk that was unmounted or a service that was removed
+// drops out. Warm loads the same window, so what is served doesn't depend
+// on when HQ last restarted.
+const LatestMaxAge = time.Hour
+
+type seriesKey struct {
+	MetricType, Resource string
+}
+
+// LatestCache keeps the latest value of every series of every server in
+// memory, so dashboards can read the current state without scanning the
+// metrics table. It is shared by the gRPC server, which updates it as
+// batches are saved, and the REST server, which serves it.
+type LatestCache struct {
+	mu      sync.RWMutex
+	servers map[string]map[seriesKey]Metric
+}
+
+func NewLatestCache() *LatestCache {
+	return &LatestCache{servers: map[string]map[seriesKey]Metric{}}
+}
+
+// Warm loads the latest values reported within LatestMaxAge.
+func (l *LatestCache) Warm(ctx context.Context, store MetricStore) error {
+	metrics, err := store.LatestMetrics(ctx, time.Now().Add(-LatestMaxAge))
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range metrics {
+		l.set(m)
+	}
+	return nil
+}
+
+// Update records the metrics of a saved batch. Agents resend buffered
+// batches after a reconnect, so older values don't replace newer ones.
+// Series of the server that haven't been reported for LatestMaxAge are
+// evicted.
+func (l *LatestCache) Update(batch *proto.MetricBatch) {
+	metrics := batchMetrics(batch)
+	since := time.Now().Add(-LatestMaxAge)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range metrics {
+		l.set(m)
+	}
+	for key, m := range l.servers[batch.ServerId] {
+		if m.Time.Before(since) {
+			delete(l.servers[batch.ServerId], key)
+		}
+	}
+}
+
+func (l *LatestCache) set(m Metric) {
+	series := l.servers[m.ServerID]
+	if series == nil {
+		series = map[seriesKey]Metric{}
+		l.servers[m.ServerID] = series
+	}
+	key := seriesKey{m.MetricType, m.Resource}
+	if old, ok := series[key]; ok && !m.Time.After(old.Time) {
+		return
+	}
+	series[key] = m
+}
+
+// Server returns the latest values of a server reported after since, sorted
+// by metric type and resource. With metricTypes, only those metric types are
+// returned.
+func (l *LatestCache) Server(serverID string, metricTypes map[string]bool, since time.Time) []Metric {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	metrics := []Metric{}
+	for key, m := range l.servers[serverID] {
+		if m.Time.Before(since) {
+			continue
+		}
+		if len(metricTypes) == 0 || metricTypes[key.MetricType] {
+			metrics = append(metrics, m)
+		}
+	}
+	slices.SortFunc(metrics, func(a, b Metric) int {
+		return cmp.Or(cmp.Compare(a.MetricType, b.MetricType), cmp.Compare(a.Resource, b.Resource))
+	})
+	return metrics
+}
+
+// Remove forgets a deleted server.
+func (l *LatestCache) Remove(serverID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.servers, serverID)
+}
+
+// Rename moves the values of a server to a new server_id, merging them with
+// any it has the way RenameServer merges histories.
+func (l *LatestCache) Rename(oldID, newID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.servers[oldID] {
+		m.ServerID = newID
+		l.set(m)
+	}
+	delete(l.servers, oldID)
+}
+
+// handleGetLatest serves the latest value of every series of a server from
+// the cache. metric narrows it to some metric types, e.g. cpu_usage,memory_used.
+func (s *RESTServer) handleGetLatest(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Latest.Server(c.Param("server_id"), splitSet(c.Query("metric")), time.Now().Add(-LatestMaxAge)))
+}
+
+// handleListLatest serves the latest value of every series across servers,
+// in the order of /servers. Query parameters: selector (e.g.
+// env=prod,role=db) and metric. Decommissioned servers are left out.
+func (s *RESTServer) handleListLatest(c *gin.Context) {
+	selector, err := ParseSelector(c.Query("selector"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	servers, err := s.Store.ListServers(c.Request.Context(), ServerQuery{Selector: selector})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	metricTypes := splitSet(c.Query("metric"))
+	since := time.Now().Add(-LatestMaxAge)
+	metrics := []Metric{}
+	for _, server := range servers {
+		metrics = append(metrics, s.Latest.Server(server.ServerID, metricTypes, since)...)
+	}
+	c.JSON(http.StatusOK, metrics)
+}
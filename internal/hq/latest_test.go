@@ -0,0 +1,133 @@
+package hq
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"sentinel/internal/proto"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// latestBatch is a batch of one value per disk path, reported at t.
+func latestBatch(serverID string, t time.Time, values map[string]float64) *proto.MetricBatch {
+	batch := &proto.MetricBatch{ServerId: serverID, Timestamp: timestamppb.New(t)}
+	for path, v := range values {
+		batch.Metrics = append(batch.Metrics, &proto.Metric{Type: "disk_used_percent", Value: v, Tags: map[string]string{"path": path}})
+	}
+	return batch
+}
+
+// latestValues lists what the cache serves for a server as resource=value.
+func latestValues(l *LatestCache, serverID string) []string {
+	var out []string
+	for _, m := range l.Server(serverID, nil, time.Now().Add(-LatestMaxAge)) {
+		out = append(out, fmt.Sprintf("%s=%g", m.Resource, m.Value))
+	}
+	return out
+}
+
+func TestLatestCacheUpdate(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name    string
+		batches []*proto.MetricBatch
+		want    []string
+	}{
+		{"newer replaces", []*proto.MetricBatch{
+			latestBatch("web-1", now.Add(-time.Minute), map[string]float64{"/": 10, "/var": 20}),
+			latestBatch("web-1", now, map[string]float64{"/": 11}),
+		}, []string{"/=11", "/var=20"}},
+		// Agents resend buffered batches after a reconnect.
+		{"older resent batch ignored", []*proto.MetricBatch{
+			latestBatch("web-1", now, map[string]float64{"/": 11}),
+			latestBatch("web-1", now.Add(-time.Minute), map[string]float64{"/": 10}),
+		}, []string{"/=11"}},
+		{"same time keeps the first", []*proto.MetricBatch{
+			latestBatch("web-1", now, map[string]float64{"/": 11}),
+			latestBatch("web-1", now, map[string]float64{"/": 12}),
+		}, []string{"/=11"}},
+		{"stale series evicted", []*proto.MetricBatch{
+			latestBatch("web-1", now.Add(-2*LatestMaxAge), map[string]float64{"/mnt/usb": 50}),
+			latestBatch("web-1", now, map[string]float64{"/": 11}),
+		}, []string{"/=11"}},
+		{"other servers kept apart", []*proto.MetricBatch{
+			latestBatch("web-2", now, map[string]float64{"/": 99}),
+			latestBatch("web-1", now, map[string]float64{"/": 11}),
+		}, []string{"/=11"}},
+	} {
+		l := NewLatestCache()
+		for _, b := range tc.batches {
+			l.Update(b)
+		}
+		if got := latestValues(l, "web-1"); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLatestCacheServerSkipsStale(t *testing.T) {
+	now := time.Now()
+	l := NewLatestCache()
+	l.Update(latestBatch("web-1", now.Add(-30*time.Minute), map[string]float64{"/": 10}))
+	l.Update(latestBatch("web-1", now, map[string]float64{"/var": 20}))
+
+	// A series that stopped reporting is left out even before it is evicted.
+	if got := l.Server("web-1", nil, now.Add(-10*time.Minute)); len(got) != 1 || got[0].Resource != "/var" {
+		t.Errorf("got %+v, want only /var", got)
+	}
+	if got := l.Server("web-1", map[string]bool{"cpu_usage": true}, time.Time{}); len(got) != 0 {
+		t.Errorf("got %+v for another metric type", got)
+	}
+
+	// Once past LatestMaxAge it is dropped from memory on the next batch.
+	l.Update(latestBatch("web-1", now.Add(-2*LatestMaxAge), map[string]float64{"/mnt/usb": 50}))
+	l.Update(latestBatch("web-1", now, map[string]float64{"/var": 21}))
+	if got := len(l.servers["web-1"]); got != 2 {
+		t.Errorf("%d series cached, want / and /var", got)
+	}
+}
+
+func TestLatestCacheRenameAndRemove(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name     string
+		old, new *proto.MetricBatch
+		want     []string
+	}{
+		{"to a new server", latestBatch("old", now, map[string]float64{"/": 1}), nil, []string{"/=1"}},
+		// Like RenameServer merging histories, the newest value of each
+		// series wins whichever server it came from.
+		{"merged", latestBatch("old", now, map[string]float64{"/": 1, "/var": 2}),
+			latestBatch("new", now.Add(-time.Minute), map[string]float64{"/": 3, "/home": 4}),
+			[]string{"/=1", "/home=4", "/var=2"}},
+		{"older values lose", latestBatch("old", now.Add(-time.Minute), map[string]float64{"/": 1}),
+			latestBatch("new", now, map[string]float64{"/": 3}),
+			[]string{"/=3"}},
+	} {
+		l := NewLatestCache()
+		l.Update(tc.old)
+		if tc.new != nil {
+			l.Update(tc.new)
+		}
+		l.Rename("old", "new")
+		if got := latestValues(l, "new"); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if got := latestValues(l, "old"); len(got) != 0 {
+			t.Errorf("%s: old server still has %q", tc.name, got)
+		}
+		for _, m := range l.Server("new", nil, time.Time{}) {
+			if m.ServerID != "new" {
+				t.Errorf("%s: %s has server_id %q", tc.name, m.Resource, m.ServerID)
+			}
+		}
+
+		l.Remove("new")
+		if got := latestValues(l, "new"); len(got) != 0 {
+			t.Errorf("%s: removed server still has %q", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package hq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sentinel/internal/proto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// liveBuffer is how many batches a live subscriber may fall behind
+	// before further batches are dropped for it.
+	liveBuffer = 64
+	// maxLiveSubscribers bounds the open live streams.
+	maxLiveSubscribers = 1000
+	// liveKeepalive is how often an idle live stream gets a comment, so
+	// proxies don't close it.
+	liveKeepalive = 15 * time.Second
+	// liveWriteTimeout disconnects a client that stops reading.
+	liveWriteTimeout = 10 * time.Second
+)
+
+// ErrLiveUnavailable is returned by Subscribe when the broker is closed or
+// has too many subscribers.
+var ErrLiveUnavailable = errors.New("live updates are unavailable")
+
+// LiveUpdate is what a subscriber gets from one batch: the metrics it asked for.
+type LiveUpdate struct {
+	ServerID string    `json:"server_id"`
+	Time     time.Time `json:"time"`
+	Metrics  []Metric  `json:"metrics"`
+}
+
+// LiveFilter picks the updates of a subscriber. Empty sets match everything.
+type LiveFilter struct {
+	ServerIDs map[string]bool
+	Metrics   map[string]bool
+}
+
+// LiveSubscription receives the updates matching its filter.
+type LiveSubscription struct {
+	filter  LiveFilter
+	updates chan LiveUpdate
+	dropped atomic.Int64
+}
+
+// Updates is closed when the subscription ends.
+func (sub *LiveSubscription) Updates() <-chan LiveUpdate {
+	return sub.updates
+}
+
+// TakeDropped returns how many updates were dropped since the last call
+// because the subscriber fell behind.
+func (sub *LiveSubscription) TakeDropped() int64 {
+	return sub.dropped.Swap(0)
+}
+
+// LiveBroker fans out freshly ingested batches to live subscribers. It is
+// shared by the gRPC server, which publishes batches, and the REST server,
+// which streams them to clients. Publishing never blocks: a subscriber that
+// falls behind loses updates rather than slowing down ingestion.
+type LiveBroker struct {
+	mu     sync.Mutex
+	subs   map[*LiveSubscription]struct{}
+	closed bool
+}
+
+func NewLiveBroker() *LiveBroker {
+	return &LiveBroker{subs: map[*LiveSubscription]struct{}{}}
+}
+
+// Subscribe starts a subscription. Call Unsubscribe when done with it.
+func (b *LiveBroker) Subscribe(filter LiveFilter) (*LiveSubscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.subs) >= maxLiveSubscribers {
+		return nil, ErrLiveUnavailable
+	}
+	sub := &LiveSubscription{filter: filter, updates: make(chan LiveUpdate, liveBuffer)}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription and closes its channel.
+func (b *LiveBroker) Unsubscribe(sub *LiveSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.updates)
+	}
+}
+
+// Publish hands the metrics of a saved batch to the subscribers that want them.
+func (b *LiveBroker) Publish(batch *proto.MetricBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 || len(batch.Metrics) == 0 {
+		return
+	}
+
+	t := batch.Timestamp.AsTime()
+	metrics := batchMetrics(batch)
+	for sub := range b.subs {
+		if len(sub.filter.ServerIDs) > 0 && !sub.filter.ServerIDs[batch.ServerId] {
+			continue
+		}
+		update := LiveUpdate{ServerID: batch.ServerId, Time: t, Metrics: metrics}
+		if len(sub.filter.Metrics) > 0 {
+			update.Metrics = nil
+			for _, m := range metrics {
+				if sub.filter.Metrics[m.MetricType] {
+					update.Metrics = append(update.Metrics, m)
+				}
+			}
+			if len(update.Metrics) == 0 {
+				continue
+			}
+		}
+		select {
+		case sub.updates <- update:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// batchMetrics returns the metrics of a batch as they are stored.
+func batchMetrics(batch *proto.MetricBatch) []Metric {
+	t := batch.Timestamp.AsTime()
+	metrics := make([]Metric, len(batch.Metrics))
+	for i, m := range batch.Metrics {
+		tags, _ := json.Marshal(m.Tags)
+		metrics[i] = Metric{
+			Time:       t,
+			ServerID:   batch.ServerId,
+			MetricType: m.Type,
+			Resource:   metricResource(m.Tags),
+			Value:      m.Value,
+			Tags:       tags,
+		}
+	}
+	return metrics
+}
+
+// Close ends all subscriptions and refuses new ones, so live streams don't
+// hold up shutdown.
+func (b *LiveBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.updates)
+	}
+}
+
+// handleLive streams the metrics of incoming batches as Server-Sent Events,
+// each a "metrics" event with a LiveUpdate. Query parameters:
+//
+//	server_id  the servers, e.g. web-1,web-2 (default all; /live/:server_id
+//	           for one)
+//	metric     the metric types, e.g. cpu_usage,memory_used (default all)
+//
+// A client that falls behind gets a "dropped" event with the number of
+// batches it missed before the next update, and should refetch what it shows.
+func (s *RESTServer) handleLive(c *gin.Context) {
+	filter := LiveFilter{
+		ServerIDs: splitSet(c.Query("server_id")),
+		Metrics:   splitSet(c.Query("metric")),
+	}
+	if id := c.Param("server_id"); id != "" {
+		filter.ServerIDs = map[string]bool{id: true}
+	}
+	sub, err := s.Live.Subscribe(filter)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.Live.Unsubscribe(sub)
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	// send writes a message and flushes it; an error means the client is gone
+	// or too slow.
+	send := func(msg string) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := w.WriteString(msg); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(": connected\n\n"); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(liveKeepalive)
+	defer keepalive.Stop()
+	for {
+		var msg string
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepalive.C:
+			msg = ": keepalive\n\n"
+		case update, ok := <-sub.Updates():
+			if !ok {
+				return
+			}
+			if n := sub.TakeDropped(); n > 0 {
+				msg = fmt.Sprintf("event: dropped\ndata: {\"dropped\":%d}\n\n", n)
+			}
+			data, err := json.Marshal(update)
+			if err != nil {
+				return
+			}
+			msg += "event: metrics\ndata: " + string(data) + "\n\n"
+		}
+		if err := send(msg); err != nil {
+			return
+		}
+	}
+}
+
+// splitSet turns a comma-separated list into a set; nil when it is empty.
+func splitSet(list string) map[string]bool {
+	var set map[string]bool
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			if set == nil {
+				set = map[string]bool{}
+			}
+			set[v] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,154 @@
+package hq
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sentinel/internal/proto"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func liveBatch(serverID string, metricTypes ...string) *proto.MetricBatch {
+	batch := &proto.MetricBatch{ServerId: serverID, Timestamp: timestamppb.New(time.Unix(1700000000, 0))}
+	for _, t := range metricTypes {
+		batch.Metrics = append(batch.Metrics, &proto.Metric{Type: t, Value: 1})
+	}
+	return batch
+}
+
+// received drains what is buffered for sub, as server_id:metric_type,...
+func received(sub *LiveSubscription) []string {
+	var out []string
+	for {
+		select {
+		case u, ok := <-sub.Updates():
+			if !ok {
+				return out
+			}
+			var types []string
+			for _, m := range u.Metrics {
+				types = append(types, m.MetricType)
+			}
+			out = append(out, u.ServerID+":"+strings.Join(types, ","))
+		default:
+			return out
+		}
+	}
+}
+
+func TestLiveBrokerFilters(t *testing.T) {
+	b := NewLiveBroker()
+	defer b.Close()
+
+	subscribe := func(filter LiveFilter) *LiveSubscription {
+		sub, err := b.Subscribe(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	all := subscribe(LiveFilter{})
+	web1 := subscribe(LiveFilter{ServerIDs: splitSet("web-1")})
+	cpu := subscribe(LiveFilter{Metrics: splitSet("cpu_usage,memory_used")})
+	web2CPU := subscribe(LiveFilter{ServerIDs: splitSet("web-2"), Metrics: splitSet("cpu_usage")})
+
+	b.Publish(liveBatch("web-1", "cpu_usage", "disk_used_percent"))
+	b.Publish(liveBatch("web-2", "disk_used_percent"))
+	b.Publish(liveBatch("web-2", "memory_used", "cpu_usage"))
+	// Batches without metrics reach nobody.
+	b.Publish(liveBatch("web-1"))
+
+	for name, tc := range map[string]struct {
+		sub  *LiveSubscription
+		want []string
+	}{
+		"all":     {all, []string{"web-1:cpu_usage,disk_used_percent", "web-2:disk_used_percent", "web-2:memory_used,cpu_usage"}},
+		"server":  {web1, []string{"web-1:cpu_usage,disk_used_percent"}},
+		"metrics": {cpu, []string{"web-1:cpu_usage", "web-2:memory_used,cpu_usage"}},
+		"both":    {web2CPU, []string{"web-2:cpu_usage"}},
+	} {
+		if got := received(tc.sub); !slices.Equal(got, tc.want) {
+			t.Errorf("%s got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestLiveBrokerDropsForSlowSubscribers(t *testing.T) {
+	b := NewLiveBroker()
+	defer b.Close()
+	slow, err := b.Subscribe(LiveFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := b.Subscribe(LiveFilter{ServerIDs: splitSet("db-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range liveBuffer + 5 {
+		b.Publish(liveBatch("web-1", "cpu_usage"))
+	}
+	if got := slow.TakeDropped(); got != 5 {
+		t.Errorf("TakeDropped = %d, want the 5 past the buffer", got)
+	}
+	if got := slow.TakeDropped(); got != 0 {
+		t.Errorf("second TakeDropped = %d, want 0", got)
+	}
+	// Batches a subscriber doesn't want don't count as dropped.
+	if got := filtered.TakeDropped(); got != 0 {
+		t.Errorf("filtered TakeDropped = %d, want 0", got)
+	}
+
+	// Once it catches up, it gets batches again.
+	if got := len(received(slow)); got != liveBuffer {
+		t.Errorf("buffered %d updates, want %d", got, liveBuffer)
+	}
+	b.Publish(liveBatch("web-1", "cpu_usage"))
+	if got := received(slow); len(got) != 1 || slow.TakeDropped() != 0 {
+		t.Errorf("after catching up got %q", got)
+	}
+}
+
+func TestLiveBrokerUnsubscribeAndClose(t *testing.T) {
+	b := NewLiveBroker()
+	sub, err := b.Subscribe(LiveFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Unsubscribe(sub)
+	if _, ok := <-sub.Updates(); ok {
+		t.Error("Updates is still open after Unsubscribe")
+	}
+	b.Unsubscribe(sub) // a second call is harmless
+	b.Publish(liveBatch("web-1", "cpu_usage"))
+
+	open, err := b.Subscribe(LiveFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if _, ok := <-open.Updates(); ok {
+		t.Error("Updates is still open after Close")
+	}
+	b.Unsubscribe(open)
+	if _, err := b.Subscribe(LiveFilter{}); !errors.Is(err, ErrLiveUnavailable) {
+		t.Errorf("Subscribe after Close = %v, want ErrLiveUnavailable", err)
+	}
+}
+
+func TestLiveBrokerSubscriberLimit(t *testing.T) {
+	b := NewLiveBroker()
+	defer b.Close()
+	for range maxLiveSubscribers {
+		if _, err := b.Subscribe(LiveFilter{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Subscribe(LiveFilter{}); !errors.Is(err, ErrLiveUnavailable) {
+		t.Errorf("Subscribe past the limit = %v, want ErrLiveUnavailable", err)
+	}
+}
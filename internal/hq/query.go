@@ -0,0 +1,272 @@
+package hq
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultQueryRange is how far back a query with a step looks by default.
+	DefaultQueryRange = time.Hour
+	// DefaultInstantRange is how far back a query without a step looks by
+	// default, short enough to stand for the current state.
+	DefaultInstantRange = 5 * time.Minute
+	// MinQueryStep and maxQueryBuckets keep a series to a sensible number
+	// of points.
+	MinQueryStep    = time.Second
+	maxQueryBuckets = 11000
+)
+
+// defaultGroupBy keeps every series of the metric apart.
+var defaultGroupBy = []string{"server_id", "resource"}
+
+// QueryResult is the response of /query.
+type QueryResult struct {
+	Metric   string    `json:"metric"`
+	Selector string    `json:"selector"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Step     string    `json:"step,omitempty"`
+	Agg      string    `json:"agg"`
+	GroupBy  []string  `json:"group_by"`
+	Series   []Series  `json:"series"`
+}
+
+// handleQuery aggregates a metric across servers. Query parameters:
+//
+//	metric     the metric type, e.g. cpu_usage (required)
+//	selector   the servers, e.g. role=web,env=prod (default all)
+//	from, to   RFC 3339 times (default: to now, from range before it)
+//	range      instead of from, e.g. 1h
+//	step       bucket size, e.g. 1m; without it each group has one point
+//	agg        avg (default), min, max, sum, count or last
+//	group_by   server_id, resource and label keys (default server_id,resource;
+//	           empty for one series over all servers)
+//	topk       only the k series with the highest value
+//	bottomk    only the k series with the lowest value
+//
+// With a step, series are ranked by agg over their points. Instead of these,
+// expr can hold a query expression; see handleExprQuery.
+func (s *RESTServer) handleQuery(c *gin.Context) {
+	if expr := c.Query("expr"); expr != "" {
+		s.handleExprQuery(c, expr)
+		return
+	}
+	q, topk, bottomk, err := parseFleetQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	series, err := s.Store.QueryMetrics(c.Request.Context(), q)
+	if errors.Is(err, ErrTooManyPoints) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case topk > 0:
+		series = rankSeries(series, q.Agg, topk, true)
+	case bottomk > 0:
+		series = rankSeries(series, q.Agg, bottomk, false)
+	}
+
+	res := QueryResult{
+		Metric:   q.Metric,
+		Selector: q.Selector.String(),
+		From:     q.From,
+		To:       q.To,
+		Agg:      q.Agg,
+		GroupBy:  q.GroupBy,
+		Series:   series,
+	}
+	if q.Step > 0 {
+		res.Step = q.Step.String()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func parseFleetQuery(c *gin.Context) (q FleetQuery, topk, bottomk int, err error) {
+	q = FleetQuery{Metric: c.Query("metric"), Agg: c.DefaultQuery("agg", "avg"), GroupBy: defaultGroupBy}
+	if q.Metric == "" {
+		return q, 0, 0, errors.New("metric is required")
+	}
+	if _, ok := aggregations[q.Agg]; !ok {
+		return q, 0, 0, fmt.Errorf("unknown agg %q: use avg, min, max, sum, count or last", q.Agg)
+	}
+	if q.Selector, err = ParseSelector(c.Query("selector")); err != nil {
+		return q, 0, 0, err
+	}
+	if v, ok := c.GetQuery("group_by"); ok {
+		q.GroupBy = []string{}
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" || slices.Contains(q.GroupBy, field) {
+				continue
+			}
+			if !labelKeyPattern.MatchString(field) {
+				return q, 0, 0, fmt.Errorf("invalid group_by field %q", field)
+			}
+			q.GroupBy = append(q.GroupBy, field)
+		}
+	}
+
+	if q.From, q.To, q.Step, err = parseQueryRange(c); err != nil {
+		return q, 0, 0, err
+	}
+
+	for name, k := range map[string]*int{"topk": &topk, "bottomk": &bottomk} {
+		if v := c.Query(name); v != "" {
+			if *k, err = strconv.Atoi(v); err != nil || *k <= 0 {
+				return q, 0, 0, fmt.Errorf("invalid %s", name)
+			}
+		}
+	}
+	if topk > 0 && bottomk > 0 {
+		return q, 0, 0, errors.New("use either topk or bottomk")
+	}
+	return q, topk, bottomk, nil
+}
+
+// parseQueryRange reads the from, to, range and step parameters of /query.
+func parseQueryRange(c *gin.Context) (from, to time.Time, step time.Duration, err error) {
+	if v := c.Query("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < MinQueryStep {
+			return from, to, 0, fmt.Errorf("invalid step %q: it must be a duration of at least %s", v, MinQueryStep)
+		}
+	}
+	to = time.Now()
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	lookback := DefaultInstantRange
+	if step > 0 {
+		lookback = DefaultQueryRange
+	}
+	if v := c.Query("range"); v != "" {
+		if lookback, err = time.ParseDuration(v); err != nil || lookback <= 0 {
+			return from, to, 0, fmt.Errorf("invalid range %q", v)
+		}
+	}
+	from = to.Add(-lookback)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, 0, errors.New("from must be before to")
+	}
+	if step > 0 && to.Sub(from)/step > maxQueryBuckets {
+		return from, to, 0, fmt.Errorf("step %s is too small for the range: at most %d points per series", step, maxQueryBuckets)
+	}
+	return from, to, step, nil
+}
+
+// ExprResult is the response of /query with an expression.
+type ExprResult struct {
+	Expr   string    `json:"expr"`
+	Type   valueType `json:"type"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Step   string    `json:"step,omitempty"`
+	Series []Series  `json:"series"`
+}
+
+// handleExprQuery evaluates a query expression (see ParseExpr). With a step
+// it is evaluated at every step from from to to, otherwise only at to.
+func (s *RESTServer) handleExprQuery(c *gin.Context, input string) {
+	for _, param := range []string{"metric", "selector", "agg", "group_by", "topk", "bottomk"} {
+		if _, ok := c.GetQuery(param); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " can't be combined with expr"})
+			return
+		}
+	}
+	e, err := ParseExpr(input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expr: " + err.Error()})
+		return
+	}
+	from, to, step, err := parseQueryRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	series, err := EvalExpr(c.Request.Context(), s.Store, e, from, to, step)
+	if errors.Is(err, ErrTooManyPoints) || errors.Is(err, ErrInvalidExpr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := ExprResult{Expr: input, Type: e.Type(), From: from, To: to, Series: series}
+	if step > 0 {
+		res.Step = step.String()
+	} else {
+		res.From = to
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// rankSeries returns the k series with the highest (or, unless highest, the
+// lowest) score, best first.
+func rankSeries(series []Series, agg string, k int, highest bool) []Series {
+	scores := make([]float64, len(series))
+	order := make([]int, len(series))
+	for i := range series {
+		scores[i] = seriesScore(series[i].Points, agg)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if highest {
+			return scores[order[a]] > scores[order[b]]
+		}
+		return scores[order[a]] < scores[order[b]]
+	})
+
+	out := make([]Series, 0, min(k, len(order)))
+	for _, i := range order[:min(k, len(order))] {
+		out = append(out, series[i])
+	}
+	return out
+}
+
+// seriesScore applies agg to the values of points, counts adding up.
+func seriesScore(points []Point, agg string) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+	score := points[0].Value
+	for _, p := range points[1:] {
+		switch agg {
+		case "min":
+			score = min(score, p.Value)
+		case "max":
+			score = max(score, p.Value)
+		case "sum", "count", "avg":
+			score += p.Value
+		case "last":
+			score = p.Value
+		}
+	}
+	if agg == "avg" {
+		score /= float64(len(points))
+	}
+	return score
+}
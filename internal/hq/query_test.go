@@ -0,0 +1,130 @@
+package hq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func queryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/query?"+query, nil)
+	return c
+}
+
+func TestParseQueryRange(t *testing.T) {
+	to := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	toParam := "to=" + to.Format(time.RFC3339)
+	for _, tc := range []struct {
+		query    string
+		from     time.Time
+		step     time.Duration
+		hasError bool
+	}{
+		// Without a step a query stands for the current state.
+		{query: toParam, from: to.Add(-DefaultInstantRange)},
+		{query: toParam + "&step=1m", from: to.Add(-DefaultQueryRange), step: time.Minute},
+		{query: toParam + "&range=2h", from: to.Add(-2 * time.Hour)},
+		{query: toParam + "&range=2h&step=5m", from: to.Add(-2 * time.Hour), step: 5 * time.Minute},
+		// from wins over range.
+		{query: toParam + "&range=2h&from=2026-01-02T11:30:00Z", from: to.Add(-30 * time.Minute)},
+		{query: toParam + "&step=1s&range=11000s", from: to.Add(-11000 * time.Second), step: time.Second},
+
+		{query: toParam + "&step=1s&range=11001s", hasError: true}, // too many buckets
+		{query: toParam + "&step=500ms", hasError: true},
+		{query: toParam + "&step=often", hasError: true},
+		{query: toParam + "&range=-1h", hasError: true},
+		{query: toParam + "&range=0s", hasError: true},
+		{query: toParam + "&from=2026-01-02T12:00:00Z", hasError: true},
+		{query: toParam + "&from=yesterday", hasError: true},
+		{query: "to=now", hasError: true},
+	} {
+		from, gotTo, step, err := parseQueryRange(queryContext(tc.query))
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("%s: got from %s, want an error", tc.query, from)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if !from.Equal(tc.from) || !gotTo.Equal(to) || step != tc.step {
+			t.Errorf("%s: got %s to %s step %s, want %s to %s step %s", tc.query, from, gotTo, step, tc.from, to, tc.step)
+		}
+	}
+}
+
+func TestParseQueryRangeDefaultsToNow(t *testing.T) {
+	before := time.Now()
+	from, to, _, err := parseQueryRange(queryContext(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to.Before(before) || time.Since(to) > time.Minute || to.Sub(from) != DefaultInstantRange {
+		t.Errorf("got %s to %s, want the last %s", from, to, DefaultInstantRange)
+	}
+}
+
+func TestSeriesScore(t *testing.T) {
+	points := []Point{{Value: 3}, {Value: 1}, {Value: 8}, {Value: 4}}
+	for agg, want := range map[string]float64{
+		"avg":   4,
+		"min":   1,
+		"max":   8,
+		"sum":   16,
+		"count": 16, // the points of a count are counts already
+		"last":  4,
+	} {
+		if got := seriesScore(points, agg); got != want {
+			t.Errorf("seriesScore(%s) = %g, want %g", agg, got, want)
+		}
+	}
+	if got := seriesScore(nil, "max"); got != 0 {
+		t.Errorf("seriesScore of no points = %g, want 0", got)
+	}
+}
+
+func TestRankSeries(t *testing.T) {
+	series := func(name string, values ...float64) Series {
+		s := Series{Labels: map[string]string{"server_id": name}}
+		for _, v := range values {
+			s.Points = append(s.Points, Point{Value: v})
+		}
+		return s
+	}
+	all := []Series{
+		series("a", 10, 20), // avg 15, last 20
+		series("b", 50, 1),  // avg 25.5, last 1
+		series("c", 15, 15), // avg 15, last 15
+		series("d", 5),      // avg 5, last 5
+	}
+
+	for _, tc := range []struct {
+		agg     string
+		k       int
+		highest bool
+		want    []string
+	}{
+		{"avg", 2, true, []string{"b", "a"}},
+		{"avg", 2, false, []string{"d", "a"}},
+		// Ties keep the order the series came in.
+		{"avg", 3, true, []string{"b", "a", "c"}},
+		{"last", 2, true, []string{"a", "c"}},
+		{"last", 1, false, []string{"b"}},
+		{"max", 10, true, []string{"b", "a", "c", "d"}},
+	} {
+		var got []string
+		for _, s := range rankSeries(all, tc.agg, tc.k, tc.highest) {
+			got = append(got, s.Labels["server_id"])
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("rankSeries(%s, %d, highest=%t) = %v, want %v", tc.agg, tc.k, tc.highest, got, tc.want)
+		}
+	}
+}
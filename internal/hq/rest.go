@@ -1,57 +1,327 @@
 package hq
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"sentinel/internal/proto"
 
 	"github.com/gin-gonic/gin"
 )
 
 type RESTServer struct {
-	Store  MetricStore
-	Router *gin.Engine
-}
+	Store   MetricStore
+	Streams *StreamRegistry
+	Live    *LiveBroker
+	Latest  *LatestCache
+	Jobs    *JobRegistry
+	Config  *Config
+	Router  *gin.Engine
 
-func NewRESTServer(store MetricStore) *RESTServer {
-	r := gin.Default()
-
-	// Enable CORS
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+	server   *http.Server
+	draining atomic.Bool
+	// liveKey signs live tokens. It is made at startup, so a restart
+	// invalidates the tokens handed out before.
+	liveKey []byte
+}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	})
+func NewRESTServer(store MetricStore, streams *StreamRegistry, live *LiveBroker, latest *LatestCache, cfg *Config) *RESTServer {
+	r := gin.New()
+	// takeLiveToken runs first, so the logger never sees a live token.
+	r.Use(takeLiveToken, gin.Logger(), gin.Recovery())
 
 	s := &RESTServer{
-		Store:  store,
-		Router: r,
+		Store:   store,
+		Streams: streams,
+		Live:    live,
+		Latest:  latest,
+		Jobs:    NewJobRegistry(),
+		Config:  cfg,
+		Router:  r,
+		server:  &http.Server{Addr: cfg.HTTP.Listen, Handler: r},
+		liveKey: make([]byte, 32),
 	}
+	rand.Read(s.liveKey)
+	r.Use(s.cors, s.authenticate, s.audit)
 	s.registerRoutes()
 	return s
 }
 
+// cors answers preflight requests and allows the configured origins. Other
+// origins get no CORS headers, so browsers refuse to hand them the response.
+func (s *RESTServer) cors(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	allowed := ""
+	for _, o := range s.Config.CORS.AllowedOrigins {
+		if o == "*" {
+			allowed = "*"
+			break
+		}
+		if strings.EqualFold(o, origin) {
+			allowed = origin
+			break
+		}
+	}
+	if allowed != "*" {
+		c.Writer.Header().Add("Vary", "Origin")
+	}
+	if allowed != "" {
+		c.Writer.Header().Set("Access-Control-Allow-Origin", allowed)
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+	}
+
+	if c.Request.Method == "OPTIONS" {
+		c.AbortWithStatus(204)
+		return
+	}
+
+	c.Next()
+}
+
 func (s *RESTServer) registerRoutes() {
-	s.Router.GET("/servers", s.handleListServers)
-	s.Router.GET("/metrics/:server_id", s.handleGetMetrics)
-	s.Router.GET("/servers/:server_id/services", s.handleGetServiceStatus)
+	s.Router.GET("/healthz", s.handleHealthz)
+	s.Router.GET("/readyz", s.handleReadyz)
+	s.Router.POST("/auth/login", s.handleLogin)
+
+	viewer := s.Router.Group("", requireRole(RoleViewer))
+	viewer.POST("/auth/logout", s.handleLogout)
+	viewer.GET("/auth/me", s.handleWhoAmI)
+	viewer.GET("/servers", s.handleListServers)
+	viewer.GET("/servers/:server_id", s.handleGetServer)
+	viewer.GET("/servers/:server_id/latest", s.handleGetLatest)
+	viewer.GET("/metrics", s.handleListMetrics)
+	viewer.GET("/metrics/:server_id", s.handleGetMetrics)
+	viewer.GET("/latest", s.handleListLatest)
+	viewer.GET("/query", s.handleQuery)
+	viewer.POST("/live/token", s.handleLiveToken)
+	viewer.GET("/live", s.handleLive)
+	viewer.GET("/live/:server_id", s.handleLive)
+	viewer.GET("/servers/:server_id/services", s.handleGetServiceStatus)
+	viewer.GET("/servers/:server_id/events", s.handleGetEvents)
+	viewer.GET("/servers/:server_id/config", s.handleGetServerConfig)
+	viewer.GET("/config/profiles", s.handleListConfigProfiles)
+	viewer.GET("/config/profiles/:name", s.handleGetConfigProfile)
+	viewer.GET("/config/status", s.handleListConfigStatus)
+	viewer.GET("/jobs", s.handleListJobs)
+	viewer.GET("/jobs/:id", s.handleGetJob)
+
+	operator := s.Router.Group("", requireRole(RoleOperator))
+	operator.POST("/servers/:server_id/decommission", s.handleDecommissionServer)
+	operator.POST("/servers/:server_id/recommission", s.handleRecommissionServer)
+	operator.PUT("/servers/:server_id/config", s.handleAssignConfig)
+	operator.DELETE("/servers/:server_id/config", s.handleUnassignConfig)
+	operator.PUT("/config/profiles/:name/selector", s.handleSetProfileSelector)
+	operator.DELETE("/config/profiles/:name/selector", s.handleDeleteProfileSelector)
+
+	admin := s.Router.Group("", requireRole(RoleAdmin))
+	admin.DELETE("/servers/:server_id", s.handleDeleteServer)
+	admin.POST("/servers/:server_id/rename", s.handleRenameServer)
+	admin.PUT("/servers/:server_id/labels", s.handleSetServerLabels)
+	admin.PUT("/config/profiles/:name", s.handleSaveConfigProfile)
+	admin.DELETE("/config/profiles/:name", s.handleDeleteConfigProfile)
+	admin.GET("/users", s.handleListUsers)
+	admin.POST("/users", s.handleCreateUser)
+	admin.PUT("/users/:username", s.handleUpdateUser)
+	admin.DELETE("/users/:username", s.handleDeleteUser)
+	admin.GET("/api-keys", s.handleListAPIKeys)
+	admin.POST("/api-keys", s.handleCreateAPIKey)
+	admin.DELETE("/api-keys/:id", s.handleDeleteAPIKey)
+	admin.GET("/audit", s.handleGetAuditLog)
 }
 
 func (s *RESTServer) handleListServers(c *gin.Context) {
-	servers, err := s.Store.ListServers(c.Request.Context())
+	q := ServerQuery{IncludeDecommissioned: c.Query("include_decommissioned") == "true"}
+	var err error
+	if q.Selector, err = ParseSelector(c.Query("selector")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	servers, err := s.Store.ListServers(c.Request.Context(), q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range servers {
+		servers[i].Conflict = s.Streams.Conflicting(servers[i].ServerID)
+	}
 	c.JSON(http.StatusOK, servers)
 }
 
+func (s *RESTServer) handleGetServer(c *gin.Context) {
+	serverID := c.Param("server_id")
+	server, err := s.Store.GetServer(c.Request.Context(), serverID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	server.Streams = s.Streams.Streams(serverID)
+	server.Conflict = s.Streams.Conflicting(serverID)
+	c.JSON(http.StatusOK, server)
+}
+
+// handleSetServerLabels replaces the labels set through the API for a
+// server: {"env": "prod", "team": "payments"}. An empty object removes them.
+func (s *RESTServer) handleSetServerLabels(c *gin.Context) {
+	var labels map[string]string
+	if err := c.ShouldBindJSON(&labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateLabels(labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := s.Store.SetServerLabels(c.Request.Context(), c.Param("server_id"), labels)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		s.handleGetServer(c)
+	}
+}
+
+func (s *RESTServer) handleDecommissionServer(c *gin.Context) {
+	s.setDecommissioned(c, true)
+}
+
+func (s *RESTServer) handleRecommissionServer(c *gin.Context) {
+	s.setDecommissioned(c, false)
+}
+
+func (s *RESTServer) setDecommissioned(c *gin.Context, decommissioned bool) {
+	err := s.Store.SetDecommissioned(c.Request.Context(), c.Param("server_id"), decommissioned)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// handleDeleteServer starts a job that deletes a server and all its data.
+// The response is the job; poll /jobs/:id for its progress.
+func (s *RESTServer) handleDeleteServer(c *gin.Context) {
+	serverID := c.Param("server_id")
+	if !s.checkServerIdle(c, serverID) {
+		return
+	}
+	job, err := s.Jobs.Start("delete", serverID, principalOf(c).Name, func(ctx context.Context, progress ProgressFunc) error {
+		if err := s.Store.DeleteServer(ctx, serverID, progress); err != nil {
+			return err
+		}
+		s.Latest.Remove(serverID)
+		return nil
+	})
+	s.respondJob(c, job, err)
+}
+
+// maxServerIDLen matches the limit agents enforce on their server_id.
+const maxServerIDLen = 128
+
+// handleRenameServer starts a job that moves a server's history to a new
+// server_id: {"server_id": "new-name"}.
+func (s *RESTServer) handleRenameServer(c *gin.Context) {
+	oldID := c.Param("server_id")
+	var req struct {
+		ServerID string `json:"server_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newID := req.ServerID
+	if len(newID) > maxServerIDLen || strings.IndexFunc(newID, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("server_id must be at most %d characters without spaces or control characters", maxServerIDLen)})
+		return
+	}
+	if newID == oldID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the new server_id is the same as the old one"})
+		return
+	}
+	if !s.checkServerIdle(c, oldID) {
+		return
+	}
+	job, err := s.Jobs.Start("rename", oldID, principalOf(c).Name, func(ctx context.Context, progress ProgressFunc) error {
+		if err := s.Store.RenameServer(ctx, oldID, newID, progress); err != nil {
+			return err
+		}
+		s.Latest.Rename(oldID, newID)
+		return nil
+	})
+	s.respondJob(c, job, err)
+}
+
+// checkServerIdle responds with an error and returns false unless the server
+// exists and no agent is streaming under its server_id, which would write
+// new data under the old name while the job runs.
+func (s *RESTServer) checkServerIdle(c *gin.Context, serverID string) bool {
+	_, err := s.Store.GetServer(c.Request.Context(), serverID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(s.Streams.Streams(serverID)) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "an agent is still connected as " + serverID + "; stop it or change its server_id first"})
+		return false
+	}
+	return true
+}
+
+func (s *RESTServer) respondJob(c *gin.Context, job Job, err error) {
+	if errors.Is(err, ErrJobRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(auditJobKey, job.ID)
+	c.Header("Location", "/jobs/"+strconv.FormatInt(job.ID, 10))
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (s *RESTServer) handleListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Jobs.List())
+}
+
+func (s *RESTServer) handleGetJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	job, ok := s.Jobs.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
 func (s *RESTServer) handleGetMetrics(c *gin.Context) {
 	serverID := c.Param("server_id")
 	metrics, err := s.Store.GetMetrics(c.Request.Context(), serverID)
@@ -62,6 +332,29 @@ func (s *RESTServer) handleGetMetrics(c *gin.Context) {
 	c.JSON(http.StatusOK, metrics)
 }
 
+// handleListMetrics serves the newest metrics across servers. Query
+// parameters: selector (e.g. env=prod,role=db), metric and limit.
+func (s *RESTServer) handleListMetrics(c *gin.Context) {
+	q := MetricQuery{MetricType: c.Query("metric")}
+	var err error
+	if q.Selector, err = ParseSelector(c.Query("selector")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+	metrics, err := s.Store.ListMetrics(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, metrics)
+}
+
 func (s *RESTServer) handleGetServiceStatus(c *gin.Context) {
 	serverID := c.Param("server_id")
 	services, err := s.Store.GetServiceStatus(c.Request.Context(), serverID)
@@ -72,6 +365,266 @@ func (s *RESTServer) handleGetServiceStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, services)
 }
 
-func (s *RESTServer) Run(addr string) error {
-	return s.Router.Run(addr)
+// handleGetEvents serves an agent's events, newest first. Query parameters:
+// from and to (RFC 3339, default the last 24 hours), severity (comma separated
+// list), min_severity, q (full-text search) and limit.
+func (s *RESTServer) handleGetEvents(c *gin.Context) {
+	serverID := c.Param("server_id")
+
+	q := EventQuery{
+		To:          time.Now(),
+		MinSeverity: c.Query("min_severity"),
+		Text:        c.Query("q"),
+	}
+	var err error
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: " + err.Error()})
+			return
+		}
+	}
+	q.From = q.To.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: " + err.Error()})
+			return
+		}
+	}
+	if v := c.Query("severity"); v != "" {
+		q.Severities = strings.Split(v, ",")
+	}
+	for _, name := range append(q.Severities, q.MinSeverity) {
+		if _, ok := SeverityLevel(name); name != "" && !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown severity: " + name})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	events, err := s.Store.GetEvents(c.Request.Context(), serverID, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// profileNamePattern keeps profile names usable in URLs.
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func (s *RESTServer) handleListConfigProfiles(c *gin.Context) {
+	profiles, err := s.Store.ListConfigProfiles(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+// handleGetConfigProfile serves the latest version of a profile, or the one
+// given by the version query parameter.
+func (s *RESTServer) handleGetConfigProfile(c *gin.Context) {
+	var version int64
+	if v := c.Query("version"); v != "" {
+		var err error
+		if version, err = strconv.ParseInt(v, 10, 64); err != nil || version <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return
+		}
+	}
+	profile, err := s.Store.GetConfigProfile(c.Request.Context(), c.Param("name"), version)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// handleSaveConfigProfile stores a new version of a profile. The body is
+// {"description": "...", "config": {...}}, where config uses the layout of
+// agent-config.json and is merged over each agent's local file.
+func (s *RESTServer) handleSaveConfigProfile(c *gin.Context) {
+	name := c.Param("name")
+	if !profileNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "profile names may only contain letters, digits, '.', '_' and '-'"})
+		return
+	}
+	var req struct {
+		Description string          `json:"description"`
+		Config      json.RawMessage `json:"config"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(req.Config, &fields); err != nil || fields == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "config must be a JSON object"})
+		return
+	}
+	for _, k := range proto.RemoteLockedKeys {
+		if _, ok := proto.LookupConfigKey(fields, k); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": k + " can only be set in the agent's local config"})
+			return
+		}
+	}
+
+	profile, err := s.Store.SaveConfigProfile(c.Request.Context(), name, req.Description, req.Config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (s *RESTServer) handleDeleteConfigProfile(c *gin.Context) {
+	err := s.Store.DeleteConfigProfile(c.Request.Context(), c.Param("name"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+	case errors.Is(err, ErrProfileInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// handleGetServerConfig serves the profile a server should run along with
+// what its agent last reported.
+func (s *RESTServer) handleGetServerConfig(c *gin.Context) {
+	status, err := s.Store.GetConfigStatus(c.Request.Context(), c.Param("server_id"))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// handleAssignConfig assigns a profile to a server: {"profile": "web"}.
+func (s *RESTServer) handleAssignConfig(c *gin.Context) {
+	var req struct {
+		Profile string `json:"profile" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := s.Store.AssignConfigProfile(c.Request.Context(), c.Param("server_id"), req.Profile)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleSetProfileSelector assigns a profile to the servers whose labels
+// match a selector: {"selector": "env=prod,role=web", "priority": 10}.
+// Servers with a profile of their own keep it.
+func (s *RESTServer) handleSetProfileSelector(c *gin.Context) {
+	var req struct {
+		Selector string `json:"selector" binding:"required"`
+		Priority int    `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	selector, err := ParseSelector(req.Selector)
+	if err == nil && len(selector) == 0 {
+		err = errors.New("selector is empty; use the default profile for every server")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = s.Store.SetProfileSelector(c.Request.Context(), c.Param("name"), selector, req.Priority)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *RESTServer) handleDeleteProfileSelector(c *gin.Context) {
+	if err := s.Store.SetProfileSelector(c.Request.Context(), c.Param("name"), nil, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleUnassignConfig puts a server back on the default profile.
+func (s *RESTServer) handleUnassignConfig(c *gin.Context) {
+	if err := s.Store.AssignConfigProfile(c.Request.Context(), c.Param("server_id"), ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleListConfigStatus serves the config status of every server. With
+// ?outdated=true only servers not running their latest profile are listed.
+func (s *RESTServer) handleListConfigStatus(c *gin.Context) {
+	statuses, err := s.Store.ListConfigStatus(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("outdated") == "true" {
+		outdated := []AgentConfigStatus{}
+		for _, st := range statuses {
+			if !st.UpToDate {
+				outdated = append(outdated, st)
+			}
+		}
+		statuses = outdated
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// Run serves the API on the configured address, over TLS if a certificate is
+// set, until Shutdown. It then returns http.ErrServerClosed.
+func (s *RESTServer) Run() error {
+	l := s.Config.HTTP
+	if l.TLS() {
+		return s.server.ListenAndServeTLS(l.CertFile, l.KeyFile)
+	}
+	return s.server.ListenAndServe()
+}
+
+// Drain makes /readyz fail while the server keeps serving, so load balancers
+// take it out of rotation before Shutdown closes the listener.
+func (s *RESTServer) Drain() {
+	s.draining.Store(true)
+}
+
+// Shutdown fails readiness checks, stops accepting connections and waits for
+// the requests in flight until ctx expires. Live streams are ended first.
+func (s *RESTServer) Shutdown(ctx context.Context) error {
+	s.Drain()
+	s.Live.Close()
+	return s.server.Shutdown(ctx)
 }
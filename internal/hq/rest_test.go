@@ -0,0 +1,107 @@
+package hq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sentinel/internal/proto"
+)
+
+// profileStore saves config profiles on top of auditStore.
+type profileStore struct {
+	auditStore
+	saved []json.RawMessage
+}
+
+func (f *profileStore) GetConfigProfile(ctx context.Context, name string, version int64) (*ConfigProfile, error) {
+	return nil, ErrNotFound
+}
+
+func (f *profileStore) SaveConfigProfile(ctx context.Context, name, description string, config json.RawMessage) (*ConfigProfile, error) {
+	f.saved = append(f.saved, config)
+	return &ConfigProfile{Name: name, Version: int64(len(f.saved)), Description: description, Config: config}, nil
+}
+
+func TestSaveConfigProfileLockedKeys(t *testing.T) {
+	store := &profileStore{}
+	s := NewRESTServer(store, NewStreamRegistry(), NewLiveBroker(), NewLatestCache(), DefaultConfig())
+	t.Cleanup(s.Live.Close)
+	save := func(config string) int {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/profiles/web", strings.NewReader(`{"config": `+config+`}`)))
+		return w.Code
+	}
+
+	for _, config := range []string{
+		`{"server_id": "a"}`,
+		`{"state_dir": "/tmp"}`,
+		`{"hq_tls": {"enabled": false}}`,
+		`{"plugins": {"exec": null}}`,
+		`{"plugins": {"logs": {"files": [{"path": "/etc/shadow"}]}}}`,
+		`{"plugins": {"checks": {"enabled": true}}}`,
+	} {
+		if code := save(config); code != http.StatusBadRequest {
+			t.Errorf("saving %s = %d, want %d", config, code, http.StatusBadRequest)
+		}
+	}
+	for _, config := range []string{
+		`{"labels": {"env": "prod"}}`,
+		`{"plugins": {"system": {"interval": "10s"}, "services": {"enabled": true}}}`,
+	} {
+		if code := save(config); code != http.StatusOK {
+			t.Errorf("saving %s = %d, want %d", config, code, http.StatusOK)
+		}
+	}
+	if len(store.saved) != 2 {
+		t.Errorf("%d profiles saved, want 2", len(store.saved))
+	}
+}
+
+func TestLookupConfigKey(t *testing.T) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(`{"server_id": "a", "plugins": {"exec": null, "system": {}}, "labels": "x"}`), &obj); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"server_id":      true,
+		"hq_address":     false,
+		"plugins.exec":   true,
+		"plugins.system": true,
+		"plugins.checks": false,
+		"labels.env":     false,
+	} {
+		if _, got := proto.LookupConfigKey(obj, key); got != want {
+			t.Errorf("LookupConfigKey(%s) = %t, want %t", key, got, want)
+		}
+	}
+}
+
+// pingStore is a store that is always reachable.
+type pingStore struct{ MetricStore }
+
+func (pingStore) Ping(ctx context.Context) error { return nil }
+
+func TestDrainFailsReadiness(t *testing.T) {
+	s := NewRESTServer(pingStore{}, NewStreamRegistry(), NewLiveBroker(), NewLatestCache(), DefaultConfig())
+	t.Cleanup(s.Live.Close)
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Errorf("/readyz = %d before draining", got)
+	}
+	// Draining keeps serving requests but takes the server out of rotation.
+	s.Drain()
+	for path, want := range map[string]int{"/readyz": http.StatusServiceUnavailable, "/healthz": http.StatusOK} {
+		if got := get(path); got != want {
+			t.Errorf("%s = %d while draining, want %d", path, got, want)
+		}
+	}
+}
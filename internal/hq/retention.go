@@ -0,0 +1,40 @@
+package hq
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunRetention deletes data older than the retention periods, once at start
+// and then every PruneInterval, until ctx is cancelled.
+func RunRetention(ctx context.Context, store MetricStore, cfg RetentionConfig) {
+	if cfg.Metrics <= 0 && cfg.Events <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		var metricsBefore, eventsBefore time.Time
+		now := time.Now()
+		if cfg.Metrics > 0 {
+			metricsBefore = now.Add(-cfg.Metrics)
+		}
+		if cfg.Events > 0 {
+			eventsBefore = now.Add(-cfg.Events)
+		}
+		metrics, events, err := store.Prune(ctx, metricsBefore, eventsBefore)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error pruning old data: %v", err)
+		} else if metrics > 0 || events > 0 {
+			log.Printf("Pruned %d metrics and %d events past retention", metrics, events)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
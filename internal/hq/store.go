@@ -3,10 +3,16 @@ package hq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"sentinel/internal/proto"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -23,39 +29,384 @@ type ServerStatus struct {
 	ServerID  string    `json:"server_id"`
 	LastSeen  time.Time `json:"last_seen"`
 	IPAddress string    `json:"ip_address,omitempty"`
+	MachineID string    `json:"machine_id,omitempty"`
+	// Labels are the agent's labels with those set through the API over them.
+	Labels map[string]string `json:"labels"`
+	// DecommissionedAt is set for retired servers, which ListServers hides.
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"`
+	// Conflict is set while more than one host streams under this server_id.
+	Conflict bool `json:"conflict,omitempty"`
 }
 
+// ErrNotFound is returned when the requested record doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// HostInfo is the inventory an agent reports about its host.
+type HostInfo struct {
+	Hostname          string            `json:"hostname"`
+	OS                string            `json:"os"`
+	Platform          string            `json:"platform"`
+	PlatformFamily    string            `json:"platform_family"`
+	PlatformVersion   string            `json:"platform_version"`
+	KernelVersion     string            `json:"kernel_version"`
+	KernelArch        string            `json:"kernel_arch"`
+	CPUModel          string            `json:"cpu_model"`
+	CPUCount          int               `json:"cpu_count"`
+	MemoryTotalBytes  int64             `json:"memory_total_bytes"`
+	BootTime          time.Time         `json:"boot_time"`
+	AgentVersion      string            `json:"agent_version"`
+	ConfigHash        string            `json:"config_hash"`
+	Labels            map[string]string `json:"labels"`              // from the agent config
+	ServiceIntervalMs int64             `json:"service_interval_ms"` // how often services are reported; 0 if not
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+// ServerDetails is a server's status together with its host inventory, if
+// the agent has reported one.
+type ServerDetails struct {
+	ServerStatus
+	// AdminLabels are the labels set through the API.
+	AdminLabels map[string]string `json:"admin_labels"`
+	Host        *HostInfo         `json:"host"`
+	Streams     []StreamInfo      `json:"streams"`
+}
+
+// ServerQuery filters ListServers.
+type ServerQuery struct {
+	IncludeDecommissioned bool
+	Selector              Selector
+}
+
+// MetricQuery filters ListMetrics. Zero values mean no filter, except Limit
+// which falls back to DefaultMetricLimit.
+type MetricQuery struct {
+	Selector   Selector
+	MetricType string
+	Limit      int
+}
+
+const (
+	DefaultMetricLimit = 100
+	MaxMetricLimit     = 1000
+)
+
+// FleetQuery aggregates one metric across the servers a selector picks.
+// Points are grouped by GroupBy, and by time buckets of Step unless Step is
+// zero, in which case each group has a single point for the whole range.
+type FleetQuery struct {
+	Metric   string
+	Selector Selector
+	From, To time.Time
+	Step     time.Duration
+	Agg      string
+	// GroupBy holds server_id, resource or server label keys. An empty
+	// GroupBy aggregates every matching point together.
+	GroupBy []string
+}
+
+// Series is the result of a FleetQuery for one group, keyed by the values
+// of its GroupBy fields.
+type Series struct {
+	Labels map[string]string `json:"labels"`
+	Points []Point           `json:"points"`
+}
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// SeriesQuery reads the raw points of a metric, to evaluate expressions on.
+// A series' labels are its server_id, its server's labels and its metric
+// tags, tags taking precedence.
+type SeriesQuery struct {
+	Metric   string
+	From, To time.Time // From is exclusive, To inclusive
+	// Equal narrows the read to series that may have these label values.
+	// Callers still check the labels of what comes back.
+	Equal map[string]string
+}
+
+// aggregations are the SQL of the FleetQuery aggregations. last takes the
+// newest point of the group.
+var aggregations = map[string]string{
+	"avg":   "AVG(m.value)",
+	"min":   "MIN(m.value)",
+	"max":   "MAX(m.value)",
+	"sum":   "SUM(m.value)",
+	"count": "COUNT(*)::double precision",
+	"last":  "(ARRAY_AGG(m.value ORDER BY m.time DESC))[1]",
+}
+
+// MaxQueryPoints bounds the points a FleetQuery may return in total.
+const MaxQueryPoints = 100000
+
+// ErrTooManyPoints is returned for a FleetQuery that would return more than
+// MaxQueryPoints.
+var ErrTooManyPoints = fmt.Errorf("the query returns more than %d points; use a larger step, a shorter range or a narrower selector", MaxQueryPoints)
+
+// serverLabels is the SQL for a server's labels, in queries that join
+// server_status as s with host_info as h.
+const serverLabels = `COALESCE(h.labels, '{}'::jsonb) || s.labels`
+
+// Service states reported by GetServiceStatus.
+const (
+	ServiceUp      = "up"
+	ServiceDown    = "down"
+	ServiceUnknown = "unknown"
+)
+
+const (
+	// DefaultCollectionInterval mirrors the agent's default collection interval.
+	DefaultCollectionInterval = 5 * time.Second
+	// DefaultServiceStaleIntervals is how many of its agent's reporting
+	// intervals may pass without a report before a service is considered
+	// unknown.
+	DefaultServiceStaleIntervals = 3
+	// ServiceHistoryWindow is how far back GetServiceStatus looks. Services
+	// not reported in it are left out, and a service that hasn't changed in
+	// it reports the start of its history in the window as its last change.
+	ServiceHistoryWindow = 7 * 24 * time.Hour
+)
+
 type ServiceStatus struct {
 	ServiceName string    `json:"service_name"`
 	Status      float64   `json:"status"`
+	State       string    `json:"state"`
 	LastSeen    time.Time `json:"last_seen"`
+	LastChange  time.Time `json:"last_change"`
+}
+
+// Event is a structured message sent by an agent, such as a matching log
+// line or a service going down.
+type Event struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	ServerID   string          `json:"server_id"`
+	Severity   string          `json:"severity"`
+	Source     string          `json:"source"`
+	Message    string          `json:"message"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// EventQuery filters GetEvents. Zero values mean no filter, except Limit
+// which falls back to DefaultEventLimit.
+type EventQuery struct {
+	From        time.Time
+	To          time.Time
+	Severities  []string // exact severities, e.g. "error", "critical"
+	MinSeverity string   // this severity or worse
+	Text        string   // full-text search over source and message
+	Limit       int
+}
+
+const (
+	DefaultEventLimit = 100
+	MaxEventLimit     = 1000
+)
+
+// Event severities, least to most severe. Their index is the level stored in
+// the events table.
+var severityNames = []string{"unspecified", "debug", "info", "warning", "error", "critical"}
+
+// SeverityLevel returns the stored level for a severity name.
+func SeverityLevel(name string) (int, bool) {
+	for i, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func severityName(level int) string {
+	if level < 0 || level >= len(severityNames) {
+		return severityNames[0]
+	}
+	return severityNames[level]
+}
+
+// DefaultProfile is the config profile for servers without an assignment.
+const DefaultProfile = "default"
+
+// ErrProfileInUse is returned when deleting a profile servers are assigned to.
+var ErrProfileInUse = errors.New("profile is assigned to servers")
+
+// AuditEntry records one write request to the REST API: who made it, what
+// it changed and how it ended. Before and After are snapshots of the
+// resource, or the request body when there is no snapshot for the route.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	ActorKind string          `json:"actor_kind"`
+	Role      string          `json:"role"`
+	IPAddress string          `json:"ip_address"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Route     string          `json:"route"`
+	Status    int             `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// AuditQuery filters GetAuditLog. Zero values mean no filter, except Limit
+// which falls back to DefaultEventLimit.
+type AuditQuery struct {
+	From       time.Time
+	To         time.Time
+	Actor      string
+	Method     string
+	Route      string // e.g. "/config/profiles/:name"
+	PathPrefix string
+	Limit      int
+}
+
+// ErrAlreadyExists is returned when creating a record whose key is taken.
+var ErrAlreadyExists = errors.New("already exists")
+
+// User is a person who logs in to the REST API with a password.
+type User struct {
+	Username     string    `json:"username"`
+	Role         string    `json:"role"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// APIKey is a long-lived credential for scripts and integrations. Only a
+// hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `json:"role"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ConfigProfile is one version of a centrally managed agent config. Saving a
+// profile adds a version; earlier versions are kept.
+type ConfigProfile struct {
+	Name        string          `json:"name"`
+	Version     int64           `json:"version"`
+	Description string          `json:"description"`
+	Config      json.RawMessage `json:"config"`
+	CreatedAt   time.Time       `json:"created_at"`
+
+	// Selector assigns the profile to servers whose labels match it and
+	// that have no profile of their own. If several selectors match, the
+	// one with the highest Priority wins, then the first profile by name.
+	Selector string `json:"selector,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// AgentConfigStatus compares the profile version a server should run with
+// what its agent last reported.
+type AgentConfigStatus struct {
+	ServerID       string     `json:"server_id"`
+	Profile        string     `json:"profile"`
+	Version        int64      `json:"version"`
+	AppliedProfile string     `json:"applied_profile"`
+	AppliedVersion int64      `json:"applied_version"`
+	Error          string     `json:"error,omitempty"`
+	ConfigHash     string     `json:"config_hash,omitempty"`
+	ReportedAt     *time.Time `json:"reported_at"`
+	UpToDate       bool       `json:"up_to_date"`
 }
 
 type MetricStore interface {
 	Init(ctx context.Context) error
+	Ping(ctx context.Context) error
 	SaveBatch(ctx context.Context, batch *proto.MetricBatch, ipAddress string) error
-	ListServers(ctx context.Context) ([]ServerStatus, error)
+	ListServers(ctx context.Context, q ServerQuery) ([]ServerStatus, error)
+	GetServer(ctx context.Context, serverID string) (*ServerDetails, error)
+	SetServerLabels(ctx context.Context, serverID string, labels map[string]string) error
+	SetDecommissioned(ctx context.Context, serverID string, decommissioned bool) error
+	DeleteServer(ctx context.Context, serverID string, progress ProgressFunc) error
+	RenameServer(ctx context.Context, oldID, newID string, progress ProgressFunc) error
 	GetMetrics(ctx context.Context, serverID string) ([]Metric, error)
+	ListMetrics(ctx context.Context, q MetricQuery) ([]Metric, error)
+	LatestMetrics(ctx context.Context, since time.Time) ([]Metric, error)
+	QueryMetrics(ctx context.Context, q FleetQuery) ([]Series, error)
+	ReadSeries(ctx context.Context, q SeriesQuery) ([]Series, error)
 	GetServiceStatus(ctx context.Context, serverID string) ([]ServiceStatus, error)
+	GetEvents(ctx context.Context, serverID string, q EventQuery) ([]Event, error)
+	SaveEvent(ctx context.Context, e Event) error
+	Prune(ctx context.Context, metricsBefore, eventsBefore time.Time) (metrics, events int64, err error)
+	ListConfigProfiles(ctx context.Context) ([]ConfigProfile, error)
+	GetConfigProfile(ctx context.Context, name string, version int64) (*ConfigProfile, error)
+	SaveConfigProfile(ctx context.Context, name, description string, config json.RawMessage) (*ConfigProfile, error)
+	DeleteConfigProfile(ctx context.Context, name string) error
+	AssignConfigProfile(ctx context.Context, serverID, profile string) error
+	SetProfileSelector(ctx context.Context, profile string, selector Selector, priority int) error
+	GetEffectiveConfig(ctx context.Context, serverID string) (*ConfigProfile, error)
+	SaveConfigStatus(ctx context.Context, status *proto.ConfigStatus) error
+	ListConfigStatus(ctx context.Context) ([]AgentConfigStatus, error)
+	GetConfigStatus(ctx context.Context, serverID string) (*AgentConfigStatus, error)
+	CountUsers(ctx context.Context) (int, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	GetUser(ctx context.Context, username string) (*User, error)
+	CreateUser(ctx context.Context, u User) (*User, error)
+	UpdateUser(ctx context.Context, username, role, passwordHash string) error
+	DeleteUser(ctx context.Context, username string) error
+	CreateSession(ctx context.Context, tokenHash, username string, expiresAt time.Time) error
+	GetSessionUser(ctx context.Context, tokenHash string) (*User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error)
+	GetAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int64) error
+	SaveAuditEntry(ctx context.Context, e AuditEntry) error
+	GetAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
 	Close()
 }
 
 type DBStore struct {
 	db *pgxpool.Pool
+
+	// ServiceStaleAfter is how long a service may go unreported before its
+	// state becomes unknown.
+	ServiceStaleAfter time.Duration
 }
 
-func NewDBStore(ctx context.Context, connString string) (*DBStore, error) {
-	pool, err := pgxpool.New(ctx, connString)
+func NewDBStore(ctx context.Context, cfg DatabaseConfig) (*DBStore, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database url: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
-	return &DBStore{db: pool}, nil
+	return &DBStore{
+		db:                pool,
+		ServiceStaleAfter: DefaultServiceStaleIntervals * DefaultCollectionInterval,
+	}, nil
 }
 
 func (s *DBStore) Close() {
 	s.db.Close()
 }
 
+// Ping checks that the database can be reached.
+func (s *DBStore) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
 func (s *DBStore) Init(ctx context.Context) error {
 	// 1. Create Metrics Table (New Schema with Resource)
 	_, err := s.db.Exec(ctx, `
@@ -68,6 +419,7 @@ func (s *DBStore) Init(ctx context.Context) error {
 			tags        JSONB,
 			CONSTRAINT metrics_pkey PRIMARY KEY (server_id, metric_type, resource, time)
 		);
+		CREATE INDEX IF NOT EXISTS metrics_time_idx ON metrics (time);
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create metrics table: %w", err)
@@ -80,11 +432,157 @@ func (s *DBStore) Init(ctx context.Context) error {
 			last_seen   TIMESTAMPTZ NOT NULL,
 			ip_address  TEXT
 		);
+		ALTER TABLE server_status ADD COLUMN IF NOT EXISTS machine_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE server_status ADD COLUMN IF NOT EXISTS decommissioned_at TIMESTAMPTZ;
+		ALTER TABLE server_status ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create server_status table: %w", err)
 	}
 
+	// 3. Create Events Table (with a full-text search column)
+	_, err = s.db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS events (
+			id          BIGSERIAL PRIMARY KEY,
+			time        TIMESTAMPTZ NOT NULL,
+			server_id   TEXT NOT NULL,
+			severity    SMALLINT NOT NULL,
+			source      TEXT NOT NULL DEFAULT '',
+			message     TEXT NOT NULL,
+			attributes  JSONB,
+			search      TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', source || ' ' || message)) STORED
+		);
+		CREATE INDEX IF NOT EXISTS events_server_time_idx ON events (server_id, time DESC);
+		CREATE INDEX IF NOT EXISTS events_time_idx ON events (time);
+		CREATE INDEX IF NOT EXISTS events_search_idx ON events USING GIN (search);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create events table: %w", err)
+	}
+
+	// 4. Create Host Info Table
+	_, err = s.db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS host_info (
+			server_id           TEXT PRIMARY KEY,
+			hostname            TEXT NOT NULL DEFAULT '',
+			os                  TEXT NOT NULL DEFAULT '',
+			platform            TEXT NOT NULL DEFAULT '',
+			platform_family     TEXT NOT NULL DEFAULT '',
+			platform_version    TEXT NOT NULL DEFAULT '',
+			kernel_version      TEXT NOT NULL DEFAULT '',
+			kernel_arch         TEXT NOT NULL DEFAULT '',
+			cpu_model           TEXT NOT NULL DEFAULT '',
+			cpu_count           INTEGER NOT NULL DEFAULT 0,
+			memory_total_bytes  BIGINT NOT NULL DEFAULT 0,
+			boot_time           TIMESTAMPTZ,
+			agent_version       TEXT NOT NULL DEFAULT '',
+			config_hash         TEXT NOT NULL DEFAULT '',
+			updated_at          TIMESTAMPTZ NOT NULL
+		);
+		ALTER TABLE host_info ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE host_info ADD COLUMN IF NOT EXISTS service_interval_ms BIGINT NOT NULL DEFAULT 0;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create host_info table: %w", err)
+	}
+
+	// 5. Create Config Profile Tables
+	_, err = s.db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS config_profiles (
+			name        TEXT NOT NULL,
+			version     BIGINT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			config      JSONB NOT NULL,
+			created_at  TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (name, version)
+		);
+		CREATE TABLE IF NOT EXISTS config_assignments (
+			server_id   TEXT PRIMARY KEY,
+			profile     TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS config_selectors (
+			profile     TEXT PRIMARY KEY,
+			selector    TEXT NOT NULL,
+			priority    INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS agent_config_status (
+			server_id   TEXT PRIMARY KEY,
+			profile     TEXT NOT NULL DEFAULT '',
+			version     BIGINT NOT NULL DEFAULT 0,
+			applied     BOOLEAN NOT NULL,
+			error       TEXT NOT NULL DEFAULT '',
+			config_hash TEXT NOT NULL DEFAULT '',
+			reported_at TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create config profile tables: %w", err)
+	}
+
+	// 6. Create Auth Tables (only hashes of tokens and keys are stored)
+	_, err = s.db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS users (
+			username      TEXT PRIMARY KEY,
+			role          TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash  TEXT PRIMARY KEY,
+			username    TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+			expires_at  TIMESTAMPTZ NOT NULL,
+			created_at  TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id           BIGSERIAL PRIMARY KEY,
+			name         TEXT NOT NULL,
+			prefix       TEXT NOT NULL,
+			key_hash     TEXT NOT NULL UNIQUE,
+			role         TEXT NOT NULL,
+			created_by   TEXT NOT NULL,
+			created_at   TIMESTAMPTZ NOT NULL,
+			last_used_at TIMESTAMPTZ
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create auth tables: %w", err)
+	}
+
+	// 7. Create Audit Log Table (append-only, enforced by a trigger)
+	_, err = s.db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id          BIGSERIAL PRIMARY KEY,
+			time        TIMESTAMPTZ NOT NULL,
+			actor       TEXT NOT NULL,
+			actor_kind  TEXT NOT NULL,
+			role        TEXT NOT NULL DEFAULT '',
+			ip_address  TEXT NOT NULL,
+			method      TEXT NOT NULL,
+			path        TEXT NOT NULL,
+			route       TEXT NOT NULL,
+			status      INTEGER NOT NULL,
+			error       TEXT NOT NULL DEFAULT '',
+			before      JSONB,
+			after       JSONB
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time DESC);
+
+		CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS audit_log_no_change ON audit_log;
+		CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+			FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+		DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+		CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+
 	return nil
 }
 
@@ -95,27 +593,64 @@ func (s *DBStore) SaveBatch(ctx context.Context, batch *proto.MetricBatch, ipAdd
 	}
 	defer tx.Rollback(ctx)
 
-	// Update Last Seen
+	// Update Last Seen (agents from before machine IDs send none; keep the known one)
 	_, err = tx.Exec(ctx, `
-		INSERT INTO server_status (server_id, last_seen, ip_address)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (server_id) DO UPDATE SET last_seen = $2, ip_address = $3
-	`, batch.ServerId, batch.Timestamp.AsTime(), ipAddress)
+		INSERT INTO server_status (server_id, last_seen, ip_address, machine_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (server_id) DO UPDATE SET last_seen = $2, ip_address = $3,
+			machine_id = COALESCE(NULLIF($4, ''), server_status.machine_id)
+	`, batch.ServerId, batch.Timestamp.AsTime(), ipAddress, batch.MachineId)
 	if err != nil {
 		return err
 	}
 
+	// Update Host Info (only sent on connect and when it changes)
+	if h := batch.HostInfo; h != nil {
+		var bootTime *time.Time
+		if h.BootTime != nil {
+			t := h.BootTime.AsTime()
+			bootTime = &t
+		}
+		labels := h.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labelsJSON, _ := json.Marshal(labels)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO host_info (server_id, hostname, os, platform, platform_family, platform_version,
+				kernel_version, kernel_arch, cpu_model, cpu_count, memory_total_bytes, boot_time,
+				agent_version, config_hash, labels, service_interval_ms, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			ON CONFLICT (server_id) DO UPDATE SET
+				hostname = EXCLUDED.hostname,
+				os = EXCLUDED.os,
+				platform = EXCLUDED.platform,
+				platform_family = EXCLUDED.platform_family,
+				platform_version = EXCLUDED.platform_version,
+				kernel_version = EXCLUDED.kernel_version,
+				kernel_arch = EXCLUDED.kernel_arch,
+				cpu_model = EXCLUDED.cpu_model,
+				cpu_count = EXCLUDED.cpu_count,
+				memory_total_bytes = EXCLUDED.memory_total_bytes,
+				boot_time = EXCLUDED.boot_time,
+				agent_version = EXCLUDED.agent_version,
+				config_hash = EXCLUDED.config_hash,
+				labels = EXCLUDED.labels,
+				service_interval_ms = EXCLUDED.service_interval_ms,
+				updated_at = EXCLUDED.updated_at
+		`, batch.ServerId, h.Hostname, h.Os, h.Platform, h.PlatformFamily, h.PlatformVersion,
+			h.KernelVersion, h.KernelArch, h.CpuModel, h.CpuCount, int64(h.MemoryTotalBytes), bootTime,
+			h.AgentVersion, h.ConfigHash, labelsJSON, h.ServiceIntervalMs, batch.Timestamp.AsTime())
+		if err != nil {
+			return err
+		}
+	}
+
 	// Insert Metrics
 	for _, m := range batch.Metrics {
 		tagsJSON, _ := json.Marshal(m.Tags)
 
-		// Extract Resource from tags (for uniqueness)
-		resource := ""
-		if val, ok := m.Tags["service"]; ok {
-			resource = val
-		} else if val, ok := m.Tags["path"]; ok {
-			resource = val
-		}
+		resource := metricResource(m.Tags)
 
 		_, err = tx.Exec(ctx, `
 			INSERT INTO metrics (time, server_id, metric_type, resource, value, tags)
@@ -127,11 +662,131 @@ func (s *DBStore) SaveBatch(ctx context.Context, batch *proto.MetricBatch, ipAdd
 		}
 	}
 
+	// Insert Events
+	for _, e := range batch.Events {
+		attrsJSON, _ := json.Marshal(e.Attributes)
+		eventTime := batch.Timestamp.AsTime()
+		if e.Timestamp != nil {
+			eventTime = e.Timestamp.AsTime()
+		}
+		severity := int(e.Severity)
+		if e.Severity == proto.Severity_SEVERITY_UNSPECIFIED {
+			severity = int(proto.Severity_SEVERITY_INFO)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO events (time, server_id, severity, source, message, attributes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, eventTime, batch.ServerId, severity, e.Source, e.Message, attrsJSON)
+		if err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit(ctx)
 }
 
-func (s *DBStore) ListServers(ctx context.Context) ([]ServerStatus, error) {
-	rows, err := s.db.Query(ctx, "SELECT server_id, last_seen, COALESCE(ip_address, '') FROM server_status ORDER BY last_seen DESC")
+// SaveEvent stores an event raised by HQ itself rather than sent by an agent.
+func (s *DBStore) SaveEvent(ctx context.Context, e Event) error {
+	level, ok := SeverityLevel(e.Severity)
+	if !ok {
+		return fmt.Errorf("unknown severity %q", e.Severity)
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO events (time, server_id, severity, source, message, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, e.Time, e.ServerID, level, e.Source, e.Message, e.Attributes)
+	return err
+}
+
+// Prune deletes metrics and events older than the given times, in batches
+// like DeleteServer. A zero time keeps that kind of data.
+func (s *DBStore) Prune(ctx context.Context, metricsBefore, eventsBefore time.Time) (metrics, events int64, err error) {
+	if !metricsBefore.IsZero() {
+		if metrics, err = s.pruneTable(ctx, "metrics", metricsBefore); err != nil {
+			return metrics, 0, err
+		}
+	}
+	if !eventsBefore.IsZero() {
+		if events, err = s.pruneTable(ctx, "events", eventsBefore); err != nil {
+			return metrics, events, err
+		}
+	}
+	return metrics, events, nil
+}
+
+func (s *DBStore) pruneTable(ctx context.Context, table string, before time.Time) (int64, error) {
+	var total int64
+	for {
+		tag, err := s.db.Exec(ctx, `
+			DELETE FROM `+table+`
+			WHERE ctid = ANY(ARRAY(SELECT ctid FROM `+table+` WHERE time < $1 LIMIT $2))
+		`, before, deleteBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("pruning %s: %w", table, err)
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < deleteBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// resourceTags are the tags that identify what a metric is about. The values
+// of those present, in this order, start the metric's resource.
+var resourceTags = []string{"service", "path", "command", "check", "file", "pattern"}
+
+// descriptiveTags describe a sample rather than say which series it belongs
+// to, such as its unit or a service's current state, so they are left out of
+// the resource.
+var descriptiveTags = map[string]bool{
+	"unit": true, "type": true, "state": true, "sub_state": true, "start_type": true, "manager": true,
+}
+
+// metricResource extracts the resource from tags (for uniqueness): the
+// values of the resourceTags present, then every other tag that isn't
+// descriptive as key=value, sorted by key. That keeps apart series that only
+// differ in a custom tag, such as two exec metrics tagged queue=a and queue=b.
+func metricResource(tags map[string]string) string {
+	var parts []string
+	for _, key := range resourceTags {
+		if val, ok := tags[key]; ok {
+			parts = append(parts, val)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		if !descriptiveTags[key] && !slices.Contains(resourceTags, key) {
+			parts = append(parts, key+"="+tags[key])
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// ListServers returns the servers that match q, most recently seen first.
+// Decommissioned servers are left out unless q includes them.
+func (s *DBStore) ListServers(ctx context.Context, q ServerQuery) ([]ServerStatus, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := q.Selector.where(serverLabels, arg)
+	if !q.IncludeDecommissioned {
+		where = append(where, "s.decommissioned_at IS NULL")
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT s.server_id, s.last_seen, COALESCE(s.ip_address, ''), s.machine_id, s.decommissioned_at,
+			`+serverLabels+`
+		FROM server_status s
+		LEFT JOIN host_info h ON h.server_id = s.server_id
+		`+filter+`
+		ORDER BY s.last_seen DESC
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -140,12 +795,181 @@ func (s *DBStore) ListServers(ctx context.Context) ([]ServerStatus, error) {
 	var servers []ServerStatus
 	for rows.Next() {
 		var s ServerStatus
-		if err := rows.Scan(&s.ServerID, &s.LastSeen, &s.IPAddress); err != nil {
+		if err := rows.Scan(&s.ServerID, &s.LastSeen, &s.IPAddress, &s.MachineID, &s.DecommissionedAt, &s.Labels); err != nil {
 			return nil, err
 		}
 		servers = append(servers, s)
 	}
-	return servers, nil
+	return servers, rows.Err()
+}
+
+func (s *DBStore) GetServer(ctx context.Context, serverID string) (*ServerDetails, error) {
+	var d ServerDetails
+	var h HostInfo
+	var hostUpdatedAt *time.Time
+	var bootTime *time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT s.server_id, s.last_seen, COALESCE(s.ip_address, ''), s.machine_id, s.decommissioned_at,
+			`+serverLabels+`, s.labels, COALESCE(h.labels, '{}'::jsonb),
+			COALESCE(h.hostname, ''), COALESCE(h.os, ''), COALESCE(h.platform, ''),
+			COALESCE(h.platform_family, ''), COALESCE(h.platform_version, ''),
+			COALESCE(h.kernel_version, ''), COALESCE(h.kernel_arch, ''), COALESCE(h.cpu_model, ''),
+			COALESCE(h.cpu_count, 0), COALESCE(h.memory_total_bytes, 0), h.boot_time,
+			COALESCE(h.agent_version, ''), COALESCE(h.config_hash, ''), COALESCE(h.service_interval_ms, 0),
+			h.updated_at
+		FROM server_status s
+		LEFT JOIN host_info h ON h.server_id = s.server_id
+		WHERE s.server_id = $1
+	`, serverID).Scan(&d.ServerID, &d.LastSeen, &d.IPAddress, &d.MachineID, &d.DecommissionedAt,
+		&d.Labels, &d.AdminLabels, &h.Labels,
+		&h.Hostname, &h.OS, &h.Platform, &h.PlatformFamily, &h.PlatformVersion,
+		&h.KernelVersion, &h.KernelArch, &h.CPUModel, &h.CPUCount, &h.MemoryTotalBytes, &bootTime,
+		&h.AgentVersion, &h.ConfigHash, &h.ServiceIntervalMs, &hostUpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hostUpdatedAt != nil {
+		h.UpdatedAt = *hostUpdatedAt
+		if bootTime != nil {
+			h.BootTime = *bootTime
+		}
+		d.Host = &h
+	}
+	return &d, nil
+}
+
+// SetServerLabels replaces the labels set through the API for a server.
+// They take precedence over the agent's labels with the same key.
+func (s *DBStore) SetServerLabels(ctx context.Context, serverID string, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	tag, err := s.db.Exec(ctx, `UPDATE server_status SET labels = $2 WHERE server_id = $1`, serverID, labelsJSON)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetDecommissioned retires a server or brings it back. A decommissioned
+// server keeps its data; an agent still reporting under its server_id keeps
+// writing to it.
+func (s *DBStore) SetDecommissioned(ctx context.Context, serverID string, decommissioned bool) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE server_status
+		SET decommissioned_at = CASE WHEN $2 THEN COALESCE(decommissioned_at, NOW()) END
+		WHERE server_id = $1
+	`, serverID, decommissioned)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ProgressFunc is told how many rows of a table a long-running operation
+// has processed so far.
+type ProgressFunc func(table string, rows int64)
+
+// serverTables hold per-server data, keyed by server_id. server_status comes
+// last, so a server only disappears once its data is gone.
+var serverTables = []string{"metrics", "events", "host_info", "config_assignments", "agent_config_status", "server_status"}
+
+// deleteBatchSize is how many rows DeleteServer removes per statement, so a
+// large delete doesn't hold one huge transaction.
+const deleteBatchSize = 10000
+
+// DeleteServer removes a server and all its data, in batches.
+func (s *DBStore) DeleteServer(ctx context.Context, serverID string, progress ProgressFunc) error {
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM server_status WHERE server_id = $1)`, serverID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	for _, table := range serverTables {
+		var total int64
+		for {
+			tag, err := s.db.Exec(ctx, `
+				DELETE FROM `+table+`
+				WHERE ctid = ANY(ARRAY(SELECT ctid FROM `+table+` WHERE server_id = $1 LIMIT $2))
+			`, serverID, deleteBatchSize)
+			if err != nil {
+				return fmt.Errorf("deleting from %s: %w", table, err)
+			}
+			total += tag.RowsAffected()
+			progress(table, total)
+			if tag.RowsAffected() < deleteBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// RenameServer moves everything recorded under oldID to newID in one
+// transaction. If newID already exists, for example because its agent
+// already reports under the new name, the histories are merged and newID
+// keeps its current status.
+func (s *DBStore) RenameServer(ctx context.Context, oldID, newID string, progress ProgressFunc) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM server_status WHERE server_id = $1)`, oldID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	for _, table := range serverTables {
+		var moved string
+		switch table {
+		case "metrics":
+			moved = `
+				UPDATE metrics m SET server_id = $2
+				WHERE m.server_id = $1 AND NOT EXISTS (
+					SELECT 1 FROM metrics n
+					WHERE n.server_id = $2 AND n.metric_type = m.metric_type
+						AND n.resource = m.resource AND n.time = m.time
+				)`
+		case "events":
+			moved = `UPDATE events SET server_id = $2 WHERE server_id = $1`
+		default:
+			// One row per server: the new server's row wins.
+			moved = `
+				UPDATE ` + table + ` SET server_id = $2
+				WHERE server_id = $1 AND NOT EXISTS (SELECT 1 FROM ` + table + ` WHERE server_id = $2)`
+		}
+		tag, err := tx.Exec(ctx, moved, oldID, newID)
+		if err != nil {
+			return fmt.Errorf("renaming in %s: %w", table, err)
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE server_id = $1`, oldID); err != nil {
+			return fmt.Errorf("renaming in %s: %w", table, err)
+		}
+		progress(table, tag.RowsAffected())
+	}
+	return tx.Commit(ctx)
 }
 
 func (s *DBStore) GetMetrics(ctx context.Context, serverID string) ([]Metric, error) {
@@ -173,30 +997,992 @@ func (s *DBStore) GetMetrics(ctx context.Context, serverID string) ([]Metric, er
 	return metrics, nil
 }
 
-func (s *DBStore) GetServiceStatus(ctx context.Context, serverID string) ([]ServiceStatus, error) {
+// ListMetrics returns the newest metrics across the servers that match q,
+// newest first. Decommissioned servers are left out.
+func (s *DBStore) ListMetrics(ctx context.Context, q MetricQuery) ([]Metric, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := append([]string{"s.decommissioned_at IS NULL"}, q.Selector.where(serverLabels, arg)...)
+	if q.MetricType != "" {
+		where = append(where, "m.metric_type = "+arg(q.MetricType))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultMetricLimit
+	}
+	limit = min(limit, MaxMetricLimit)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT m.time, m.server_id, m.metric_type, m.resource, m.value, m.tags
+		FROM metrics m
+		JOIN server_status s ON s.server_id = m.server_id
+		LEFT JOIN host_info h ON h.server_id = m.server_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY m.time DESC
+		LIMIT `+arg(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []Metric
+	for rows.Next() {
+		var m Metric
+		if err := rows.Scan(&m.Time, &m.ServerID, &m.MetricType, &m.Resource, &m.Value, &m.Tags); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// LatestMetrics returns the latest value of every series of every server
+// reported since since.
+func (s *DBStore) LatestMetrics(ctx context.Context, since time.Time) ([]Metric, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT DISTINCT ON (resource) 
-			resource as service_name,
-			value as status,
-			time as last_seen
+		SELECT DISTINCT ON (server_id, metric_type, resource)
+			time, server_id, metric_type, resource, value, tags
 		FROM metrics
-		WHERE server_id = $1 
-			AND metric_type = 'service_status'
-			AND resource != ''
-		ORDER BY resource, time DESC
-	`, serverID)
+		WHERE time >= $1
+		ORDER BY server_id, metric_type, resource, time DESC
+	`, since)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var services []ServiceStatus
+	var metrics []Metric
 	for rows.Next() {
-		var s ServiceStatus
-		if err := rows.Scan(&s.ServiceName, &s.Status, &s.LastSeen); err != nil {
+		var m Metric
+		if err := rows.Scan(&m.Time, &m.ServerID, &m.MetricType, &m.Resource, &m.Value, &m.Tags); err != nil {
 			return nil, err
 		}
-		services = append(services, s)
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// QueryMetrics runs a FleetQuery. Series are sorted by their labels, and
+// their points by time. Decommissioned servers are left out.
+func (s *DBStore) QueryMetrics(ctx context.Context, q FleetQuery) ([]Series, error) {
+	agg, ok := aggregations[q.Agg]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation %q", q.Agg)
+	}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{
+		"s.decommissioned_at IS NULL",
+		"m.metric_type = " + arg(q.Metric),
+		"m.time >= " + arg(q.From),
+		"m.time < " + arg(q.To),
+	}
+	where = append(where, q.Selector.where(serverLabels, arg)...)
+
+	var groups []string
+	for _, field := range q.GroupBy {
+		switch field {
+		case "server_id", "resource":
+			groups = append(groups, "m."+field)
+		default:
+			groups = append(groups, "COALESCE(("+serverLabels+")->>"+arg(field)+", '')")
+		}
+	}
+	// Groups are referred to by position, as their label keys are arguments.
+	var positions []string
+	for i := range groups {
+		positions = append(positions, strconv.Itoa(i+1))
+	}
+	timeCol := "MAX(m.time)"
+	if q.Step > 0 {
+		timeCol = "date_bin(" + arg(q.Step.Milliseconds()) + "::bigint * interval '1 millisecond', m.time, " + arg(q.From) + ")"
+		positions = append(positions, strconv.Itoa(len(groups)+1))
+	}
+	groupBy, orderBy := "", ""
+	if len(positions) > 0 {
+		groupBy = "GROUP BY " + strings.Join(positions, ", ")
+		orderBy = "ORDER BY " + strings.Join(positions, ", ")
+	}
+
+	// HAVING drops the empty row an aggregate without GROUP BY returns when
+	// nothing matches.
+	rows, err := s.db.Query(ctx, `
+		SELECT `+strings.Join(append(groups, timeCol, agg), ", ")+`
+		FROM metrics m
+		JOIN server_status s ON s.server_id = m.server_id
+		LEFT JOIN host_info h ON h.server_id = m.server_id
+		WHERE `+strings.Join(where, " AND ")+`
+		`+groupBy+`
+		HAVING COUNT(*) > 0
+		`+orderBy+`
+		LIMIT `+arg(MaxQueryPoints+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []Series{}
+	values := make([]string, len(groups))
+	dest := make([]any, 0, len(groups)+2)
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	var p Point
+	dest = append(dest, &p.Time, &p.Value)
+	var n int
+	var lastKey string
+	for rows.Next() {
+		if n++; n > MaxQueryPoints {
+			return nil, ErrTooManyPoints
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		key := strings.Join(values, "\x00")
+		if len(series) == 0 || key != lastKey {
+			labels := make(map[string]string, len(values))
+			for i, field := range q.GroupBy {
+				labels[field] = values[i]
+			}
+			series = append(series, Series{Labels: labels})
+			lastKey = key
+		}
+		last := &series[len(series)-1]
+		last.Points = append(last.Points, p)
+	}
+	return series, rows.Err()
+}
+
+// ReadSeries returns the points of every series that q may match, with
+// points sorted by time. A series is a server, resource and set of tags, so
+// a tag that changes over time, like the state of service_state, starts a
+// new series instead of being labelled with its first value. Decommissioned
+// servers are left out.
+func (s *DBStore) ReadSeries(ctx context.Context, q SeriesQuery) ([]Series, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{
+		"s.decommissioned_at IS NULL",
+		"m.metric_type = " + arg(q.Metric),
+		"m.time > " + arg(q.From),
+		"m.time <= " + arg(q.To),
+	}
+	for _, k := range slices.Sorted(maps.Keys(q.Equal)) {
+		if k == "server_id" {
+			where = append(where, "m.server_id = "+arg(q.Equal[k]))
+			continue
+		}
+		key, value := arg(k), arg(q.Equal[k])
+		where = append(where, "(m.tags->>"+key+" = "+value+" OR ("+serverLabels+")->>"+key+" = "+value+")")
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT m.server_id, m.resource, `+serverLabels+`, COALESCE(m.tags, '{}'::jsonb), m.time, m.value
+		FROM metrics m
+		JOIN server_status s ON s.server_id = m.server_id
+		LEFT JOIN host_info h ON h.server_id = m.server_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY m.server_id, m.resource, m.time
+		LIMIT `+arg(MaxQueryPoints+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	b := newSeriesBuilder()
+	var serverID, resource string
+	var serverLabelsJSON, tagsJSON []byte
+	var p Point
+	var n int
+	for rows.Next() {
+		if n++; n > MaxQueryPoints {
+			return nil, ErrTooManyPoints
+		}
+		if err := rows.Scan(&serverID, &resource, &serverLabelsJSON, &tagsJSON, &p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		if err := b.add(serverID, resource, serverLabelsJSON, tagsJSON, p); err != nil {
+			return nil, err
+		}
+	}
+	return b.series, rows.Err()
+}
+
+// seriesBuilder groups the rows of ReadSeries, in time order per server and
+// resource, into series by their server, resource and tags.
+type seriesBuilder struct {
+	series []Series
+	index  map[string]int
+}
+
+func newSeriesBuilder() *seriesBuilder {
+	return &seriesBuilder{series: []Series{}, index: map[string]int{}}
+}
+
+// add appends p to its series. tagsJSON must come from jsonb, which orders
+// keys, so equal tags have equal JSON.
+func (b *seriesBuilder) add(serverID, resource string, serverLabelsJSON, tagsJSON []byte, p Point) error {
+	key := serverID + "\x00" + resource + "\x00" + string(tagsJSON)
+	i, ok := b.index[key]
+	if !ok {
+		// Labels are only decoded once per series.
+		labels := map[string]string{}
+		if err := json.Unmarshal(serverLabelsJSON, &labels); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(tagsJSON, &labels); err != nil {
+			return err
+		}
+		labels["server_id"] = serverID
+		i = len(b.series)
+		b.index[key] = i
+		b.series = append(b.series, Series{Labels: labels})
+	}
+	b.series[i].Points = append(b.series[i].Points, p)
+	return nil
+}
+
+func (s *DBStore) GetServiceStatus(ctx context.Context, serverID string) ([]ServiceStatus, error) {
+	staleAfter, err := s.serviceStaleAfter(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// For each service take the latest report, then find when it last changed:
+	// the first report after the most recent one with a different value. Only
+	// the last ServiceHistoryWindow is read, so a long retention doesn't make
+	// this slower.
+	rows, err := s.db.Query(ctx, `
+		WITH latest AS (
+			SELECT DISTINCT ON (resource) resource, value, time
+			FROM metrics
+			WHERE server_id = $1
+				AND metric_type = 'service_status'
+				AND resource != ''
+				AND time > $2
+			ORDER BY resource, time DESC
+		),
+		previous AS (
+			SELECT m.resource, MAX(m.time) AS time
+			FROM metrics m
+			JOIN latest l ON l.resource = m.resource
+			WHERE m.server_id = $1
+				AND m.metric_type = 'service_status'
+				AND m.time > $2
+				AND m.value <> l.value
+			GROUP BY m.resource
+		)
+		SELECT
+			l.resource AS service_name,
+			l.value AS status,
+			l.time AS last_seen,
+			(
+				SELECT MIN(m.time)
+				FROM metrics m
+				WHERE m.server_id = $1
+					AND m.metric_type = 'service_status'
+					AND m.resource = l.resource
+					AND m.time > COALESCE(p.time, $2)
+			) AS last_change
+		FROM latest l
+		LEFT JOIN previous p ON p.resource = l.resource
+		ORDER BY l.resource
+	`, serverID, time.Now().Add(-ServiceHistoryWindow))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var services []ServiceStatus
+	for rows.Next() {
+		var s ServiceStatus
+		if err := rows.Scan(&s.ServiceName, &s.Status, &s.LastSeen, &s.LastChange); err != nil {
+			return nil, err
+		}
+		s.State = serviceState(s, now, staleAfter)
+		services = append(services, s)
+	}
+	return services, rows.Err()
+}
+
+// serviceStaleAfter is how long the services of a server may go unreported:
+// a few of the intervals its agent reports them at, but no less than
+// ServiceStaleAfter.
+func (s *DBStore) serviceStaleAfter(ctx context.Context, serverID string) (time.Duration, error) {
+	var intervalMs int64
+	err := s.db.QueryRow(ctx, `SELECT service_interval_ms FROM host_info WHERE server_id = $1`, serverID).Scan(&intervalMs)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	return max(s.ServiceStaleAfter, DefaultServiceStaleIntervals*time.Duration(intervalMs)*time.Millisecond), nil
+}
+
+// serviceState turns the latest service_status report into up/down/unknown.
+func serviceState(svc ServiceStatus, now time.Time, staleAfter time.Duration) string {
+	if staleAfter > 0 && now.Sub(svc.LastSeen) > staleAfter {
+		return ServiceUnknown
+	}
+	if svc.Status > 0 {
+		return ServiceUp
+	}
+	return ServiceDown
+}
+
+func (s *DBStore) GetEvents(ctx context.Context, serverID string, q EventQuery) ([]Event, error) {
+	where := []string{"server_id = $1"}
+	args := []any{serverID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !q.From.IsZero() {
+		where = append(where, "time >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "time <= "+arg(q.To))
+	}
+	if len(q.Severities) > 0 {
+		levels := make([]int, 0, len(q.Severities))
+		for _, name := range q.Severities {
+			level, ok := SeverityLevel(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown severity %q", name)
+			}
+			levels = append(levels, level)
+		}
+		where = append(where, "severity = ANY("+arg(levels)+")")
+	}
+	if q.MinSeverity != "" {
+		level, ok := SeverityLevel(q.MinSeverity)
+		if !ok {
+			return nil, fmt.Errorf("unknown severity %q", q.MinSeverity)
+		}
+		where = append(where, "severity >= "+arg(level))
+	}
+	if q.Text != "" {
+		where = append(where, "search @@ websearch_to_tsquery('simple', "+arg(q.Text)+")")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultEventLimit
+	}
+	limit = min(limit, MaxEventLimit)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, time, server_id, severity, source, message, COALESCE(attributes, '{}'::jsonb)
+		FROM events
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY time DESC, id DESC
+		LIMIT `+arg(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var severity int16
+		if err := rows.Scan(&e.ID, &e.Time, &e.ServerID, &severity, &e.Source, &e.Message, &e.Attributes); err != nil {
+			return nil, err
+		}
+		e.Severity = severityName(int(severity))
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *DBStore) ListConfigProfiles(ctx context.Context) ([]ConfigProfile, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT DISTINCT ON (p.name) p.name, p.version, p.description, p.config, p.created_at,
+			COALESCE(sel.selector, ''), COALESCE(sel.priority, 0)
+		FROM config_profiles p
+		LEFT JOIN config_selectors sel ON sel.profile = p.name
+		ORDER BY p.name, p.version DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []ConfigProfile{}
+	for rows.Next() {
+		var p ConfigProfile
+		if err := rows.Scan(&p.Name, &p.Version, &p.Description, &p.Config, &p.CreatedAt, &p.Selector, &p.Priority); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// GetConfigProfile returns one version of a profile, or the latest when
+// version is 0.
+func (s *DBStore) GetConfigProfile(ctx context.Context, name string, version int64) (*ConfigProfile, error) {
+	var p ConfigProfile
+	err := s.db.QueryRow(ctx, `
+		SELECT p.name, p.version, p.description, p.config, p.created_at,
+			COALESCE(sel.selector, ''), COALESCE(sel.priority, 0)
+		FROM config_profiles p
+		LEFT JOIN config_selectors sel ON sel.profile = p.name
+		WHERE p.name = $1 AND ($2 = 0 OR p.version = $2)
+		ORDER BY p.version DESC
+		LIMIT 1
+	`, name, version).Scan(&p.Name, &p.Version, &p.Description, &p.Config, &p.CreatedAt, &p.Selector, &p.Priority)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// lockProfile serializes writes to the named profile until tx ends. It is an
+// advisory lock rather than a row lock, as a new profile has no rows yet.
+func lockProfile(ctx context.Context, tx pgx.Tx, name string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('config_profiles'), hashtext($1))`, name)
+	return err
+}
+
+// SaveConfigProfile stores config as the next version of the named profile,
+// creating the profile if needed. Concurrent saves of a profile get
+// consecutive versions.
+func (s *DBStore) SaveConfigProfile(ctx context.Context, name, description string, config json.RawMessage) (*ConfigProfile, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockProfile(ctx, tx, name); err != nil {
+		return nil, err
+	}
+
+	p := ConfigProfile{Name: name, Description: description, Config: config}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO config_profiles (name, version, description, config, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, NOW()
+		FROM config_profiles
+		WHERE name = $1
+		RETURNING version, created_at
+	`, name, description, config).Scan(&p.Version, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeleteConfigProfile removes every version of a profile. Profiles that are
+// still assigned to servers, by server_id or by selector, can't be deleted.
+func (s *DBStore) DeleteConfigProfile(ctx context.Context, name string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockProfile(ctx, tx, name); err != nil {
+		return err
+	}
+
+	var assigned bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM config_assignments WHERE profile = $1)
+			OR EXISTS (SELECT 1 FROM config_selectors WHERE profile = $1)
+	`, name).Scan(&assigned); err != nil {
+		return err
+	}
+	if assigned {
+		return ErrProfileInUse
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM config_profiles WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+// AssignConfigProfile sets the profile a server runs. An empty profile
+// removes the assignment, so the server falls back to DefaultProfile.
+func (s *DBStore) AssignConfigProfile(ctx context.Context, serverID, profile string) error {
+	if profile == "" {
+		_, err := s.db.Exec(ctx, `DELETE FROM config_assignments WHERE server_id = $1`, serverID)
+		return err
+	}
+	tag, err := s.db.Exec(ctx, `
+		INSERT INTO config_assignments (server_id, profile)
+		SELECT $1, $2
+		WHERE EXISTS (SELECT 1 FROM config_profiles WHERE name = $2)
+		ON CONFLICT (server_id) DO UPDATE SET profile = EXCLUDED.profile
+	`, serverID, profile)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetProfileSelector assigns a profile to the servers whose labels match
+// selector. An empty selector removes it.
+func (s *DBStore) SetProfileSelector(ctx context.Context, profile string, selector Selector, priority int) error {
+	if len(selector) == 0 {
+		_, err := s.db.Exec(ctx, `DELETE FROM config_selectors WHERE profile = $1`, profile)
+		return err
+	}
+	tag, err := s.db.Exec(ctx, `
+		INSERT INTO config_selectors (profile, selector, priority)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM config_profiles WHERE name = $1)
+		ON CONFLICT (profile) DO UPDATE SET selector = EXCLUDED.selector, priority = EXCLUDED.priority
+	`, profile, selector.String(), priority)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type profileSelector struct {
+	profile  string
+	selector Selector
+}
+
+// profileSelectors loads the selectors of all profiles, best first.
+func (s *DBStore) profileSelectors(ctx context.Context) ([]profileSelector, error) {
+	rows, err := s.db.Query(ctx, `SELECT profile, selector FROM config_selectors ORDER BY priority DESC, profile`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var selectors []profileSelector
+	for rows.Next() {
+		var ps profileSelector
+		var sel string
+		if err := rows.Scan(&ps.profile, &sel); err != nil {
+			return nil, err
+		}
+		if ps.selector, err = ParseSelector(sel); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", ps.profile, err)
+		}
+		selectors = append(selectors, ps)
+	}
+	return selectors, rows.Err()
+}
+
+// profileFor picks the profile a server runs: the one assigned to it, the
+// first profile whose selector matches its labels, or DefaultProfile.
+func profileFor(assigned string, labels map[string]string, selectors []profileSelector) string {
+	if assigned != "" {
+		return assigned
+	}
+	for _, ps := range selectors {
+		if ps.selector.Matches(labels) {
+			return ps.profile
+		}
+	}
+	return DefaultProfile
+}
+
+// GetEffectiveConfig returns the latest version of the profile a server
+// should run, as chosen by profileFor. ErrNotFound means no profile applies.
+func (s *DBStore) GetEffectiveConfig(ctx context.Context, serverID string) (*ConfigProfile, error) {
+	var assigned *string
+	var labels map[string]string
+	err := s.db.QueryRow(ctx, `
+		SELECT (SELECT profile FROM config_assignments WHERE server_id = $1),
+			(SELECT `+serverLabels+`
+			 FROM server_status s
+			 LEFT JOIN host_info h ON h.server_id = s.server_id
+			 WHERE s.server_id = $1)
+	`, serverID).Scan(&assigned, &labels)
+	if err != nil {
+		return nil, err
+	}
+	selectors, err := s.profileSelectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	if assigned != nil {
+		name = *assigned
+	}
+	return s.GetConfigProfile(ctx, profileFor(name, labels, selectors), 0)
+}
+
+func (s *DBStore) SaveConfigStatus(ctx context.Context, status *proto.ConfigStatus) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO agent_config_status (server_id, profile, version, applied, error, config_hash, reported_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (server_id) DO UPDATE SET
+			profile = EXCLUDED.profile,
+			version = EXCLUDED.version,
+			applied = EXCLUDED.applied,
+			error = EXCLUDED.error,
+			config_hash = EXCLUDED.config_hash,
+			reported_at = EXCLUDED.reported_at
+	`, status.ServerId, status.Profile, status.Version, status.Applied, status.Error, status.ConfigHash)
+	return err
+}
+
+// ListConfigStatus returns the config status of every known server.
+func (s *DBStore) ListConfigStatus(ctx context.Context) ([]AgentConfigStatus, error) {
+	return s.queryConfigStatus(ctx, "")
+}
+
+func (s *DBStore) GetConfigStatus(ctx context.Context, serverID string) (*AgentConfigStatus, error) {
+	statuses, err := s.queryConfigStatus(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, ErrNotFound
+	}
+	return &statuses[0], nil
+}
+
+// queryConfigStatus lists the config status of one server, or of all servers
+// still in service when serverID is empty.
+func (s *DBStore) queryConfigStatus(ctx context.Context, serverID string) ([]AgentConfigStatus, error) {
+	latest := map[string]int64{}
+	rows, err := s.db.Query(ctx, `SELECT name, MAX(version) FROM config_profiles GROUP BY name`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		var version int64
+		if err := rows.Scan(&name, &version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		latest[name] = version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	selectors, err := s.profileSelectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT s.server_id, COALESCE(a.profile, ''), `+serverLabels+`,
+			COALESCE(st.profile, ''), COALESCE(st.version, 0), COALESCE(st.applied, false),
+			COALESCE(st.error, ''), COALESCE(st.config_hash, ''), st.reported_at
+		FROM server_status s
+		LEFT JOIN host_info h ON h.server_id = s.server_id
+		LEFT JOIN config_assignments a ON a.server_id = s.server_id
+		LEFT JOIN agent_config_status st ON st.server_id = s.server_id
+		WHERE ($1 = '' AND s.decommissioned_at IS NULL) OR s.server_id = $1
+		ORDER BY s.server_id
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []AgentConfigStatus{}
+	for rows.Next() {
+		var st AgentConfigStatus
+		var assigned string
+		var labels map[string]string
+		var applied bool
+		if err := rows.Scan(&st.ServerID, &assigned, &labels,
+			&st.AppliedProfile, &st.AppliedVersion, &applied,
+			&st.Error, &st.ConfigHash, &st.ReportedAt); err != nil {
+			return nil, err
+		}
+		if profile := profileFor(assigned, labels, selectors); latest[profile] > 0 {
+			st.Profile, st.Version = profile, latest[profile]
+		}
+		if st.ReportedAt == nil {
+			// Agents that never reported only count as out of date when
+			// there is something for them to apply.
+			st.UpToDate = st.Profile == ""
+		} else {
+			st.UpToDate = applied && st.AppliedProfile == st.Profile && st.AppliedVersion == st.Version
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, rows.Err()
+}
+
+func (s *DBStore) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&n)
+	return n, err
+}
+
+func (s *DBStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.Query(ctx, `SELECT username, role, created_at FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *DBStore) GetUser(ctx context.Context, username string) (*User, error) {
+	var u User
+	err := s.db.QueryRow(ctx, `
+		SELECT username, role, password_hash, created_at FROM users WHERE username = $1
+	`, username).Scan(&u.Username, &u.Role, &u.PasswordHash, &u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CreateUser adds a user. ErrAlreadyExists means the username is taken.
+func (s *DBStore) CreateUser(ctx context.Context, u User) (*User, error) {
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO users (username, role, password_hash, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (username) DO NOTHING
+		RETURNING created_at
+	`, u.Username, u.Role, u.PasswordHash).Scan(&u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateUser changes a user's role and password hash. Empty values are left
+// unchanged. A new password ends the user's sessions.
+func (s *DBStore) UpdateUser(ctx context.Context, username, role, passwordHash string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE users
+		SET role = COALESCE(NULLIF($2, ''), role),
+			password_hash = COALESCE(NULLIF($3, ''), password_hash)
+		WHERE username = $1
+	`, username, role, passwordHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if passwordHash != "" {
+		if _, err := tx.Exec(ctx, `DELETE FROM sessions WHERE username = $1`, username); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteUser removes a user and, through the foreign key, their sessions.
+func (s *DBStore) DeleteUser(ctx context.Context, username string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM users WHERE username = $1`, username)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateSession stores a login session. Expired sessions are cleared out at
+// the same time.
+func (s *DBStore) CreateSession(ctx context.Context, tokenHash, username string, expiresAt time.Time) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO sessions (token_hash, username, expires_at, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`, tokenHash, username, expiresAt)
+	return err
+}
+
+// GetSessionUser returns the user of an unexpired session.
+func (s *DBStore) GetSessionUser(ctx context.Context, tokenHash string) (*User, error) {
+	var u User
+	err := s.db.QueryRow(ctx, `
+		SELECT u.username, u.role, u.created_at
+		FROM sessions s
+		JOIN users u ON u.username = s.username
+		WHERE s.token_hash = $1 AND s.expires_at > NOW()
+	`, tokenHash).Scan(&u.Username, &u.Role, &u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *DBStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+func (s *DBStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, prefix, role, created_by, created_at, last_used_at
+		FROM api_keys
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Role, &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *DBStore) CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error) {
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO api_keys (name, prefix, key_hash, role, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id, created_at
+	`, key.Name, key.Prefix, keyHash, key.Role, key.CreatedBy).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKey looks a key up by its hash and records that it was used.
+func (s *DBStore) GetAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	var k APIKey
+	err := s.db.QueryRow(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE key_hash = $1
+		RETURNING id, name, prefix, role, created_by, created_at, last_used_at
+	`, keyHash).Scan(&k.ID, &k.Name, &k.Prefix, &k.Role, &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *DBStore) DeleteAPIKey(ctx context.Context, id int64) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM api_keys WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) SaveAuditEntry(ctx context.Context, e AuditEntry) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO audit_log (time, actor, actor_kind, role, ip_address, method, path, route, status, error, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, e.Time, e.Actor, e.ActorKind, e.Role, e.IPAddress, e.Method, e.Path, e.Route, e.Status, e.Error, e.Before, e.After)
+	return err
+}
+
+// GetAuditLog returns audit entries matching q, newest first.
+func (s *DBStore) GetAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	where := []string{"TRUE"}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !q.From.IsZero() {
+		where = append(where, "time >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "time <= "+arg(q.To))
+	}
+	if q.Actor != "" {
+		where = append(where, "actor = "+arg(q.Actor))
+	}
+	if q.Method != "" {
+		where = append(where, "method = "+arg(strings.ToUpper(q.Method)))
+	}
+	if q.Route != "" {
+		where = append(where, "route = "+arg(q.Route))
+	}
+	if q.PathPrefix != "" {
+		where = append(where, "starts_with(path, "+arg(q.PathPrefix)+")")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultEventLimit
+	}
+	limit = min(limit, MaxEventLimit)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, time, actor, actor_kind, role, ip_address, method, path, route, status, error, before, after
+		FROM audit_log
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY time DESC, id DESC
+		LIMIT `+arg(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.ActorKind, &e.Role, &e.IPAddress,
+			&e.Method, &e.Path, &e.Route, &e.Status, &e.Error, &e.Before, &e.After); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
 	}
-	return services, nil
+	return entries, rows.Err()
 }
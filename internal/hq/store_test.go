@@ -0,0 +1,104 @@
+package hq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMetricResource(t *testing.T) {
+	for _, tc := range []struct {
+		tags map[string]string
+		want string
+	}{
+		{nil, ""},
+		{map[string]string{"unit": "percent"}, ""},
+		{map[string]string{"unit": "gb", "path": "/var"}, "/var"},
+		{map[string]string{"service": "nginx"}, "nginx"},
+		{map[string]string{"file": "/var/log/app.log", "pattern": "errors"}, "/var/log/app.log|errors"},
+		{map[string]string{"check": "web", "type": "http"}, "web"},
+		// Service state changes over time; the series stays the same.
+		{map[string]string{"service": "cron", "manager": "systemd", "state": "running", "sub_state": "running", "start_type": "enabled"}, "cron"},
+		// Custom tags keep otherwise identical series apart.
+		{map[string]string{"command": "queue", "queue": "a"}, "queue|queue=a"},
+		{map[string]string{"command": "queue", "queue": "b", "team": "ops"}, "queue|queue=b|team=ops"},
+		{map[string]string{"core": "0"}, "core=0"},
+	} {
+		if got := metricResource(tc.tags); got != tc.want {
+			t.Errorf("metricResource(%v) = %q, want %q", tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestProfileFor(t *testing.T) {
+	mustParse := func(s string) Selector {
+		sel, err := ParseSelector(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sel
+	}
+	// Best first, as profileSelectors loads them.
+	selectors := []profileSelector{
+		{"prod-db", mustParse("env=prod,role=db")},
+		{"prod", mustParse("env=prod")},
+		{"canary", mustParse("canary,!maintenance")},
+	}
+
+	for _, tc := range []struct {
+		assigned string
+		labels   map[string]string
+		want     string
+	}{
+		{"", map[string]string{"env": "prod", "role": "db"}, "prod-db"},
+		{"", map[string]string{"env": "prod", "role": "web"}, "prod"},
+		{"", map[string]string{"canary": "yes"}, "canary"},
+		{"", map[string]string{"canary": "yes", "maintenance": "true"}, DefaultProfile},
+		{"", nil, DefaultProfile},
+		// A server's own assignment beats any selector.
+		{"special", map[string]string{"env": "prod", "role": "db"}, "special"},
+	} {
+		if got := profileFor(tc.assigned, tc.labels, selectors); got != tc.want {
+			t.Errorf("profileFor(%q, %v) = %q, want %q", tc.assigned, tc.labels, got, tc.want)
+		}
+	}
+}
+
+func TestSeriesBuilder(t *testing.T) {
+	b := newSeriesBuilder()
+	t0 := time.Unix(1700000000, 0)
+	// ReadSeries rows: by server and resource, then time.
+	for i, row := range []struct {
+		server, resource, tags string
+	}{
+		{"web-1", "cron", `{"service": "cron", "state": "running"}`},
+		{"web-1", "cron", `{"service": "cron", "state": "failed"}`},
+		{"web-1", "cron", `{"service": "cron", "state": "running"}`},
+		{"web-1", "nginx", `{"service": "nginx", "state": "running"}`},
+		{"web-2", "cron", `{"service": "cron", "state": "running"}`},
+	} {
+		p := Point{Time: t0.Add(time.Duration(i) * time.Minute), Value: float64(i)}
+		if err := b.add(row.server, row.resource, []byte(`{"env": "prod"}`), []byte(row.tags), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, s := range b.series {
+		got = append(got, fmt.Sprintf("%s %s %s %s %v", s.Labels["server_id"], s.Labels["service"], s.Labels["state"], s.Labels["env"], s.Points))
+	}
+	want := []string{
+		fmt.Sprintf("web-1 cron running prod %v", []Point{{t0, 0}, {t0.Add(2 * time.Minute), 2}}),
+		fmt.Sprintf("web-1 cron failed prod %v", []Point{{t0.Add(time.Minute), 1}}),
+		fmt.Sprintf("web-1 nginx running prod %v", []Point{{t0.Add(3 * time.Minute), 3}}),
+		fmt.Sprintf("web-2 cron running prod %v", []Point{{t0.Add(4 * time.Minute), 4}}),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got series %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("series %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package hq
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policies for a second agent streaming under a server_id that is already
+// connected from another host.
+const (
+	// ConflictAllow accepts both streams and flags the server as conflicting.
+	ConflictAllow = "allow"
+	// ConflictReject refuses the newer stream.
+	ConflictReject = "reject"
+)
+
+// conflictReportEvery throttles the conflict events of one server_id, since a
+// rejected agent retries every few seconds.
+const conflictReportEvery = 10 * time.Minute
+
+// StreamInfo describes one open metrics stream.
+type StreamInfo struct {
+	ServerID    string    `json:"server_id"`
+	Peer        string    `json:"peer"`
+	MachineID   string    `json:"machine_id,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	id uint64
+}
+
+// sameHost reports whether two streams come from the same host. Agents that
+// don't send a machine ID are told apart by their IP address.
+func (a StreamInfo) sameHost(b StreamInfo) bool {
+	if a.MachineID != "" && b.MachineID != "" {
+		return a.MachineID == b.MachineID
+	}
+	return peerHost(a.Peer) == peerHost(b.Peer)
+}
+
+func peerHost(peer string) string {
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return peer
+}
+
+// StreamRegistry tracks the open metrics streams per server_id. It is shared
+// by the gRPC server, which registers streams, and the REST server, which
+// reports them.
+type StreamRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	streams  map[string][]StreamInfo
+	reported map[string]time.Time
+}
+
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{
+		streams:  map[string][]StreamInfo{},
+		reported: map[string]time.Time{},
+	}
+}
+
+// Register records a new stream and returns it together with the open
+// streams of the same server_id that come from other hosts. When there are
+// such rivals and allowConflict is false, the stream is not recorded and ok
+// is false.
+func (r *StreamRegistry) Register(info StreamInfo, allowConflict bool) (registered StreamInfo, rivals []StreamInfo, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.streams[info.ServerID] {
+		if !other.sameHost(info) {
+			rivals = append(rivals, other)
+		}
+	}
+	if len(rivals) > 0 && !allowConflict {
+		return info, rivals, false
+	}
+	r.nextID++
+	info.id = r.nextID
+	r.streams[info.ServerID] = append(r.streams[info.ServerID], info)
+	return info, rivals, true
+}
+
+// Unregister forgets a stream returned by Register.
+func (r *StreamRegistry) Unregister(info StreamInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	streams := r.streams[info.ServerID]
+	for i, s := range streams {
+		if s.id == info.id {
+			streams = append(streams[:i], streams[i+1:]...)
+			break
+		}
+	}
+	if len(streams) == 0 {
+		delete(r.streams, info.ServerID)
+	} else {
+		r.streams[info.ServerID] = streams
+	}
+}
+
+// Streams returns the open streams of a server, oldest first.
+func (r *StreamRegistry) Streams(serverID string) []StreamInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	streams := append([]StreamInfo(nil), r.streams[serverID]...)
+	sort.Slice(streams, func(i, j int) bool { return streams[i].ConnectedAt.Before(streams[j].ConnectedAt) })
+	return streams
+}
+
+// Conflicting reports whether a server has open streams from more than one
+// host.
+func (r *StreamRegistry) Conflicting(serverID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	streams := r.streams[serverID]
+	for _, s := range streams[min(1, len(streams)):] {
+		if !s.sameHost(streams[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldReport reports whether a conflict on serverID is due for an event,
+// and if so notes that one is being raised now.
+func (r *StreamRegistry) shouldReport(serverID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.reported[serverID]; ok && time.Since(last) < conflictReportEvery {
+		return false
+	}
+	r.reported[serverID] = time.Now()
+	return true
+}
@@ -0,0 +1,39 @@
+package proto
+
+import "strings"
+
+// RemoteLockedKeys are the agent config keys a ConfigUpdate can't set; the
+// agent only takes them from its local config file. Nested keys are given
+// as dotted paths. HQ refuses profiles that set them, and the agent ignores
+// them in what HQ sends.
+//
+// A bad hq_address, hq_tls or server_id would cut the agent off from HQ or
+// make it report as another server. The exec plugin runs commands, the logs
+// plugin reads any file the agent can and the checks plugin probes any
+// address, so whoever controls HQ's profiles mustn't be able to turn them
+// on. state_dir is where the agent writes its state.
+var RemoteLockedKeys = []string{
+	"hq_address",
+	"hq_tls",
+	"server_id",
+	"state_dir",
+	"plugins.exec",
+	"plugins.logs",
+	"plugins.checks",
+}
+
+// LookupConfigKey returns the value at key, a dotted path like plugins.exec,
+// in obj, a decoded JSON config.
+func LookupConfigKey(obj map[string]any, key string) (any, bool) {
+	for {
+		k, rest, nested := strings.Cut(key, ".")
+		v, ok := obj[k]
+		if !ok || !nested {
+			return v, ok
+		}
+		if obj, ok = v.(map[string]any); !ok {
+			return nil, false
+		}
+		key = rest
+	}
+}
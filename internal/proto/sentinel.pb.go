@@ -22,11 +22,72 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Severity int32
+
+const (
+	Severity_SEVERITY_UNSPECIFIED Severity = 0
+	Severity_SEVERITY_DEBUG       Severity = 1
+	Severity_SEVERITY_INFO        Severity = 2
+	Severity_SEVERITY_WARNING     Severity = 3
+	Severity_SEVERITY_ERROR       Severity = 4
+	Severity_SEVERITY_CRITICAL    Severity = 5
+)
+
+// Enum value maps for Severity.
+var (
+	Severity_name = map[int32]string{
+		0: "SEVERITY_UNSPECIFIED",
+		1: "SEVERITY_DEBUG",
+		2: "SEVERITY_INFO",
+		3: "SEVERITY_WARNING",
+		4: "SEVERITY_ERROR",
+		5: "SEVERITY_CRITICAL",
+	}
+	Severity_value = map[string]int32{
+		"SEVERITY_UNSPECIFIED": 0,
+		"SEVERITY_DEBUG":       1,
+		"SEVERITY_INFO":        2,
+		"SEVERITY_WARNING":     3,
+		"SEVERITY_ERROR":       4,
+		"SEVERITY_CRITICAL":    5,
+	}
+)
+
+func (x Severity) Enum() *Severity {
+	p := new(Severity)
+	*p = x
+	return p
+}
+
+func (x Severity) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Severity) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_proto_sentinel_proto_enumTypes[0].Descriptor()
+}
+
+func (Severity) Type() protoreflect.EnumType {
+	return &file_internal_proto_sentinel_proto_enumTypes[0]
+}
+
+func (x Severity) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Severity.Descriptor instead.
+func (Severity) EnumDescriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{0}
+}
+
 type MetricBatch struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ServerId      string                 `protobuf:"bytes,1,opt,name=server_id,json=serverId,proto3" json:"server_id,omitempty"`
 	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	Metrics       []*Metric              `protobuf:"bytes,3,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	Events        []*Event               `protobuf:"bytes,4,rep,name=events,proto3" json:"events,omitempty"`
+	HostInfo      *HostInfo              `protobuf:"bytes,5,opt,name=host_info,json=hostInfo,proto3" json:"host_info,omitempty"`    // Sent on the first batch of a stream and whenever it changes
+	MachineId     string                 `protobuf:"bytes,6,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"` // Stable ID of the host, to tell apart hosts that claim the same server_id
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -82,6 +143,27 @@ func (x *MetricBatch) GetMetrics() []*Metric {
 	return nil
 }
 
+func (x *MetricBatch) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *MetricBatch) GetHostInfo() *HostInfo {
+	if x != nil {
+		return x.HostInfo
+	}
+	return nil
+}
+
+func (x *MetricBatch) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
 type Metric struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"` // e.g., "cpu_usage", "memory_used", "disk_free", "service_cpu:<name>"
@@ -142,6 +224,426 @@ func (x *Metric) GetTags() map[string]string {
 	return nil
 }
 
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Severity      Severity               `protobuf:"varint,5,opt,name=severity,proto3,enum=sentinel.Severity" json:"severity,omitempty"`
+	Source        string                 `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"` // e.g. "logtail", "services"
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Attributes    map[string]string      `protobuf:"bytes,4,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // e.g. {"file": "/var/log/app.log", "pattern": "error"}
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_internal_proto_sentinel_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_sentinel_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Event) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Event) GetSeverity() Severity {
+	if x != nil {
+		return x.Severity
+	}
+	return Severity_SEVERITY_UNSPECIFIED
+}
+
+func (x *Event) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Event) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+type HostInfo struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Hostname          string                 `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Os                string                 `protobuf:"bytes,2,opt,name=os,proto3" json:"os,omitempty"`             // e.g. "windows", "linux"
+	Platform          string                 `protobuf:"bytes,3,opt,name=platform,proto3" json:"platform,omitempty"` // e.g. "Microsoft Windows Server 2022 Datacenter", "ubuntu"
+	PlatformFamily    string                 `protobuf:"bytes,4,opt,name=platform_family,json=platformFamily,proto3" json:"platform_family,omitempty"`
+	PlatformVersion   string                 `protobuf:"bytes,5,opt,name=platform_version,json=platformVersion,proto3" json:"platform_version,omitempty"`
+	KernelVersion     string                 `protobuf:"bytes,6,opt,name=kernel_version,json=kernelVersion,proto3" json:"kernel_version,omitempty"`
+	KernelArch        string                 `protobuf:"bytes,7,opt,name=kernel_arch,json=kernelArch,proto3" json:"kernel_arch,omitempty"`
+	CpuModel          string                 `protobuf:"bytes,8,opt,name=cpu_model,json=cpuModel,proto3" json:"cpu_model,omitempty"`
+	CpuCount          int32                  `protobuf:"varint,9,opt,name=cpu_count,json=cpuCount,proto3" json:"cpu_count,omitempty"` // logical CPUs
+	MemoryTotalBytes  uint64                 `protobuf:"varint,10,opt,name=memory_total_bytes,json=memoryTotalBytes,proto3" json:"memory_total_bytes,omitempty"`
+	BootTime          *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=boot_time,json=bootTime,proto3" json:"boot_time,omitempty"`
+	AgentVersion      string                 `protobuf:"bytes,12,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	ConfigHash        string                 `protobuf:"bytes,13,opt,name=config_hash,json=configHash,proto3" json:"config_hash,omitempty"`
+	Labels            map[string]string      `protobuf:"bytes,14,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // From the agent config, e.g. {"env": "prod", "role": "db"}
+	ServiceIntervalMs int64                  `protobuf:"varint,15,opt,name=service_interval_ms,json=serviceIntervalMs,proto3" json:"service_interval_ms,omitempty"`                         // How often the services plugin reports; 0 when it is off
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *HostInfo) Reset() {
+	*x = HostInfo{}
+	mi := &file_internal_proto_sentinel_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HostInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HostInfo) ProtoMessage() {}
+
+func (x *HostInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_sentinel_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HostInfo.ProtoReflect.Descriptor instead.
+func (*HostInfo) Descriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *HostInfo) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *HostInfo) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *HostInfo) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+func (x *HostInfo) GetPlatformFamily() string {
+	if x != nil {
+		return x.PlatformFamily
+	}
+	return ""
+}
+
+func (x *HostInfo) GetPlatformVersion() string {
+	if x != nil {
+		return x.PlatformVersion
+	}
+	return ""
+}
+
+func (x *HostInfo) GetKernelVersion() string {
+	if x != nil {
+		return x.KernelVersion
+	}
+	return ""
+}
+
+func (x *HostInfo) GetKernelArch() string {
+	if x != nil {
+		return x.KernelArch
+	}
+	return ""
+}
+
+func (x *HostInfo) GetCpuModel() string {
+	if x != nil {
+		return x.CpuModel
+	}
+	return ""
+}
+
+func (x *HostInfo) GetCpuCount() int32 {
+	if x != nil {
+		return x.CpuCount
+	}
+	return 0
+}
+
+func (x *HostInfo) GetMemoryTotalBytes() uint64 {
+	if x != nil {
+		return x.MemoryTotalBytes
+	}
+	return 0
+}
+
+func (x *HostInfo) GetBootTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.BootTime
+	}
+	return nil
+}
+
+func (x *HostInfo) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *HostInfo) GetConfigHash() string {
+	if x != nil {
+		return x.ConfigHash
+	}
+	return ""
+}
+
+func (x *HostInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *HostInfo) GetServiceIntervalMs() int64 {
+	if x != nil {
+		return x.ServiceIntervalMs
+	}
+	return 0
+}
+
+type ConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServerId      string                 `protobuf:"bytes,1,opt,name=server_id,json=serverId,proto3" json:"server_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigRequest) Reset() {
+	*x = ConfigRequest{}
+	mi := &file_internal_proto_sentinel_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigRequest) ProtoMessage() {}
+
+func (x *ConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_sentinel_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigRequest.ProtoReflect.Descriptor instead.
+func (*ConfigRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ConfigRequest) GetServerId() string {
+	if x != nil {
+		return x.ServerId
+	}
+	return ""
+}
+
+type ConfigUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"` // empty when no profile applies to the agent
+	Version       int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	ConfigJson    []byte                 `protobuf:"bytes,3,opt,name=config_json,json=configJson,proto3" json:"config_json,omitempty"` // merged over the agent's local config file
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigUpdate) Reset() {
+	*x = ConfigUpdate{}
+	mi := &file_internal_proto_sentinel_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigUpdate) ProtoMessage() {}
+
+func (x *ConfigUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_sentinel_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigUpdate.ProtoReflect.Descriptor instead.
+func (*ConfigUpdate) Descriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ConfigUpdate) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *ConfigUpdate) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ConfigUpdate) GetConfigJson() []byte {
+	if x != nil {
+		return x.ConfigJson
+	}
+	return nil
+}
+
+type ConfigStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServerId      string                 `protobuf:"bytes,1,opt,name=server_id,json=serverId,proto3" json:"server_id,omitempty"`
+	Profile       string                 `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	Version       int64                  `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	Applied       bool                   `protobuf:"varint,4,opt,name=applied,proto3" json:"applied,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`                             // why the version was rejected, when applied is false
+	ConfigHash    string                 `protobuf:"bytes,6,opt,name=config_hash,json=configHash,proto3" json:"config_hash,omitempty"` // hash of the effective config after applying
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigStatus) Reset() {
+	*x = ConfigStatus{}
+	mi := &file_internal_proto_sentinel_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigStatus) ProtoMessage() {}
+
+func (x *ConfigStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_sentinel_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigStatus.ProtoReflect.Descriptor instead.
+func (*ConfigStatus) Descriptor() ([]byte, []int) {
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ConfigStatus) GetServerId() string {
+	if x != nil {
+		return x.ServerId
+	}
+	return ""
+}
+
+func (x *ConfigStatus) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *ConfigStatus) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ConfigStatus) GetApplied() bool {
+	if x != nil {
+		return x.Applied
+	}
+	return false
+}
+
+func (x *ConfigStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ConfigStatus) GetConfigHash() string {
+	if x != nil {
+		return x.ConfigHash
+	}
+	return ""
+}
+
 type Ack struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -152,7 +654,7 @@ type Ack struct {
 
 func (x *Ack) Reset() {
 	*x = Ack{}
-	mi := &file_internal_proto_sentinel_proto_msgTypes[2]
+	mi := &file_internal_proto_sentinel_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -164,7 +666,7 @@ func (x *Ack) String() string {
 func (*Ack) ProtoMessage() {}
 
 func (x *Ack) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_sentinel_proto_msgTypes[2]
+	mi := &file_internal_proto_sentinel_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -177,7 +679,7 @@ func (x *Ack) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Ack.ProtoReflect.Descriptor instead.
 func (*Ack) Descriptor() ([]byte, []int) {
-	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{2}
+	return file_internal_proto_sentinel_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Ack) GetSuccess() bool {
@@ -198,23 +700,84 @@ var File_internal_proto_sentinel_proto protoreflect.FileDescriptor
 
 const file_internal_proto_sentinel_proto_rawDesc = "" +
 	"\n" +
-	"\x1dinternal/proto/sentinel.proto\x12\bsentinel\x1a\x1fgoogle/protobuf/timestamp.proto\"\x90\x01\n" +
+	"\x1dinternal/proto/sentinel.proto\x12\bsentinel\x1a\x1fgoogle/protobuf/timestamp.proto\"\x89\x02\n" +
 	"\vMetricBatch\x12\x1b\n" +
 	"\tserver_id\x18\x01 \x01(\tR\bserverId\x128\n" +
 	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12*\n" +
-	"\ametrics\x18\x03 \x03(\v2\x10.sentinel.MetricR\ametrics\"\x9b\x01\n" +
+	"\ametrics\x18\x03 \x03(\v2\x10.sentinel.MetricR\ametrics\x12'\n" +
+	"\x06events\x18\x04 \x03(\v2\x0f.sentinel.EventR\x06events\x12/\n" +
+	"\thost_info\x18\x05 \x01(\v2\x12.sentinel.HostInfoR\bhostInfo\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x06 \x01(\tR\tmachineId\"\x9b\x01\n" +
 	"\x06Metric\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\x01R\x05value\x12.\n" +
 	"\x04tags\x18\x03 \x03(\v2\x1a.sentinel.Metric.TagsEntryR\x04tags\x1a7\n" +
 	"\tTagsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"9\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa3\x02\n" +
+	"\x05Event\x128\n" +
+	"\ttimestamp\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12.\n" +
+	"\bseverity\x18\x05 \x01(\x0e2\x12.sentinel.SeverityR\bseverity\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12?\n" +
+	"\n" +
+	"attributes\x18\x04 \x03(\v2\x1f.sentinel.Event.AttributesEntryR\n" +
+	"attributes\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xf8\x04\n" +
+	"\bHostInfo\x12\x1a\n" +
+	"\bhostname\x18\x01 \x01(\tR\bhostname\x12\x0e\n" +
+	"\x02os\x18\x02 \x01(\tR\x02os\x12\x1a\n" +
+	"\bplatform\x18\x03 \x01(\tR\bplatform\x12'\n" +
+	"\x0fplatform_family\x18\x04 \x01(\tR\x0eplatformFamily\x12)\n" +
+	"\x10platform_version\x18\x05 \x01(\tR\x0fplatformVersion\x12%\n" +
+	"\x0ekernel_version\x18\x06 \x01(\tR\rkernelVersion\x12\x1f\n" +
+	"\vkernel_arch\x18\a \x01(\tR\n" +
+	"kernelArch\x12\x1b\n" +
+	"\tcpu_model\x18\b \x01(\tR\bcpuModel\x12\x1b\n" +
+	"\tcpu_count\x18\t \x01(\x05R\bcpuCount\x12,\n" +
+	"\x12memory_total_bytes\x18\n" +
+	" \x01(\x04R\x10memoryTotalBytes\x127\n" +
+	"\tboot_time\x18\v \x01(\v2\x1a.google.protobuf.TimestampR\bbootTime\x12#\n" +
+	"\ragent_version\x18\f \x01(\tR\fagentVersion\x12\x1f\n" +
+	"\vconfig_hash\x18\r \x01(\tR\n" +
+	"configHash\x126\n" +
+	"\x06labels\x18\x0e \x03(\v2\x1e.sentinel.HostInfo.LabelsEntryR\x06labels\x12.\n" +
+	"\x13service_interval_ms\x18\x0f \x01(\x03R\x11serviceIntervalMs\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\",\n" +
+	"\rConfigRequest\x12\x1b\n" +
+	"\tserver_id\x18\x01 \x01(\tR\bserverId\"c\n" +
+	"\fConfigUpdate\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12\x1f\n" +
+	"\vconfig_json\x18\x03 \x01(\fR\n" +
+	"configJson\"\xb0\x01\n" +
+	"\fConfigStatus\x12\x1b\n" +
+	"\tserver_id\x18\x01 \x01(\tR\bserverId\x12\x18\n" +
+	"\aprofile\x18\x02 \x01(\tR\aprofile\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x03R\aversion\x12\x18\n" +
+	"\aapplied\x18\x04 \x01(\bR\aapplied\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x1f\n" +
+	"\vconfig_hash\x18\x06 \x01(\tR\n" +
+	"configHash\"9\n" +
 	"\x03Ack\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2C\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage*\x8c\x01\n" +
+	"\bSeverity\x12\x18\n" +
+	"\x14SEVERITY_UNSPECIFIED\x10\x00\x12\x12\n" +
+	"\x0eSEVERITY_DEBUG\x10\x01\x12\x11\n" +
+	"\rSEVERITY_INFO\x10\x02\x12\x14\n" +
+	"\x10SEVERITY_WARNING\x10\x03\x12\x12\n" +
+	"\x0eSEVERITY_ERROR\x10\x04\x12\x15\n" +
+	"\x11SEVERITY_CRITICAL\x10\x052\xc2\x01\n" +
 	"\bSentinel\x127\n" +
-	"\rStreamMetrics\x12\x15.sentinel.MetricBatch\x1a\r.sentinel.Ack(\x01B\x19Z\x17sentinel/internal/protob\x06proto3"
+	"\rStreamMetrics\x12\x15.sentinel.MetricBatch\x1a\r.sentinel.Ack(\x01\x12@\n" +
+	"\vWatchConfig\x12\x17.sentinel.ConfigRequest\x1a\x16.sentinel.ConfigUpdate0\x01\x12;\n" +
+	"\x12ReportConfigStatus\x12\x16.sentinel.ConfigStatus\x1a\r.sentinel.AckB\x19Z\x17sentinel/internal/protob\x06proto3"
 
 var (
 	file_internal_proto_sentinel_proto_rawDescOnce sync.Once
@@ -228,25 +791,45 @@ func file_internal_proto_sentinel_proto_rawDescGZIP() []byte {
 	return file_internal_proto_sentinel_proto_rawDescData
 }
 
-var file_internal_proto_sentinel_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_internal_proto_sentinel_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_internal_proto_sentinel_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_internal_proto_sentinel_proto_goTypes = []any{
-	(*MetricBatch)(nil),           // 0: sentinel.MetricBatch
-	(*Metric)(nil),                // 1: sentinel.Metric
-	(*Ack)(nil),                   // 2: sentinel.Ack
-	nil,                           // 3: sentinel.Metric.TagsEntry
-	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+	(Severity)(0),                 // 0: sentinel.Severity
+	(*MetricBatch)(nil),           // 1: sentinel.MetricBatch
+	(*Metric)(nil),                // 2: sentinel.Metric
+	(*Event)(nil),                 // 3: sentinel.Event
+	(*HostInfo)(nil),              // 4: sentinel.HostInfo
+	(*ConfigRequest)(nil),         // 5: sentinel.ConfigRequest
+	(*ConfigUpdate)(nil),          // 6: sentinel.ConfigUpdate
+	(*ConfigStatus)(nil),          // 7: sentinel.ConfigStatus
+	(*Ack)(nil),                   // 8: sentinel.Ack
+	nil,                           // 9: sentinel.Metric.TagsEntry
+	nil,                           // 10: sentinel.Event.AttributesEntry
+	nil,                           // 11: sentinel.HostInfo.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
 }
 var file_internal_proto_sentinel_proto_depIdxs = []int32{
-	4, // 0: sentinel.MetricBatch.timestamp:type_name -> google.protobuf.Timestamp
-	1, // 1: sentinel.MetricBatch.metrics:type_name -> sentinel.Metric
-	3, // 2: sentinel.Metric.tags:type_name -> sentinel.Metric.TagsEntry
-	0, // 3: sentinel.Sentinel.StreamMetrics:input_type -> sentinel.MetricBatch
-	2, // 4: sentinel.Sentinel.StreamMetrics:output_type -> sentinel.Ack
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	12, // 0: sentinel.MetricBatch.timestamp:type_name -> google.protobuf.Timestamp
+	2,  // 1: sentinel.MetricBatch.metrics:type_name -> sentinel.Metric
+	3,  // 2: sentinel.MetricBatch.events:type_name -> sentinel.Event
+	4,  // 3: sentinel.MetricBatch.host_info:type_name -> sentinel.HostInfo
+	9,  // 4: sentinel.Metric.tags:type_name -> sentinel.Metric.TagsEntry
+	12, // 5: sentinel.Event.timestamp:type_name -> google.protobuf.Timestamp
+	0,  // 6: sentinel.Event.severity:type_name -> sentinel.Severity
+	10, // 7: sentinel.Event.attributes:type_name -> sentinel.Event.AttributesEntry
+	12, // 8: sentinel.HostInfo.boot_time:type_name -> google.protobuf.Timestamp
+	11, // 9: sentinel.HostInfo.labels:type_name -> sentinel.HostInfo.LabelsEntry
+	1,  // 10: sentinel.Sentinel.StreamMetrics:input_type -> sentinel.MetricBatch
+	5,  // 11: sentinel.Sentinel.WatchConfig:input_type -> sentinel.ConfigRequest
+	7,  // 12: sentinel.Sentinel.ReportConfigStatus:input_type -> sentinel.ConfigStatus
+	8,  // 13: sentinel.Sentinel.StreamMetrics:output_type -> sentinel.Ack
+	6,  // 14: sentinel.Sentinel.WatchConfig:output_type -> sentinel.ConfigUpdate
+	8,  // 15: sentinel.Sentinel.ReportConfigStatus:output_type -> sentinel.Ack
+	13, // [13:16] is the sub-list for method output_type
+	10, // [10:13] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_internal_proto_sentinel_proto_init() }
@@ -259,13 +842,14 @@ func file_internal_proto_sentinel_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_proto_sentinel_proto_rawDesc), len(file_internal_proto_sentinel_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   4,
+			NumEnums:      1,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_internal_proto_sentinel_proto_goTypes,
 		DependencyIndexes: file_internal_proto_sentinel_proto_depIdxs,
+		EnumInfos:         file_internal_proto_sentinel_proto_enumTypes,
 		MessageInfos:      file_internal_proto_sentinel_proto_msgTypes,
 	}.Build()
 	File_internal_proto_sentinel_proto = out.File
@@ -19,7 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Sentinel_StreamMetrics_FullMethodName = "/sentinel.Sentinel/StreamMetrics"
+	Sentinel_StreamMetrics_FullMethodName      = "/sentinel.Sentinel/StreamMetrics"
+	Sentinel_WatchConfig_FullMethodName        = "/sentinel.Sentinel/WatchConfig"
+	Sentinel_ReportConfigStatus_FullMethodName = "/sentinel.Sentinel/ReportConfigStatus"
 )
 
 // SentinelClient is the client API for Sentinel service.
@@ -28,6 +30,11 @@ const (
 type SentinelClient interface {
 	// Agent streams metrics to HQ
 	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[MetricBatch, Ack], error)
+	// Agent receives its centrally managed config: the current one right away,
+	// then every new version for as long as the stream is open
+	WatchConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConfigUpdate], error)
+	// Agent reports which config version it applied, or why it couldn't
+	ReportConfigStatus(ctx context.Context, in *ConfigStatus, opts ...grpc.CallOption) (*Ack, error)
 }
 
 type sentinelClient struct {
@@ -51,12 +58,46 @@ func (c *sentinelClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOpt
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Sentinel_StreamMetricsClient = grpc.ClientStreamingClient[MetricBatch, Ack]
 
+func (c *sentinelClient) WatchConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConfigUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sentinel_ServiceDesc.Streams[1], Sentinel_WatchConfig_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConfigRequest, ConfigUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sentinel_WatchConfigClient = grpc.ServerStreamingClient[ConfigUpdate]
+
+func (c *sentinelClient) ReportConfigStatus(ctx context.Context, in *ConfigStatus, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, Sentinel_ReportConfigStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SentinelServer is the server API for Sentinel service.
 // All implementations must embed UnimplementedSentinelServer
 // for forward compatibility.
 type SentinelServer interface {
 	// Agent streams metrics to HQ
 	StreamMetrics(grpc.ClientStreamingServer[MetricBatch, Ack]) error
+	// Agent receives its centrally managed config: the current one right away,
+	// then every new version for as long as the stream is open
+	WatchConfig(*ConfigRequest, grpc.ServerStreamingServer[ConfigUpdate]) error
+	// Agent reports which config version it applied, or why it couldn't
+	ReportConfigStatus(context.Context, *ConfigStatus) (*Ack, error)
 	mustEmbedUnimplementedSentinelServer()
 }
 
@@ -70,6 +111,12 @@ type UnimplementedSentinelServer struct{}
 func (UnimplementedSentinelServer) StreamMetrics(grpc.ClientStreamingServer[MetricBatch, Ack]) error {
 	return status.Error(codes.Unimplemented, "method StreamMetrics not implemented")
 }
+func (UnimplementedSentinelServer) WatchConfig(*ConfigRequest, grpc.ServerStreamingServer[ConfigUpdate]) error {
+	return status.Error(codes.Unimplemented, "method WatchConfig not implemented")
+}
+func (UnimplementedSentinelServer) ReportConfigStatus(context.Context, *ConfigStatus) (*Ack, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReportConfigStatus not implemented")
+}
 func (UnimplementedSentinelServer) mustEmbedUnimplementedSentinelServer() {}
 func (UnimplementedSentinelServer) testEmbeddedByValue()                  {}
 
@@ -98,19 +145,58 @@ func _Sentinel_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream)
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Sentinel_StreamMetricsServer = grpc.ClientStreamingServer[MetricBatch, Ack]
 
+func _Sentinel_WatchConfig_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConfigRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SentinelServer).WatchConfig(m, &grpc.GenericServerStream[ConfigRequest, ConfigUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sentinel_WatchConfigServer = grpc.ServerStreamingServer[ConfigUpdate]
+
+func _Sentinel_ReportConfigStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfigStatus)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SentinelServer).ReportConfigStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sentinel_ReportConfigStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SentinelServer).ReportConfigStatus(ctx, req.(*ConfigStatus))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Sentinel_ServiceDesc is the grpc.ServiceDesc for Sentinel service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Sentinel_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "sentinel.Sentinel",
 	HandlerType: (*SentinelServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReportConfigStatus",
+			Handler:    _Sentinel_ReportConfigStatus_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "StreamMetrics",
 			Handler:       _Sentinel_StreamMetrics_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "WatchConfig",
+			Handler:       _Sentinel_WatchConfig_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "internal/proto/sentinel.proto",
 }
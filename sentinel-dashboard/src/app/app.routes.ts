@@ -1,8 +1,10 @@
 import { Routes } from '@angular/router';
 import { Dashboard } from './components/dashboard/dashboard';
 import { ServerDetails } from './components/server-details/server-details';
+import { Login } from './components/login/login';
 
 export const routes: Routes = [
      { path: '', component: Dashboard },
      { path: 'server/:id', component: ServerDetails },
+     { path: 'login', component: Login },
 ];
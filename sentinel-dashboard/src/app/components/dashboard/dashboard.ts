@@ -1,7 +1,11 @@
-import { ChangeDetectorRef, Component, OnInit } from '@angular/core';
-import { SentinelService, ServerStatus } from '../../services/sentinel.service';
-import { RouterLink } from '@angular/router';
+import { ChangeDetectorRef, Component, OnDestroy, OnInit } from '@angular/core';
+import { Metric, SentinelService, ServerStatus } from '../../services/sentinel.service';
+import { Router, RouterLink } from '@angular/router';
 import { CommonModule } from '@angular/common';
+import { AuthService } from '../../services/auth.service';
+
+// The metrics shown on each server card.
+const overviewMetricTypes = ['cpu_usage', 'memory_used_percent'];
 
 @Component({
   selector: 'app-dashboard',
@@ -9,15 +13,22 @@ import { CommonModule } from '@angular/common';
   templateUrl: './dashboard.html',
   styleUrl: './dashboard.css',
 })
-export class Dashboard implements OnInit {
+export class Dashboard implements OnInit, OnDestroy {
     servers: ServerStatus[] = [];
+    // The latest overview metrics of each server, by server_id and metric type.
+    latest: Record<string, Record<string, Metric>> = {};
+    private timer?: ReturnType<typeof setInterval>;
     errorMessage: string = '';
 
-    constructor(private sentinel: SentinelService, private cdr: ChangeDetectorRef) { }
+    constructor(private sentinel: SentinelService, public auth: AuthService, private router: Router, private cdr: ChangeDetectorRef) { }
 
     ngOnInit() {
         this.refreshServers();
-        setInterval(() => this.refreshServers(), 5000);
+        this.timer = setInterval(() => this.refreshServers(), 5000);
+    }
+
+    ngOnDestroy() {
+        clearInterval(this.timer);
     }
 
     refreshServers() {
@@ -36,6 +47,23 @@ export class Dashboard implements OnInit {
                 this.cdr.detectChanges();
             }
         });
+
+        this.sentinel.getLatest(overviewMetricTypes).subscribe({
+            next: (metrics) => {
+                const latest: Record<string, Record<string, Metric>> = {};
+                for (const m of metrics || []) {
+                    (latest[m.server_id] ??= {})[m.metric_type] = m;
+                }
+                this.latest = latest;
+                this.cdr.detectChanges();
+            },
+            error: (err: any) => console.error("Latest metrics error:", err),
+        });
+    }
+
+    logout() {
+        this.auth.logout();
+        this.router.navigate(['/login']);
     }
 
     isAlive(lastSeen: string): boolean {
@@ -0,0 +1,37 @@
+import { ChangeDetectorRef, Component } from '@angular/core';
+import { FormsModule } from '@angular/forms';
+import { ActivatedRoute, Router } from '@angular/router';
+import { AuthService } from '../../services/auth.service';
+
+@Component({
+  selector: 'app-login',
+  imports: [FormsModule],
+  templateUrl: './login.html',
+  styleUrl: './login.css',
+})
+export class Login {
+  username: string = '';
+  password: string = '';
+  errorMessage: string = '';
+  busy = false;
+
+  constructor(private auth: AuthService, private route: ActivatedRoute, private router: Router, private cdr: ChangeDetectorRef) { }
+
+  login() {
+    this.busy = true;
+    this.errorMessage = '';
+    this.auth.login(this.username, this.password).subscribe({
+      next: () => {
+        const returnUrl = this.route.snapshot.queryParamMap.get('returnUrl') || '/';
+        this.router.navigateByUrl(returnUrl);
+      },
+      error: (err: any) => {
+        this.busy = false;
+        this.errorMessage = err.status === 401
+          ? 'Invalid username or password'
+          : `Failed to log in: ${err.error?.error || err.message || 'Unknown error'}`;
+        this.cdr.detectChanges();
+      }
+    });
+  }
+}
@@ -1,9 +1,14 @@
-import { ChangeDetectorRef, Component, OnInit } from '@angular/core';
+import { ChangeDetectorRef, Component, OnDestroy, OnInit } from '@angular/core';
 import { ActivatedRoute, RouterLink } from '@angular/router';
-import { Metric, SentinelService, ServiceStatus } from '../../services/sentinel.service';
+import { Metric, SentinelEvent, SentinelService, ServiceStatus } from '../../services/sentinel.service';
 import { ChartConfiguration, ChartOptions } from 'chart.js';
 import { CommonModule } from '@angular/common';
 import { BaseChartDirective, provideCharts, withDefaultRegisterables } from 'ng2-charts';
+import { Subscription } from 'rxjs';
+
+// The metrics drawn on the chart, and how many of them it keeps.
+const chartMetricTypes = ['cpu_usage', 'memory_total_mb'];
+const maxChartMetrics = 100;
 
 @Component({
   selector: 'app-server-details',
@@ -12,9 +17,15 @@ import { BaseChartDirective, provideCharts, withDefaultRegisterables } from 'ng2
   templateUrl: './server-details.html',
   styleUrl: './server-details.css',
 })
-export class ServerDetails implements OnInit {
+export class ServerDetails implements OnInit, OnDestroy {
   serverId: string = '';
   services: ServiceStatus[] = [];
+  events: SentinelEvent[] = [];
+
+  // Newest first, like /metrics returns them.
+  private metrics: Metric[] = [];
+  private live?: Subscription;
+  private timer?: ReturnType<typeof setInterval>;
 
   public lineChartData: ChartConfiguration<'line'>['data'] = {
     labels: [],
@@ -77,20 +88,52 @@ export class ServerDetails implements OnInit {
 
   ngOnInit() {
     this.serverId = this.route.snapshot.paramMap.get('id') || '';
+    this.loadMetrics();
     this.refreshData();
-    setInterval(() => this.refreshData(), 5000);
+
+    // The chart follows the live stream; services and events are polled.
+    this.live = this.sentinel.live(this.serverId, chartMetricTypes).subscribe({
+      next: event => {
+        if (event.type === 'metrics') {
+          this.addMetrics(event.update.metrics);
+        } else {
+          this.loadMetrics();
+        }
+      },
+      error: (err: any) => console.error("Live stream error:", err),
+    });
+    this.timer = setInterval(() => this.refreshData(), 5000);
   }
-  refreshData() {
+
+  ngOnDestroy() {
+    this.live?.unsubscribe();
+    clearInterval(this.timer);
+  }
+
+  loadMetrics() {
     this.sentinel.getMetrics(this.serverId).subscribe(metrics => {
-      this.updateChart(metrics);
-      console.log("Metrics: ", metrics);
+      this.metrics = (metrics || []).filter(m => chartMetricTypes.includes(m.metric_type));
+      this.updateChart(this.metrics);
       this.cdr.detectChanges();
     });
+  }
+
+  addMetrics(metrics: Metric[]) {
+    this.metrics = [...metrics, ...this.metrics].slice(0, maxChartMetrics);
+    this.updateChart(this.metrics);
+    this.cdr.detectChanges();
+  }
 
+  refreshData() {
     this.sentinel.getServiceStatus(this.serverId).subscribe(services => {
       this.services = services || [];
       this.cdr.detectChanges();
     });
+
+    this.sentinel.getEvents(this.serverId).subscribe(events => {
+      this.events = events || [];
+      this.cdr.detectChanges();
+    });
   }
 
   updateChart(metrics: Metric[]) {
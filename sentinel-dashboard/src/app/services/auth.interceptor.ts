@@ -0,0 +1,33 @@
+import { HttpErrorResponse, HttpInterceptorFn } from '@angular/common/http';
+import { inject } from '@angular/core';
+import { Router } from '@angular/router';
+import { catchError, throwError } from 'rxjs';
+import { AuthService } from './auth.service';
+import { apiUrl } from './sentinel.service';
+
+// Sends the session token with requests to HQ. When HQ answers 401 the
+// token is missing or has expired, so the user is sent to log in and brought
+// back afterwards. Without auth.enabled HQ never answers 401 and no login is
+// needed.
+export const authInterceptor: HttpInterceptorFn = (req, next) => {
+  if (!req.url.startsWith(apiUrl)) {
+    return next(req);
+  }
+  const auth = inject(AuthService);
+  const router = inject(Router);
+  const token = auth.token;
+  if (token) {
+    req = req.clone({ setHeaders: { Authorization: `Bearer ${token}` } });
+  }
+  return next(req).pipe(
+    catchError((err: HttpErrorResponse) => {
+      if (err.status === 401 && !req.url.endsWith('/auth/login')) {
+        auth.forget();
+        if (!router.url.startsWith('/login')) {
+          router.navigate(['/login'], { queryParams: { returnUrl: router.url } });
+        }
+      }
+      return throwError(() => err);
+    }),
+  );
+};
@@ -0,0 +1,45 @@
+import { HttpClient } from '@angular/common/http';
+import { Injectable } from '@angular/core';
+import { Observable, map } from 'rxjs';
+import { apiUrl } from './sentinel.service';
+
+// Where the session token is kept, so a reload doesn't log the user out.
+const tokenKey = 'sentinel_token';
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string;
+  user: { username: string; role: string };
+}
+
+@Injectable({
+  providedIn: 'root',
+})
+export class AuthService {
+  constructor(private http: HttpClient) { }
+
+  get token(): string | null {
+    return localStorage.getItem(tokenKey);
+  }
+
+  // Exchanges a username and password for a session token, which the
+  // auth interceptor then sends with every request to HQ.
+  login(username: string, password: string): Observable<void> {
+    return this.http.post<LoginResponse>(`${apiUrl}/auth/login`, { username, password }).pipe(
+      map(res => localStorage.setItem(tokenKey, res.token)),
+    );
+  }
+
+  logout() {
+    if (this.token) {
+      // Ends the session in HQ too; the token is forgotten either way.
+      this.http.post(`${apiUrl}/auth/logout`, null).subscribe({ error: () => { } });
+    }
+    this.forget();
+  }
+
+  // Drops the token without telling HQ, e.g. once HQ has rejected it.
+  forget() {
+    localStorage.removeItem(tokenKey);
+  }
+}
@@ -1,11 +1,19 @@
-import { HttpClient } from '@angular/common/http';
+import { HttpClient, HttpErrorResponse } from '@angular/common/http';
 import { Injectable } from '@angular/core';
-import { Observable } from 'rxjs';
+import { Observable, Subscription } from 'rxjs';
+
+// Where HQ's REST API is.
+export const apiUrl = 'http://localhost:8080';
+
+// How long live() waits before reopening a dropped stream.
+const liveRetryDelay = 5000;
 
 export interface ServerStatus {
   server_id: string;
   last_seen: string;
   ip_address: string;
+  machine_id?: string;
+  conflict?: boolean;
 }
 
 export interface Metric {
@@ -20,14 +28,37 @@ export interface Metric {
 export interface ServiceStatus {
   service_name: string;
   status: number;
+  state: 'up' | 'down' | 'unknown';
   last_seen: string;
+  last_change: string;
+}
+
+export interface LiveUpdate {
+  server_id: string;
+  time: string;
+  metrics: Metric[];
+}
+
+// What a live stream delivers: the metrics of a batch, or a 'resync' when
+// batches may have been missed (the client fell behind or reconnected) and
+// what is shown should be fetched again.
+export type LiveEvent = { type: 'metrics'; update: LiveUpdate } | { type: 'resync' };
+
+export interface SentinelEvent {
+  id: number;
+  time: string;
+  server_id: string;
+  severity: 'debug' | 'info' | 'warning' | 'error' | 'critical';
+  source: string;
+  message: string;
+  attributes: Record<string, string>;
 }
 
 @Injectable({
   providedIn: 'root',
 })
 export class SentinelService {
-  private apiUrl = 'http://localhost:8080';
+  private apiUrl = apiUrl;
 
   constructor(private http: HttpClient) { }
 
@@ -39,7 +70,78 @@ export class SentinelService {
     return this.http.get<Metric[]>(`${this.apiUrl}/metrics/${serverId}`);
   }
 
+  // The current value of every series across servers in one call, from
+  // HQ's in-memory cache. metricTypes narrows it down, e.g. ['cpu_usage'].
+  getLatest(metricTypes: string[] = []): Observable<Metric[]> {
+    const params: Record<string, string> = metricTypes.length ? { metric: metricTypes.join(',') } : {};
+    return this.http.get<Metric[]>(`${this.apiUrl}/latest`, { params });
+  }
+
   getServiceStatus(serverId: string): Observable<ServiceStatus[]> {
     return this.http.get<ServiceStatus[]>(`${this.apiUrl}/servers/${serverId}/services`);
   }
+
+  getEvents(serverId: string, limit = 20): Observable<SentinelEvent[]> {
+    return this.http.get<SentinelEvent[]>(`${this.apiUrl}/servers/${serverId}/events`, { params: { limit } });
+  }
+
+  // Streams a server's metrics as HQ receives them, from /live/:server_id.
+  // metricTypes narrows it down, e.g. ['cpu_usage']. Unsubscribing closes
+  // the stream.
+  //
+  // EventSource can't send the Authorization header, so each connection
+  // first gets a short-lived token from /live/token and passes it as
+  // ?token=. The token only opens the stream within a minute, so when the
+  // connection drops it is reopened with a new token rather than left to
+  // EventSource's own retries.
+  live(serverId: string, metricTypes: string[] = []): Observable<LiveEvent> {
+    return new Observable<LiveEvent>(subscriber => {
+      let source: EventSource | undefined;
+      let tokenRequest: Subscription | undefined;
+      let retry: ReturnType<typeof setTimeout> | undefined;
+      let opened = false;
+
+      const reconnect = () => {
+        source?.close();
+        retry = setTimeout(connect, liveRetryDelay);
+      };
+      const connect = () => {
+        tokenRequest = this.http.post<{ token: string }>(`${this.apiUrl}/live/token`, null).subscribe({
+          next: ({ token }) => {
+            const params = new URLSearchParams({ token });
+            if (metricTypes.length) {
+              params.set('metric', metricTypes.join(','));
+            }
+            source = new EventSource(`${this.apiUrl}/live/${encodeURIComponent(serverId)}?${params}`);
+            source.onopen = () => {
+              if (opened) {
+                subscriber.next({ type: 'resync' });
+              }
+              opened = true;
+            };
+            source.addEventListener('metrics', e => {
+              subscriber.next({ type: 'metrics', update: JSON.parse((e as MessageEvent).data) });
+            });
+            source.addEventListener('dropped', () => subscriber.next({ type: 'resync' }));
+            source.onerror = reconnect;
+          },
+          error: (err: HttpErrorResponse) => {
+            // Not logged in or not allowed: retrying won't help.
+            if (err.status === 401 || err.status === 403) {
+              subscriber.error(err);
+            } else {
+              reconnect();
+            }
+          },
+        });
+      };
+
+      connect();
+      return () => {
+        tokenRequest?.unsubscribe();
+        clearTimeout(retry);
+        source?.close();
+      };
+    });
+  }
 }